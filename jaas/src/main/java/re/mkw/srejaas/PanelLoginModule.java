@@ -195,7 +195,8 @@ public class PanelLoginModule implements LoginModule {
   /**
    * Retrieves the password hash and salt from the database.
    *
-   * @throws LoginException Database error, username doesn't exist, or user didn't set password yet.
+   * @throws LoginException Database error, username doesn't exist, user didn't set password yet, or
+   *     user was disabled.
    */
   private void getPasswordHash() throws LoginException {
     Connection dbConn = null;
@@ -206,7 +207,9 @@ public class PanelLoginModule implements LoginModule {
 
       stmt =
           dbConn.prepareStatement(
-              "SELECT salt, hash FROM passwords WHERE username = ? AND format = 1");
+              "SELECT salt, hash FROM passwords WHERE username = ? AND format = 1"
+                  + " AND id NOT IN"
+                  + " (SELECT user_id FROM user_status WHERE disabled_at IS NOT NULL)");
       stmt.setString(1, this.username);
 
       rs = stmt.executeQuery();
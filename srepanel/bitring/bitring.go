@@ -1,45 +1,82 @@
 // Package bitring provides a ring buffer bitmap
+//
+// A BitRing is a sliding replay window over sequence numbers.
+// Advance issues a new sequence number, Insert marks one as used.
+// Only the most recent sequence numbers, up to the size of the ring,
+// are remembered; older ones are reported as forgotten (ok == false)
+// and must be rejected by callers.
+//
+// All methods are safe for concurrent use. Races between Advance and
+// Insert/Contains near the edge of the window may report a sequence
+// number as forgotten or used, but never report a used one as unused.
 package bitring
 
 import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"math/bits"
 	"sync/atomic"
 )
 
 // It is depressing that Go does not provides memory fences.
 
+var (
+	// ErrForgotten is returned for sequence numbers outside the window.
+	ErrForgotten = errors.New("sequence number outside replay window")
+	// ErrReused is returned for sequence numbers used before.
+	ErrReused = errors.New("sequence number reused")
+)
+
 // BitRing provides a bitmap spanning a ring buffer.
 type BitRing struct {
-	n    atomic.Uint64
+	n    atomic.Uint64 // last issued sequence number
 	mask uint64
 	v    []atomic.Uint64
 }
 
-func NewBitRing(sz uint64) BitRing {
-	mask := (1 << bits.LeadingZeros64(sz)) - 1
-	mask |= 0x3f
-	return BitRing{
-		mask: sz,
-		v:    make([]atomic.Uint64, (sz+1)/64),
+// NewBitRing creates a ring remembering at least sz sequence numbers.
+// The size is rounded up to a power of two of at least 64.
+func NewBitRing(sz uint64) *BitRing {
+	if sz < 64 {
+		sz = 64
+	}
+	mask := uint64(1)<<(64-bits.LeadingZeros64(sz-1)) - 1
+	return &BitRing{
+		mask: mask,
+		v:    make([]atomic.Uint64, (mask+1)/64),
 	}
 }
 
-// fits returns whether index n is within the ring buffer.
+// Size returns how many sequence numbers the ring remembers.
+func (r *BitRing) Size() uint64 {
+	return r.mask + 1
+}
+
+// fits returns whether sequence number n was issued and is within the window.
 func (r *BitRing) fits(n uint64) bool {
-	return r.n.Load()-n <= r.mask
+	cur := r.n.Load()
+	return n != 0 && n <= cur && cur-n <= r.mask
 }
 
+// Contains returns whether sequence number n was used.
 func (r *BitRing) Contains(n uint64) (exist, ok bool) {
 	if !r.fits(n) {
 		return false, false
 	}
 	idx := n & r.mask
-	return (r.v[idx/64].Load() & (idx % 64)) != 0, true
+	exist = (r.v[idx/64].Load() & (1 << (idx % 64))) != 0
+	// the slot may have been recycled meanwhile
+	if !r.fits(n) {
+		return false, false
+	}
+	return exist, true
 }
 
+// Advance issues a new sequence number. Sequence numbers start at 1.
 func (r *BitRing) Advance() uint64 {
 	n := r.n.Add(1)
-	// clear bit
+	// clear bit of the sequence number that left the window
 	idx := n & r.mask
 	for {
 		vOld := r.v[idx/64].Load()
@@ -51,6 +88,7 @@ func (r *BitRing) Advance() uint64 {
 	return n
 }
 
+// Insert marks sequence number n as used and returns whether it was used before.
 func (r *BitRing) Insert(n uint64) (exist, ok bool) {
 	if !r.fits(n) {
 		return false, false
@@ -64,5 +102,41 @@ func (r *BitRing) Insert(n uint64) (exist, ok bool) {
 			break
 		}
 	}
+	// the slot may have been recycled between the check and the insert
+	if !r.fits(n) {
+		return false, false
+	}
 	return (vOld & (1 << (idx % 64))) != 0, true
 }
+
+// Use marks sequence number n as used. It fails if n is outside the window
+// or was used before, so each sequence number is accepted at most once.
+func (r *BitRing) Use(n uint64) error {
+	exist, ok := r.Insert(n)
+	if !ok {
+		return ErrForgotten
+	}
+	if exist {
+		return ErrReused
+	}
+	return nil
+}
+
+// FormatID encodes a sequence number as an opaque string ID, e.g. a JWT ID.
+func FormatID(n uint64) string {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], n)
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// ParseID decodes a string ID created by FormatID.
+func ParseID(id string) (uint64, error) {
+	var buf [8]byte
+	if len(id) != base64.RawURLEncoding.EncodedLen(len(buf)) {
+		return 0, errors.New("malformed ID")
+	}
+	if _, err := base64.RawURLEncoding.Decode(buf[:], []byte(id)); err != nil {
+		return 0, errors.New("malformed ID")
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
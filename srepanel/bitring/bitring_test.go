@@ -0,0 +1,171 @@
+package bitring
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewBitRingSize(t *testing.T) {
+	tests := []struct {
+		sz, want uint64
+	}{
+		{0, 64},
+		{1, 64},
+		{64, 64},
+		{65, 128},
+		{1000, 1024},
+		{65536, 65536},
+	}
+	for _, tt := range tests {
+		if got := NewBitRing(tt.sz).Size(); got != tt.want {
+			t.Errorf("NewBitRing(%d).Size() = %d, want %d", tt.sz, got, tt.want)
+		}
+	}
+}
+
+func TestUse(t *testing.T) {
+	tests := []struct {
+		name    string
+		advance int      // sequence numbers issued before
+		used    []uint64 // sequence numbers used before
+		n       uint64
+		err     error
+	}{
+		{"fresh", 1, nil, 1, nil},
+		{"reused", 1, []uint64{1}, 1, ErrReused},
+		{"other used", 2, []uint64{1}, 2, nil},
+		{"zero", 1, nil, 0, ErrForgotten},
+		{"not issued", 1, nil, 2, ErrForgotten},
+		{"oldest in window", 64, nil, 1, nil},
+		{"left window", 65, nil, 1, ErrForgotten},
+		{"used and left window", 65, []uint64{1}, 1, ErrForgotten},
+		{"recycled slot", 65, []uint64{1}, 65, nil},
+		{"far past", 1000, nil, 900, ErrForgotten},
+		{"far past in window", 1000, nil, 937, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewBitRing(64)
+			for i := 0; i < tt.advance; i++ {
+				n := r.Advance()
+				if n != uint64(i+1) {
+					t.Fatalf("Advance() = %d, want %d", n, i+1)
+				}
+				for _, used := range tt.used {
+					if used == n {
+						if err := r.Use(n); err != nil {
+							t.Fatalf("Use(%d) = %v", n, err)
+						}
+					}
+				}
+			}
+			if err := r.Use(tt.n); err != tt.err {
+				t.Fatalf("Use(%d) = %v, want %v", tt.n, err, tt.err)
+			}
+			exist, ok := r.Contains(tt.n)
+			if ok != (tt.err != ErrForgotten) || (ok && !exist) {
+				t.Errorf("Contains(%d) = %v, %v after use", tt.n, exist, ok)
+			}
+		})
+	}
+}
+
+// Each sequence number must be accepted at most once, however many
+// goroutines race to use it while others advance the ring.
+func TestConcurrentUse(t *testing.T) {
+	const (
+		issuers   = 4
+		consumers = 4
+		perIssuer = 2000
+	)
+	r := NewBitRing(256)
+	accepted := make([]atomic.Int32, issuers*perIssuer+1)
+	issued := make(chan uint64, 64)
+
+	var wg sync.WaitGroup
+	for i := 0; i < issuers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perIssuer; j++ {
+				issued <- r.Advance()
+			}
+		}()
+	}
+	var consumed sync.WaitGroup
+	for i := 0; i < consumers; i++ {
+		consumed.Add(1)
+		go func() {
+			defer consumed.Done()
+			for n := range issued {
+				// Every consumer tries twice, as a replaying attacker would
+				for k := 0; k < 2; k++ {
+					if r.Use(n) == nil {
+						accepted[n].Add(1)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(issued)
+	consumed.Wait()
+
+	for n := range accepted {
+		if got := accepted[n].Load(); got > 1 {
+			t.Fatalf("sequence number %d accepted %d times", n, got)
+		}
+	}
+}
+
+func TestID(t *testing.T) {
+	for _, n := range []uint64{0, 1, 1 << 32, ^uint64(0)} {
+		id := FormatID(n)
+		if got, err := ParseID(id); err != nil || got != n {
+			t.Errorf("ParseID(%q) = %d, %v, want %d", id, got, err, n)
+		}
+	}
+	for _, id := range []string{"", "AAAAAAAAAA", "AAAAAAAAAAAA", "AAAAAAAAAA!"} {
+		if _, err := ParseID(id); err == nil {
+			t.Errorf("ParseID(%q) accepted a malformed ID", id)
+		}
+	}
+}
+
+// FuzzBitRing compares the ring against a map remembering everything.
+// Each byte advances the ring (high bit set) or uses a recent sequence number.
+func FuzzBitRing(f *testing.F) {
+	f.Add([]byte{0x80, 0x00, 0x00})
+	f.Add([]byte{0x80, 0x80, 0x01, 0x00, 0x41, 0x7f})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		r := NewBitRing(64)
+		used := make(map[uint64]bool)
+		var cur uint64
+		for _, op := range ops {
+			if op&0x80 != 0 {
+				for i := 0; i <= int(op&0x7f); i++ {
+					cur++
+					if n := r.Advance(); n != cur {
+						t.Fatalf("Advance() = %d, want %d", n, cur)
+					}
+				}
+				continue
+			}
+			n := cur - uint64(op) // may wrap to large, unissued numbers
+			want := error(nil)
+			switch {
+			case n == 0 || n > cur || cur-n >= r.Size():
+				want = ErrForgotten
+			case used[n]:
+				want = ErrReused
+			}
+			if err := r.Use(n); err != want {
+				t.Fatalf("Use(%d) at %d = %v, want %v", n, cur, err, want)
+			}
+			if want == nil {
+				used[n] = true
+			}
+		}
+	})
+}
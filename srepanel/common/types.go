@@ -1,9 +1,36 @@
 package common
 
+import "time"
+
 type Identity struct {
 	ID         uint64 `json:"id"`
+	Provider   string `json:"provider,omitempty"` // identity provider ID, e.g. "discord"
+	Subject    string `json:"subject,omitempty"`  // user ID at identity provider
 	Username   string `json:"username"`
-	AvatarHash string `json:"avatar"`
+	GlobalName string `json:"global_name,omitempty"`
+	AvatarHash string `json:"avatar"`               // Discord avatar hash
+	AvatarURL  string `json:"avatar_url,omitempty"` // avatar of non-Discord identities
+
+	// GuildRoles maps Discord guilds the user is a member of to their role IDs.
+	// Only known during login, never persisted in sessions.
+	GuildRoles map[string][]string `json:"-"`
+}
+
+// DisplayName returns the name to greet the user with.
+// Falls back to the unique username if no global name is set.
+func (i *Identity) DisplayName() string {
+	if i.GlobalName != "" {
+		return i.GlobalName
+	}
+	return i.Username
+}
+
+// LinkedIdentity is an external account that can be used to log into a panel account.
+type LinkedIdentity struct {
+	Provider string
+	Subject  string
+	Username string
+	Primary  bool // profile is synced from this identity
 }
 
 type GhidraEndpoint struct {
@@ -12,7 +39,22 @@ type GhidraEndpoint struct {
 }
 
 type UserState struct {
-	HasPassword bool
+	IsAdmin        bool   `json:"is_admin"`
+	HasPassword    bool   `json:"has_password"`
+	GhidraUsername string `json:"ghidra_username"` // username of the Ghidra account, if any
+	RenamePending  bool   `json:"rename_pending"`  // Ghidra username no longer matches Discord username
+	TOTPEnabled    bool   `json:"totp_enabled"`    // two-factor authentication is set up
+}
+
+// Session is a logged-in device of a user.
+type Session struct {
+	ID         string
+	Device     string // user agent
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	Current    bool // session of the current request
 }
 
 type Link struct {
@@ -21,6 +63,16 @@ type Link struct {
 }
 
 type UserRepoAccess struct {
-	Repo string
-	Perm string
+	Repo string `json:"repo"`
+	Perm string `json:"perm"`
+}
+
+// APIToken is a personal access token of a user, without its secret.
+type APIToken struct {
+	ID         string
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time // nil if never used
 }
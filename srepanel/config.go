@@ -1,9 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/ghidra"
+	"go.mkw.re/ghidra-panel/oidc"
+	"go.mkw.re/ghidra-panel/passhash"
+	"go.mkw.re/ghidra-panel/ratelimit"
+	"go.mkw.re/ghidra-panel/token"
+	"go.mkw.re/ghidra-panel/web"
 )
 
 type config struct {
@@ -14,23 +33,481 @@ type config struct {
 		// TODO allow for more general webhooks
 		// For now, we assume that the webhook is for Discord
 		WebhookURL string `json:"webhook_url"`
+		// APIBase overrides the Discord API base URL, for testing.
+		APIBase string `json:"api_base_url"`
+		// RequiredGuilds restricts login to members of at least one of these guilds.
+		RequiredGuilds []string `json:"required_guilds"`
+		// GuildInviteURL is shown to users refused for not being a guild member.
+		GuildInviteURL string `json:"guild_invite_url"`
+		// BotToken enables periodic re-validation of required guild membership.
+		BotToken string `json:"bot_token"`
+		// MembershipCheckInterval is how often guild membership is re-validated.
+		MembershipCheckInterval duration `json:"membership_check_interval"`
+		// DeprovisionGracePeriod is how long users may be outside the required
+		// guilds before they are disabled and removed from all ACLs.
+		DeprovisionGracePeriod duration `json:"deprovision_grace_period"`
+		// MaxDeprovisions bounds how many users one membership check
+		// deprovisions, membership.DefaultMaxDeprovisions if zero.
+		MaxDeprovisions int `json:"max_deprovisions_per_check"`
 	} `json:"discord"`
+	// OIDC lists generic OpenID Connect providers offered next to Discord.
+	OIDC   []*oidc.Config `json:"oidc"`
 	Ghidra struct {
 		Endpoint common.GhidraEndpoint `json:"endpoint"`
 		RepoDir  string                `json:"repo_dir"`
+		// ProbeInterval is how often the Ghidra server ports are checked.
+		ProbeInterval duration `json:"probe_interval"`
+		// ProbeTimeout bounds each connection attempt of a check.
+		ProbeTimeout duration `json:"probe_timeout"`
 	} `json:"ghidra"`
 	Links []common.Link `json:"links"`
+	// RoleRules grant repo permissions based on Discord guild roles.
+	RoleRules []web.RoleRule `json:"role_rules"`
+	// RoleSyncDryRun only logs the ACL changes role rules would make.
+	RoleSyncDryRun bool `json:"role_sync_dry_run"`
+	// TrustedProxies lists reverse proxies (CIDR ranges or IPs) allowed to
+	// report the client address in X-Forwarded-For.
+	TrustedProxies []string `json:"trusted_proxies"`
+	Session        struct {
+		// Lifetime is how long a session lasts at most after login.
+		Lifetime duration `json:"lifetime"`
+		// IdleTimeout ends sessions that were not used for this long.
+		IdleTimeout duration `json:"idle_timeout"`
+	} `json:"session"`
+	OAuthState struct {
+		// Validity is how long a login may take at the identity provider.
+		Validity duration `json:"validity"`
+		// ReplayStore is "database" (default), which survives restarts and is
+		// shared by panel instances using the same database file, or "memory",
+		// where logins in flight do not survive restarts.
+		ReplayStore string `json:"replay_store"`
+	} `json:"oauth_state"`
+	// RateLimits override the default rate limits per route,
+	// e.g. {"password": {"every": "1m", "burst": 5}}.
+	RateLimits map[string]rateLimit `json:"rate_limits"`
+	// PasswordHashing bounds the memory used by concurrent password hashes,
+	// about 19 MiB per worker.
+	PasswordHashing struct {
+		// Workers is how many passwords are hashed at once.
+		Workers int `json:"workers"`
+		// QueueDepth is how many password changes may wait for a worker
+		// before further ones are turned away.
+		QueueDepth *int `json:"queue_depth"`
+	} `json:"password_hashing"`
+	Readiness struct {
+		// ACLMaxAge is how old the ACLs may get before /readyz fails.
+		ACLMaxAge duration `json:"acl_max_age"`
+	} `json:"readiness"`
+	Metrics struct {
+		// Enabled serves Prometheus metrics at /metrics of the panel.
+		Enabled bool `json:"enabled"`
+		// Listen serves the metrics on a separate address instead,
+		// e.g. "127.0.0.1:9100", so they need not be exposed publicly.
+		Listen string `json:"listen"`
+	} `json:"metrics"`
+	// TLSCert and TLSKey are PEM files to serve HTTPS with. They are
+	// reloaded on SIGHUP and when they change, e.g. after renewal.
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// TLSRedirectListen redirects plain HTTP on this address to base_url,
+	// e.g. ":80".
+	TLSRedirectListen string `json:"tls_redirect_listen"`
 }
 
-func (c *config) validate() {
+// rateLimit is a token bucket refilling one request every Every, up to Burst.
+type rateLimit struct {
+	Every duration `json:"every"`
+	Burst int      `json:"burst"`
+}
+
+// rateLimits returns the configured rate limits.
+func (c *config) rateLimits() map[string]ratelimit.Rule {
+	rules := make(map[string]ratelimit.Rule, len(c.RateLimits))
+	for route, limit := range c.RateLimits {
+		rules[route] = ratelimit.Rule{Every: time.Duration(limit.Every), Burst: limit.Burst}
+	}
+	return rules
+}
+
+const (
+	replayStoreMemory   = "memory"
+	replayStoreDatabase = "database"
+)
+
+// duration is a time.Duration read from a string such as "6h".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// or returns the duration, or a default if unset.
+func (d duration) or(def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// sessionLifetime returns how long sessions last at most after login.
+func (c *config) sessionLifetime() time.Duration {
+	return c.Session.Lifetime.or(token.DefaultLifetime)
+}
+
+// hasher returns the password hashing pool with the configured limits.
+func (c *config) hasher() *passhash.Pool {
+	workers := c.PasswordHashing.Workers
+	if workers == 0 {
+		workers = passhash.DefaultWorkers
+	}
+	queueDepth := passhash.DefaultQueueDepth
+	if c.PasswordHashing.QueueDepth != nil {
+		queueDepth = *c.PasswordHashing.QueueDepth
+	}
+	return passhash.NewPool(passhash.DefaultParams, workers, queueDepth)
+}
+
+// validate checks the config for mistakes. All problems found are
+// reported together.
+func (c *config) validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if err := checkURL(c.BaseURL, false); err != nil {
+		fail("base_url: %v", err)
+	} else if parsed, _ := url.Parse(c.BaseURL); parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+		fail("base_url: must be an origin without path, e.g. \"https://panel.example.org\"")
+	}
+	ids := map[string]bool{"discord": true}
+	for _, p := range c.OIDC {
+		if p.ID == "" || strings.Trim(p.ID, "abcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+			fail("oidc.id %q must be a non-empty lowercase slug", p.ID)
+		} else if ids[p.ID] {
+			fail("oidc.id %q is not unique", p.ID)
+		}
+		ids[p.ID] = true
+		if err := checkURL(p.Issuer, false); err != nil {
+			fail("oidc %q: issuer: %v", p.ID, err)
+		}
+		if p.ClientID == "" {
+			fail("oidc %q: client_id not set", p.ID)
+		}
+	}
+	if c.Discord.ClientID == "" && len(c.OIDC) == 0 {
+		fail("client_id not set")
+	}
+	if c.Discord.ClientID != "" && c.Discord.ClientSecret == "" {
+		fail("client_secret not set")
+	}
+	if c.Discord.WebhookURL != "" {
+		if err := checkURL(c.Discord.WebhookURL, true); err != nil {
+			fail("discord.webhook_url: %v", err)
+		}
+	}
+	if c.Discord.GuildInviteURL != "" {
+		if err := checkURL(c.Discord.GuildInviteURL, false); err != nil {
+			fail("discord.guild_invite_url: %v", err)
+		}
+	}
+	if c.Discord.APIBase != "" {
+		if err := checkURL(c.Discord.APIBase, false); err != nil {
+			fail("discord.api_base_url: %v", err)
+		}
+	}
+	if c.Discord.BotToken != "" && len(c.Discord.RequiredGuilds) == 0 {
+		fail("bot_token requires required_guilds")
+	}
+	for _, guildID := range c.Discord.RequiredGuilds {
+		if _, err := strconv.ParseUint(guildID, 10, 64); err != nil {
+			fail("required_guilds: invalid guild ID %q", guildID)
+		}
+	}
+	if c.Discord.MaxDeprovisions < 0 {
+		fail("discord.max_deprovisions_per_check must not be negative")
+	}
+	for i, link := range c.Links {
+		if link.Name == "" {
+			fail("links[%d]: name not set", i)
+		}
+		if err := checkURL(link.URL, false); err != nil {
+			fail("links[%d]: url: %v", i, err)
+		}
+	}
+	if endpoint := c.Ghidra.Endpoint; endpoint.Hostname == "" {
+		if endpoint.Port != 0 {
+			fail("ghidra.endpoint: port set without hostname")
+		}
+	} else if endpoint.Port == 0 || endpoint.Port > math.MaxUint16-2 {
+		// The two ports above the configured one are used as well
+		fail("ghidra.endpoint: port must be between 1 and %d", math.MaxUint16-2)
+	}
+	if c.Ghidra.RepoDir != "" {
+		if info, err := os.Stat(c.Ghidra.RepoDir); err != nil {
+			fail("ghidra.repo_dir: %v", err)
+		} else if !info.IsDir() {
+			fail("ghidra.repo_dir: %s is not a directory", c.Ghidra.RepoDir)
+		}
+	}
+	if c.Ghidra.ProbeInterval < 0 || c.Ghidra.ProbeTimeout < 0 {
+		fail("ghidra.probe_interval and ghidra.probe_timeout must not be negative")
+	}
+	for i, rule := range c.RoleRules {
+		if _, err := strconv.ParseUint(rule.Guild, 10, 64); err != nil {
+			fail("role_rules[%d]: invalid guild ID %q", i, rule.Guild)
+		}
+		if _, err := strconv.ParseUint(rule.Role, 10, 64); err != nil {
+			fail("role_rules[%d]: invalid role ID %q", i, rule.Role)
+		}
+		if _, ok := ghidra.ParsePerm(rule.Perm); !ok {
+			fail("role_rules[%d]: invalid perm %q", i, rule.Perm)
+		}
+		if _, err := path.Match(rule.Repos, ""); err != nil {
+			fail("role_rules[%d]: invalid repos pattern %q", i, rule.Repos)
+		}
+	}
+	if _, err := web.ParseTrustedProxies(c.TrustedProxies); err != nil {
+		fail("trusted_proxies: %v", err)
+	}
+	if c.Session.Lifetime < 0 || c.Session.IdleTimeout < 0 {
+		fail("session.lifetime and session.idle_timeout must not be negative")
+	}
+	if c.Session.IdleTimeout != 0 && time.Duration(c.Session.IdleTimeout) > c.sessionLifetime() {
+		fail("session.idle_timeout must not exceed session.lifetime")
+	}
+	if c.OAuthState.Validity < 0 {
+		fail("oauth_state.validity must not be negative")
+	}
+	switch c.OAuthState.ReplayStore {
+	case "", replayStoreMemory, replayStoreDatabase:
+	default:
+		fail("oauth_state.replay_store: unknown store %q", c.OAuthState.ReplayStore)
+	}
+	routes := make([]string, 0, len(c.RateLimits))
+	for route := range c.RateLimits {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	rules := c.rateLimits()
+	for _, route := range routes {
+		if _, ok := web.DefaultRateLimits[route]; !ok {
+			fail("rate_limits: unknown route %q", route)
+		} else if err := rules[route].Validate(); err != nil {
+			fail("rate_limits: %s: %v", route, err)
+		}
+	}
+	if c.PasswordHashing.Workers < 0 {
+		fail("password_hashing.workers must not be negative")
+	}
+	if q := c.PasswordHashing.QueueDepth; q != nil && *q < 0 {
+		fail("password_hashing.queue_depth must not be negative")
+	}
+	if c.Readiness.ACLMaxAge < 0 {
+		fail("readiness.acl_max_age must not be negative")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		fail("tls_cert and tls_key must be set together")
+	}
+	if c.TLSRedirectListen != "" && c.TLSCert == "" {
+		fail("tls_redirect_listen requires tls_cert and tls_key")
+	}
+	return errors.Join(errs...)
+}
+
+// checkURL checks that a URL is absolute with an http or https scheme,
+// or only https if httpsOnly is set.
+func checkURL(raw string, httpsOnly bool) error {
+	if raw == "" {
+		return errors.New("not set")
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	switch {
+	case parsed.Scheme == "https":
+	case parsed.Scheme == "http" && !httpsOnly:
+	case httpsOnly:
+		return fmt.Errorf("%q is not an https URL", raw)
+	default:
+		return fmt.Errorf("%q is not an http or https URL", raw)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
+
+// envPrefix starts the names of environment variables overriding settings.
+const envPrefix = "GHIDRA_PANEL_"
+
+// applyEnv overrides secrets with environment variables, so credentials
+// can be kept out of the config file:
+//
+//	GHIDRA_PANEL_DISCORD_CLIENT_SECRET
+//	GHIDRA_PANEL_DISCORD_BOT_TOKEN
+//	GHIDRA_PANEL_DISCORD_WEBHOOK_URL
+//	GHIDRA_PANEL_OIDC_<ID>_CLIENT_SECRET (ID upper case, "-" as "_")
+//
+// Each variable may instead be set with a _FILE suffix to the path of a
+// file holding the value, e.g. a mounted container secret.
+func (c *config) applyEnv() error {
+	type override struct {
+		name  string // without envPrefix
+		value *string
+	}
+	overrides := []override{
+		{"DISCORD_CLIENT_SECRET", &c.Discord.ClientSecret},
+		{"DISCORD_BOT_TOKEN", &c.Discord.BotToken},
+		{"DISCORD_WEBHOOK_URL", &c.Discord.WebhookURL},
+	}
+	for _, p := range c.OIDC {
+		id := strings.ToUpper(strings.ReplaceAll(p.ID, "-", "_"))
+		overrides = append(overrides, override{"OIDC_" + id + "_CLIENT_SECRET", &p.ClientSecret})
+	}
+
+	var errs []error
+	for _, override := range overrides {
+		value, ok, err := lookupEnv(envPrefix + override.name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			*override.value = value
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lookupEnv reads a variable, or the file named by the variable with a
+// _FILE suffix. Setting both is an error.
+func lookupEnv(name string) (value string, ok bool, err error) {
+	value, ok = os.LookupEnv(name)
+	filePath, fromFile := os.LookupEnv(name + "_FILE")
+	if !fromFile {
+		return value, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("%s and %s_FILE are both set", name, name)
+	}
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(buf), "\r\n"), true, nil
+}
+
+// readConfig reads the config file, rejecting unknown settings,
+// and applies environment overrides.
+func readConfig(filePath string) (*config, error) {
+	buf, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(config)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("failed to parse %s: unexpected data after config", filePath)
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	// URLs are built by appending paths
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	return cfg, nil
+}
+
+// reloadConfig re-reads the config file and applies the settings that
+// can change at runtime to the server. Nothing is applied if the file
+// is invalid. Changes needing a restart are logged.
+func reloadConfig(filePath string, running *config, server *web.Server) (*config, error) {
+	next, err := readConfig(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := next.validate(); err != nil {
+		return nil, err
+	}
+	webConfig := *server.Config()
+	next.setReloadable(&webConfig)
+	server.SetConfig(&webConfig)
+	for _, setting := range next.restartSettings(running) {
+		log.Printf("Config: %s changed, restart to apply", setting)
+	}
+	log.Print("Reloaded config")
+	return next, nil
+}
+
+// setReloadable copies the settings that can change at runtime
+// into the web config. The config must be valid.
+func (c *config) setReloadable(wc *web.Config) {
+	endpoint := c.Ghidra.Endpoint
+	trustedProxies, _ := web.ParseTrustedProxies(c.TrustedProxies)
+	wc.GhidraEndpoint = &endpoint
+	wc.Links = c.Links
+	wc.DiscordWebhookURL = c.Discord.WebhookURL
+	wc.GuildInviteURL = c.Discord.GuildInviteURL
+	wc.RoleRules = c.RoleRules
+	wc.RoleSyncDryRun = c.RoleSyncDryRun
+	wc.TrustedProxies = trustedProxies
+	wc.ACLMaxAge = c.Readiness.ACLMaxAge.or(web.DefaultACLMaxAge)
+}
 
-	if c.Discord.ClientID == "" {
-		log.Fatal("client_id not set")
+// restartSettings lists the settings that differ from the running config
+// but only take effect after a restart.
+func (c *config) restartSettings(running *config) []string {
+	var changed []string
+	check := func(name string, next, prev any) {
+		if !reflect.DeepEqual(next, prev) {
+			changed = append(changed, name)
+		}
 	}
-	if c.Discord.ClientSecret == "" {
-		log.Fatal("client_secret not set")
+	check("base_url", c.BaseURL, running.BaseURL)
+	check("tls_cert", c.TLSCert, running.TLSCert)
+	check("tls_key", c.TLSKey, running.TLSKey)
+	check("tls_redirect_listen", c.TLSRedirectListen, running.TLSRedirectListen)
+	check("discord.client_id", c.Discord.ClientID, running.Discord.ClientID)
+	check("discord.client_secret", c.Discord.ClientSecret, running.Discord.ClientSecret)
+	check("discord.api_base_url", c.Discord.APIBase, running.Discord.APIBase)
+	check("discord.required_guilds", c.Discord.RequiredGuilds, running.Discord.RequiredGuilds)
+	check("discord.bot_token", c.Discord.BotToken, running.Discord.BotToken)
+	check("discord.membership_check_interval", c.Discord.MembershipCheckInterval, running.Discord.MembershipCheckInterval)
+	check("discord.deprovision_grace_period", c.Discord.DeprovisionGracePeriod, running.Discord.DeprovisionGracePeriod)
+	check("discord.max_deprovisions_per_check", c.Discord.MaxDeprovisions, running.Discord.MaxDeprovisions)
+	check("oidc", c.OIDC, running.OIDC)
+	check("ghidra.repo_dir", c.Ghidra.RepoDir, running.Ghidra.RepoDir)
+	// The endpoint is shown right away, but probed at the old address
+	check("ghidra.endpoint (probe)", c.Ghidra.Endpoint, running.Ghidra.Endpoint)
+	check("ghidra.probe_interval", c.Ghidra.ProbeInterval, running.Ghidra.ProbeInterval)
+	check("ghidra.probe_timeout", c.Ghidra.ProbeTimeout, running.Ghidra.ProbeTimeout)
+	// Roles are only fetched from the guilds known at startup
+	known := make(map[string]bool)
+	for _, guild := range web.RoleGuilds(running.RoleRules) {
+		known[guild] = true
 	}
-	if c.BaseURL == "" {
-		log.Fatal("base_url not set")
+	for _, guild := range web.RoleGuilds(c.RoleRules) {
+		if !known[guild] {
+			changed = append(changed, "role_rules (new guilds)")
+			break
+		}
 	}
+	check("session", c.Session, running.Session)
+	check("oauth_state", c.OAuthState, running.OAuthState)
+	check("rate_limits", c.rateLimits(), running.rateLimits())
+	check("password_hashing", c.PasswordHashing, running.PasswordHashing)
+	check("metrics", c.Metrics, running.Metrics)
+	return changed
 }
@@ -0,0 +1,677 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/oidc"
+	"go.mkw.re/ghidra-panel/token"
+	"go.mkw.re/ghidra-panel/web"
+)
+
+// testConfig is a valid config file.
+const testConfig = `{
+	"base_url": "https://panel.example",
+	"discord": {
+		"client_id": "1234",
+		"client_secret": "secret",
+		"webhook_url": "https://discord.example/api/webhooks/1"
+	},
+	"ghidra": {"endpoint": {"hostname": "ghidra.example", "port": 13100}},
+	"links": [{"name": "Wiki", "url": "https://wiki.example"}],
+	"role_rules": [{"guild": "1", "role": "2", "perm": "READ_ONLY", "repos": "*"}]
+}`
+
+// writeConfig writes a config file and returns its path.
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(filePath, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func parseConfig(t *testing.T, data string) *config {
+	t.Helper()
+	cfg, err := readConfig(writeConfig(t, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newReloadServer returns a server running with testConfig.
+func newReloadServer(t *testing.T) (*web.Server, *web.Config) {
+	t.Helper()
+	running := &web.Config{
+		Origin:   "https://panel.example",
+		CSRFKeys: [][]byte{[]byte("key")},
+		Dev:      true,
+	}
+	parseConfig(t, testConfig).setReloadable(running)
+	server := new(web.Server)
+	server.SetConfig(running)
+	return server, running
+}
+
+func TestReloadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string // replaces the file, or removes it if empty
+		err    string
+		check  func(t *testing.T, wc *web.Config)
+	}{
+		{
+			name: "reloadable settings",
+			config: `{
+				"base_url": "https://panel.example",
+				"discord": {
+					"client_id": "1234",
+					"client_secret": "secret",
+					"webhook_url": "https://discord.example/api/webhooks/2",
+					"guild_invite_url": "https://discord.example/invite"
+				},
+				"ghidra": {"endpoint": {"hostname": "ghidra2.example", "port": 13200}},
+				"links": [{"name": "Docs", "url": "https://docs.example"}],
+				"role_rules": [{"guild": "1", "role": "3", "perm": "WRITE", "repos": "re*"}],
+				"role_sync_dry_run": true,
+				"trusted_proxies": ["10.0.0.0/8"],
+				"readiness": {"acl_max_age": "5m"}
+			}`,
+			check: func(t *testing.T, wc *web.Config) {
+				if wc.DiscordWebhookURL != "https://discord.example/api/webhooks/2" {
+					t.Errorf("webhook %q not reloaded", wc.DiscordWebhookURL)
+				}
+				if wc.GuildInviteURL != "https://discord.example/invite" {
+					t.Errorf("guild invite %q not reloaded", wc.GuildInviteURL)
+				}
+				if *wc.GhidraEndpoint != (common.GhidraEndpoint{Hostname: "ghidra2.example", Port: 13200}) {
+					t.Errorf("endpoint %+v not reloaded", *wc.GhidraEndpoint)
+				}
+				if len(wc.Links) != 1 || wc.Links[0].Name != "Docs" {
+					t.Errorf("links %+v not reloaded", wc.Links)
+				}
+				if len(wc.RoleRules) != 1 || wc.RoleRules[0].Role != "3" || !wc.RoleSyncDryRun {
+					t.Errorf("role rules %+v (dry run %v) not reloaded", wc.RoleRules, wc.RoleSyncDryRun)
+				}
+				if len(wc.TrustedProxies) != 1 || wc.TrustedProxies[0].String() != "10.0.0.0/8" {
+					t.Errorf("trusted proxies %v not reloaded", wc.TrustedProxies)
+				}
+				if wc.ACLMaxAge != 5*time.Minute {
+					t.Errorf("ACL max age %s not reloaded", wc.ACLMaxAge)
+				}
+			},
+		},
+		{
+			name: "settings removed",
+			config: `{
+				"base_url": "https://panel.example",
+				"discord": {"client_id": "1234", "client_secret": "secret"}
+			}`,
+			check: func(t *testing.T, wc *web.Config) {
+				if wc.DiscordWebhookURL != "" || wc.Links != nil || wc.RoleRules != nil {
+					t.Errorf("removed settings kept: %+v", wc)
+				}
+				if *wc.GhidraEndpoint != (common.GhidraEndpoint{}) {
+					t.Errorf("endpoint %+v kept", *wc.GhidraEndpoint)
+				}
+				if wc.ACLMaxAge != web.DefaultACLMaxAge {
+					t.Errorf("ACL max age %s, want default %s", wc.ACLMaxAge, web.DefaultACLMaxAge)
+				}
+			},
+		},
+		{
+			name:   "syntax error",
+			config: `{"base_url": "https://panel.example",`,
+			err:    "failed to parse",
+		},
+		{
+			name:   "unknown setting",
+			config: `{"base_url": "https://panel.example", "webhook": "https://discord.example"}`,
+			err:    `unknown field "webhook"`,
+		},
+		{
+			name: "invalid",
+			config: `{
+				"base_url": "https://panel.example",
+				"discord": {"client_id": "1234", "client_secret": "secret", "webhook_url": "http://discord.example"},
+				"trusted_proxies": ["nonsense"]
+			}`,
+			err: "discord.webhook_url",
+		},
+		{
+			name: "file removed",
+			err:  "no such file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, prev := newReloadServer(t)
+			running := parseConfig(t, testConfig)
+			filePath := writeConfig(t, tt.config)
+			if tt.config == "" {
+				if err := os.Remove(filePath); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			next, err := reloadConfig(filePath, running, server)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("reloadConfig() error = %v, want %q", err, tt.err)
+				}
+				// Nothing is applied from a bad file
+				if server.Config() != prev {
+					t.Error("config applied despite error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("reloadConfig() error: ", err)
+			}
+			if next == nil || next == running {
+				t.Fatal("reloadConfig() did not return the new config")
+			}
+			wc := server.Config()
+			if wc == prev {
+				t.Fatal("config not applied")
+			}
+			// Settings only read at startup are kept
+			if wc.Origin != prev.Origin || !reflect.DeepEqual(wc.CSRFKeys, prev.CSRFKeys) || !wc.Dev {
+				t.Errorf("startup settings changed: %+v", wc)
+			}
+			// The running config is replaced, not modified
+			if prev.DiscordWebhookURL != "https://discord.example/api/webhooks/1" || prev.GhidraEndpoint.Hostname != "ghidra.example" {
+				t.Errorf("previous config modified: %+v", prev)
+			}
+			tt.check(t, wc)
+		})
+	}
+}
+
+func TestRestartSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *config)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			change: func(c *config) {},
+		},
+		{
+			name: "reloadable only",
+			change: func(c *config) {
+				c.Discord.WebhookURL = "https://discord.example/api/webhooks/2"
+				c.Links = nil
+				c.TrustedProxies = []string{"10.0.0.1"}
+				c.RoleSyncDryRun = true
+				c.Readiness.ACLMaxAge = duration(time.Minute)
+			},
+		},
+		{
+			name: "role rule in a known guild",
+			change: func(c *config) {
+				c.RoleRules = append(c.RoleRules, web.RoleRule{Guild: "1", Role: "3", Perm: "WRITE", Repos: "*"})
+			},
+		},
+		{
+			name:   "role rule in a new guild",
+			change: func(c *config) { c.RoleRules[0].Guild = "4" },
+			want:   []string{"role_rules (new guilds)"},
+		},
+		{
+			name:   "endpoint",
+			change: func(c *config) { c.Ghidra.Endpoint.Port = 13200 },
+			want:   []string{"ghidra.endpoint (probe)"},
+		},
+		{
+			name: "startup settings",
+			change: func(c *config) {
+				c.BaseURL = "https://panel2.example"
+				c.Discord.ClientSecret = "rotated"
+				c.OIDC = []*oidc.Config{{ID: "corp", Issuer: "https://idp.example", ClientID: "panel"}}
+				c.Session.Lifetime = duration(time.Hour)
+				c.RateLimits = map[string]rateLimit{"login": {Every: duration(time.Second), Burst: 1}}
+				c.Metrics.Enabled = true
+			},
+			want: []string{"base_url", "discord.client_secret", "oidc", "session", "rate_limits", "metrics"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running := parseConfig(t, testConfig)
+			next := parseConfig(t, testConfig)
+			tt.change(next)
+			if got := next.restartSettings(running); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restartSettings() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	repoDir := t.TempDir()
+	repoFile := filepath.Join(repoDir, "file")
+	if err := os.WriteFile(repoFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	intPtr := func(i int) *int { return &i }
+	tests := []struct {
+		name   string
+		change func(c *config)
+		errs   []string // each reported, none if empty
+	}{
+		{
+			name:   "valid",
+			change: func(c *config) {},
+		},
+		{
+			name: "everything set",
+			change: func(c *config) {
+				c.Discord.GuildInviteURL = "https://discord.example/invite"
+				c.Discord.APIBase = "http://127.0.0.1:8080"
+				c.Discord.RequiredGuilds = []string{"1"}
+				c.Discord.BotToken = "bot"
+				c.OIDC = []*oidc.Config{{ID: "corp-sso", Issuer: "https://idp.example", ClientID: "panel"}}
+				c.Ghidra.RepoDir = repoDir
+				c.Ghidra.Endpoint.Port = 65533
+				c.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1"}
+				c.Session.Lifetime = duration(time.Hour)
+				c.Session.IdleTimeout = duration(time.Hour)
+				c.OAuthState.ReplayStore = replayStoreMemory
+				c.RateLimits = map[string]rateLimit{web.LimitLogin: {Every: duration(time.Second), Burst: 1}}
+				c.PasswordHashing.QueueDepth = intPtr(0)
+				c.TLSCert, c.TLSKey, c.TLSRedirectListen = "cert.pem", "key.pem", ":80"
+			},
+		},
+		{
+			name: "OIDC only",
+			change: func(c *config) {
+				c.Discord.ClientID = ""
+				c.OIDC = []*oidc.Config{{ID: "corp", Issuer: "https://idp.example", ClientID: "panel"}}
+			},
+		},
+		{
+			name:   "no base URL",
+			change: func(c *config) { c.BaseURL = "" },
+			errs:   []string{"base_url: not set"},
+		},
+		{
+			name:   "base URL with path",
+			change: func(c *config) { c.BaseURL = "https://panel.example/panel" },
+			errs:   []string{"base_url: must be an origin"},
+		},
+		{
+			name:   "base URL not http",
+			change: func(c *config) { c.BaseURL = "ftp://panel.example" },
+			errs:   []string{"not an http or https URL"},
+		},
+		{
+			name:   "no identity provider",
+			change: func(c *config) { c.Discord.ClientID = "" },
+			errs:   []string{"client_id not set"},
+		},
+		{
+			name:   "no client secret",
+			change: func(c *config) { c.Discord.ClientSecret = "" },
+			errs:   []string{"client_secret not set"},
+		},
+		{
+			name:   "plain HTTP webhook",
+			change: func(c *config) { c.Discord.WebhookURL = "http://discord.example/api/webhooks/1" },
+			errs:   []string{"discord.webhook_url: \"http://discord.example/api/webhooks/1\" is not an https URL"},
+		},
+		{
+			name:   "webhook without host",
+			change: func(c *config) { c.Discord.WebhookURL = "https:///api/webhooks/1" },
+			errs:   []string{"discord.webhook_url", "has no host"},
+		},
+		{
+			name: "guild settings",
+			change: func(c *config) {
+				c.Discord.BotToken = "bot"
+				c.Discord.GuildInviteURL = "discord.gg/invite"
+			},
+			errs: []string{"discord.guild_invite_url", "bot_token requires required_guilds"},
+		},
+		{
+			name:   "invalid guild",
+			change: func(c *config) { c.Discord.RequiredGuilds = []string{"guild"} },
+			errs:   []string{`required_guilds: invalid guild ID "guild"`},
+		},
+		{
+			name:   "negative deprovision limit",
+			change: func(c *config) { c.Discord.MaxDeprovisions = -1 },
+			errs:   []string{"discord.max_deprovisions_per_check must not be negative"},
+		},
+		{
+			name: "OIDC providers",
+			change: func(c *config) {
+				c.OIDC = []*oidc.Config{
+					{ID: "Corp", Issuer: "https://idp.example", ClientID: "panel"},
+					{ID: "discord", Issuer: "https://idp.example", ClientID: "panel"},
+					{ID: "corp", Issuer: "idp.example"},
+				}
+			},
+			errs: []string{
+				`oidc.id "Corp" must be a non-empty lowercase slug`,
+				`oidc.id "discord" is not unique`,
+				`oidc "corp": issuer`,
+				`oidc "corp": client_id not set`,
+			},
+		},
+		{
+			name:   "link",
+			change: func(c *config) { c.Links = append(c.Links, common.Link{URL: "/wiki"}) },
+			errs:   []string{"links[1]: name not set", "links[1]: url"},
+		},
+		{
+			name:   "port without hostname",
+			change: func(c *config) { c.Ghidra.Endpoint.Hostname = "" },
+			errs:   []string{"ghidra.endpoint: port set without hostname"},
+		},
+		{
+			name:   "no port",
+			change: func(c *config) { c.Ghidra.Endpoint.Port = 0 },
+			errs:   []string{"ghidra.endpoint: port must be between 1 and 65533"},
+		},
+		{
+			name:   "derived ports overflow",
+			change: func(c *config) { c.Ghidra.Endpoint.Port = 65534 },
+			errs:   []string{"ghidra.endpoint: port must be between 1 and 65533"},
+		},
+		{
+			name:   "repo dir missing",
+			change: func(c *config) { c.Ghidra.RepoDir = filepath.Join(repoDir, "missing") },
+			errs:   []string{"ghidra.repo_dir", "no such file"},
+		},
+		{
+			name:   "repo dir is a file",
+			change: func(c *config) { c.Ghidra.RepoDir = repoFile },
+			errs:   []string{"is not a directory"},
+		},
+		{
+			name:   "negative probe interval",
+			change: func(c *config) { c.Ghidra.ProbeInterval = -1 },
+			errs:   []string{"ghidra.probe_interval and ghidra.probe_timeout must not be negative"},
+		},
+		{
+			name: "role rule",
+			change: func(c *config) {
+				c.RoleRules = append(c.RoleRules, web.RoleRule{Guild: "g", Role: "r", Perm: "OWNER", Repos: "["})
+			},
+			errs: []string{
+				`role_rules[1]: invalid guild ID "g"`,
+				`role_rules[1]: invalid role ID "r"`,
+				`role_rules[1]: invalid perm "OWNER"`,
+				`role_rules[1]: invalid repos pattern "["`,
+			},
+		},
+		{
+			name:   "trusted proxy",
+			change: func(c *config) { c.TrustedProxies = []string{"proxy.example"} },
+			errs:   []string{"trusted_proxies"},
+		},
+		{
+			name:   "idle timeout beyond default lifetime",
+			change: func(c *config) { c.Session.IdleTimeout = duration(365 * 24 * time.Hour) },
+			errs:   []string{"session.idle_timeout must not exceed session.lifetime"},
+		},
+		{
+			name: "idle timeout beyond lifetime",
+			change: func(c *config) {
+				c.Session.Lifetime = duration(time.Hour)
+				c.Session.IdleTimeout = duration(2 * time.Hour)
+			},
+			errs: []string{"session.idle_timeout must not exceed session.lifetime"},
+		},
+		{
+			name:   "negative session lifetime",
+			change: func(c *config) { c.Session.Lifetime = -1 },
+			errs:   []string{"session.lifetime and session.idle_timeout must not be negative"},
+		},
+		{
+			name: "OAuth state",
+			change: func(c *config) {
+				c.OAuthState.Validity = -1
+				c.OAuthState.ReplayStore = "redis"
+			},
+			errs: []string{"oauth_state.validity must not be negative", `oauth_state.replay_store: unknown store "redis"`},
+		},
+		{
+			name: "rate limits",
+			change: func(c *config) {
+				c.RateLimits = map[string]rateLimit{
+					"signup":              {Every: duration(time.Second), Burst: 1},
+					web.LimitPassword:     {Burst: 1},
+					web.LimitLogin:        {Every: duration(time.Second)},
+					web.LimitSecondFactor: {Every: duration(time.Second), Burst: 3},
+				}
+			},
+			errs: []string{
+				"rate_limits: login: burst must be positive",
+				"rate_limits: password: interval must be positive",
+				`rate_limits: unknown route "signup"`,
+			},
+		},
+		{
+			name: "password hashing",
+			change: func(c *config) {
+				c.PasswordHashing.Workers = -1
+				c.PasswordHashing.QueueDepth = intPtr(-1)
+			},
+			errs: []string{"password_hashing.workers must not be negative", "password_hashing.queue_depth must not be negative"},
+		},
+		{
+			name:   "negative ACL max age",
+			change: func(c *config) { c.Readiness.ACLMaxAge = -1 },
+			errs:   []string{"readiness.acl_max_age must not be negative"},
+		},
+		{
+			name:   "TLS certificate without key",
+			change: func(c *config) { c.TLSCert = "cert.pem" },
+			errs:   []string{"tls_cert and tls_key must be set together"},
+		},
+		{
+			name:   "TLS redirect without TLS",
+			change: func(c *config) { c.TLSRedirectListen = ":80" },
+			errs:   []string{"tls_redirect_listen requires tls_cert and tls_key"},
+		},
+		{
+			name: "all problems together",
+			change: func(c *config) {
+				c.BaseURL = ""
+				c.Discord.ClientSecret = ""
+				c.Readiness.ACLMaxAge = -1
+			},
+			errs: []string{"base_url", "client_secret not set", "readiness.acl_max_age"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := parseConfig(t, testConfig)
+			tt.change(c)
+			err := c.validate()
+			if len(tt.errs) == 0 {
+				if err != nil {
+					t.Fatal("validate() error: ", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validate() succeeded, want %q", tt.errs)
+			}
+			for _, want := range tt.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validate() error %q lacks %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// unsetEnv removes the variables for the duration of the test.
+func unsetEnv(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		for _, name := range []string{name, name + "_FILE"} {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := func(name, content string) string {
+		filePath := filepath.Join(dir, name)
+		if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+	names := []string{
+		"GHIDRA_PANEL_DISCORD_CLIENT_SECRET",
+		"GHIDRA_PANEL_DISCORD_BOT_TOKEN",
+		"GHIDRA_PANEL_DISCORD_WEBHOOK_URL",
+		"GHIDRA_PANEL_OIDC_CORP_SSO_CLIENT_SECRET",
+	}
+	tests := []struct {
+		name string
+		env  map[string]string
+		err  []string
+		want func(c *config) bool
+	}{
+		{
+			name: "nothing set",
+			want: func(c *config) bool {
+				return c.Discord.ClientSecret == "secret" && c.Discord.BotToken == "" && c.OIDC[0].ClientSecret == "from-file"
+			},
+		},
+		{
+			name: "variables",
+			env: map[string]string{
+				"GHIDRA_PANEL_DISCORD_CLIENT_SECRET":       "env-secret",
+				"GHIDRA_PANEL_DISCORD_BOT_TOKEN":           "env-bot",
+				"GHIDRA_PANEL_DISCORD_WEBHOOK_URL":         "https://discord.example/api/webhooks/2",
+				"GHIDRA_PANEL_OIDC_CORP_SSO_CLIENT_SECRET": "env-oidc",
+			},
+			want: func(c *config) bool {
+				return c.Discord.ClientSecret == "env-secret" && c.Discord.BotToken == "env-bot" &&
+					c.Discord.WebhookURL == "https://discord.example/api/webhooks/2" && c.OIDC[0].ClientSecret == "env-oidc"
+			},
+		},
+		{
+			name: "empty variable",
+			env:  map[string]string{"GHIDRA_PANEL_DISCORD_WEBHOOK_URL": ""},
+			want: func(c *config) bool { return c.Discord.WebhookURL == "" },
+		},
+		{
+			name: "files",
+			env: map[string]string{
+				"GHIDRA_PANEL_DISCORD_CLIENT_SECRET_FILE":       secretFile("client_secret", "file-secret\n"),
+				"GHIDRA_PANEL_OIDC_CORP_SSO_CLIENT_SECRET_FILE": secretFile("oidc_secret", "file-oidc\r\n"),
+			},
+			want: func(c *config) bool {
+				return c.Discord.ClientSecret == "file-secret" && c.OIDC[0].ClientSecret == "file-oidc"
+			},
+		},
+		{
+			name: "file and variable",
+			env: map[string]string{
+				"GHIDRA_PANEL_DISCORD_BOT_TOKEN":      "env-bot",
+				"GHIDRA_PANEL_DISCORD_BOT_TOKEN_FILE": secretFile("bot_token", "file-bot"),
+			},
+			err: []string{"GHIDRA_PANEL_DISCORD_BOT_TOKEN and GHIDRA_PANEL_DISCORD_BOT_TOKEN_FILE are both set"},
+		},
+		{
+			name: "missing files",
+			env: map[string]string{
+				"GHIDRA_PANEL_DISCORD_CLIENT_SECRET_FILE": filepath.Join(dir, "missing"),
+				"GHIDRA_PANEL_DISCORD_BOT_TOKEN_FILE":     filepath.Join(dir, "missing"),
+			},
+			err: []string{"GHIDRA_PANEL_DISCORD_CLIENT_SECRET_FILE: open", "GHIDRA_PANEL_DISCORD_BOT_TOKEN_FILE: open"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unsetEnv(t, names...)
+			c := parseConfig(t, testConfig)
+			c.OIDC = []*oidc.Config{{ID: "corp-sso", ClientSecret: "from-file"}}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			err := c.applyEnv()
+			if len(tt.err) != 0 {
+				if err == nil {
+					t.Fatalf("applyEnv() succeeded, want %q", tt.err)
+				}
+				for _, want := range tt.err {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("applyEnv() error %q lacks %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("applyEnv() error: ", err)
+			}
+			if !tt.want(c) {
+				t.Errorf("overrides not applied: %+v, OIDC secret %q", c.Discord, c.OIDC[0].ClientSecret)
+			}
+		})
+	}
+}
+
+// Overrides are applied when the config file is read, before validation.
+func TestReadConfigEnv(t *testing.T) {
+	unsetEnv(t, "GHIDRA_PANEL_DISCORD_WEBHOOK_URL")
+	t.Setenv("GHIDRA_PANEL_DISCORD_WEBHOOK_URL", "http://discord.example/api/webhooks/2")
+	c := parseConfig(t, testConfig)
+	if c.Discord.WebhookURL != "http://discord.example/api/webhooks/2" {
+		t.Fatalf("webhook %q not overridden", c.Discord.WebhookURL)
+	}
+	if err := c.validate(); err == nil || !strings.Contains(err.Error(), "discord.webhook_url") {
+		t.Errorf("validate() error = %v, want overridden webhook rejected", err)
+	}
+}
+
+func TestReadConfigBaseURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		want    string
+		valid   bool
+	}{
+		{"https://panel.example", "https://panel.example", true},
+		{"https://panel.example/", "https://panel.example", true},
+		{"https://panel.example:8443/", "https://panel.example:8443", true},
+		{"https://panel.example//", "https://panel.example/", false},
+		{"https://panel.example/panel/", "https://panel.example/panel", false},
+	}
+	for _, tt := range tests {
+		c := parseConfig(t, strings.Replace(testConfig, `"https://panel.example"`, `"`+tt.baseURL+`"`, 1))
+		if c.BaseURL != tt.want {
+			t.Errorf("%s: base URL %q, want %q", tt.baseURL, c.BaseURL, tt.want)
+		}
+		if err := c.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: validate() error = %v, want valid %v", tt.baseURL, err, tt.valid)
+		}
+	}
+}
+
+func TestSessionLifetime(t *testing.T) {
+	if got := parseConfig(t, testConfig).sessionLifetime(); got != token.DefaultLifetime {
+		t.Errorf("default session lifetime %s, want %s", got, token.DefaultLifetime)
+	}
+	config := strings.Replace(testConfig, `"links"`, `"session": {"lifetime": "24h"}, "links"`, 1)
+	if got := parseConfig(t, config).sessionLifetime(); got != 24*time.Hour {
+		t.Errorf("session lifetime %s, want 24h", got)
+	}
+}
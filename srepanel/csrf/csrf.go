@@ -1,6 +1,7 @@
 package csrf
 
 import (
+	"context"
 	"crypto/hmac"
 	crand "crypto/rand"
 	"crypto/sha256"
@@ -9,96 +10,220 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.mkw.re/ghidra-panel/bitring"
 )
 
-const csrfValidity = 30 * time.Second
+// DefaultValidity is how long one-time tokens are valid by default.
+const DefaultValidity = 30 * time.Second
 
 const csrfDepth = 65536
 
+// ReplayStore records consumed token IDs, so that multiple panel instances
+// and restarts share replay protection.
+type ReplayStore interface {
+	// ConsumeOnce marks an ID as used until it expires.
+	// Returns false if the ID was used before.
+	ConsumeOnce(ctx context.Context, id uint64, expiresAt time.Time) (bool, error)
+}
+
+// Options configure a OneTime.
+type Options struct {
+	// Keys are MAC keys, the first one issues tokens.
+	// A random key is used if empty, which does not survive restarts.
+	// Keys require a Store: tokens must not outlive the record of their use.
+	Keys [][]byte
+	// Validity is how long tokens are valid, DefaultValidity if zero.
+	Validity time.Duration
+	// Store replaces the in-memory replay protection if set.
+	Store ReplayStore
+}
+
 type OneTime struct {
-	ring   bitring.BitRing
-	macKey [32]byte
+	ring     *bitring.BitRing
+	macKeys  keyring
+	validity time.Duration
+	store    ReplayStore
+}
+
+// keyring holds MAC keys, the first one issuing tokens.
+// The keys may be replaced while in use.
+type keyring struct {
+	keys atomic.Pointer[[][]byte]
+}
+
+func (r *keyring) get() [][]byte {
+	return *r.keys.Load()
+}
+
+func (r *keyring) set(keys [][]byte) {
+	r.keys.Store(&keys)
 }
 
-func NewOneTime() *OneTime {
+// NewOneTime creates a OneTime. Panics if keys are given without a store,
+// as tokens consumed before a restart would become valid again.
+func NewOneTime(opts Options) *OneTime {
+	if len(opts.Keys) > 0 && opts.Store == nil {
+		panic("csrf: persistent keys require a replay store")
+	}
 	c := &OneTime{
-		ring: bitring.NewBitRing(csrfDepth),
+		validity: opts.Validity,
+		store:    opts.Store,
 	}
-	if _, randErr := crand.Read(c.macKey[:]); randErr != nil {
-		panic("crypto rand read failed: " + randErr.Error())
+	if len(opts.Keys) == 0 {
+		c.macKeys.set([][]byte{randomKey()})
+	} else {
+		c.macKeys.set(opts.Keys)
+	}
+	if c.validity == 0 {
+		c.validity = DefaultValidity
+	}
+	if c.store == nil {
+		c.ring = bitring.NewBitRing(csrfDepth)
 	}
 	return c
 }
 
-func (c *OneTime) Issue() string {
+// SetKeys replaces the MAC keys, e.g. after key rotation. Tokens issued
+// with keys no longer given stop being accepted.
+// Panics if the OneTime was created without keys.
+func (c *OneTime) SetKeys(keys [][]byte) {
+	if len(keys) == 0 || c.store == nil {
+		panic("csrf: persistent keys require a replay store")
+	}
+	c.macKeys.set(keys)
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, randErr := crand.Read(key); randErr != nil {
+		panic("crypto rand read failed: " + randErr.Error())
+	}
+	return key
+}
+
+// nextID returns a new token ID. IDs are sequential in memory,
+// but random with a shared store, where instances cannot coordinate.
+func (c *OneTime) nextID() uint64 {
+	if c.store == nil {
+		return c.ring.Advance()
+	}
+	var id [8]byte
+	if _, randErr := crand.Read(id[:]); randErr != nil {
+		panic("crypto rand read failed: " + randErr.Error())
+	}
+	return binary.LittleEndian.Uint64(id[:])
+}
+
+// MaxDataLen is the maximum length of data carried by a token.
+const MaxDataLen = 512
+
+// Issue creates a one-time token carrying data, e.g. a return path.
+// The data is authenticated, but not encrypted.
+func (c *OneTime) Issue(data string) string {
+	if len(data) > MaxDataLen {
+		panic("csrf token data too long")
+	}
+
 	// 0:32 = hmac key
 	// 32:40 = counter
 	// 40:48 = timestamp
-	var key [48]byte
+	// 48: = data
+	key := make([]byte, 48+len(data))
 
 	// issue new key
-	n := c.ring.Advance()
+	n := c.nextID()
 	binary.LittleEndian.PutUint64(key[32:40], n)
 	binary.LittleEndian.PutUint64(key[40:48], uint64(time.Now().Unix()))
+	copy(key[48:], data)
 
 	// hmac key
-	mac := hmac.New(sha256.New, c.macKey[:])
-	_, _ = mac.Write(key[32:48])
+	mac := hmac.New(sha256.New, c.macKeys.get()[0])
+	_, _ = mac.Write(key[32:])
 	mac.Sum(key[:0])
 
-	return "v0:" + base64.URLEncoding.EncodeToString(key[:])
+	return "v1:" + base64.RawURLEncoding.EncodeToString(key)
 }
 
-func (c *OneTime) Check(x string) (id uint64, err error) {
-	if !strings.HasPrefix(x, "v0:") {
-		return 0, fmt.Errorf("unsupported csrf token version")
+// parse decodes a token and returns it with the MAC key it was issued with.
+func (c *OneTime) parse(x string) (key, macKey []byte, err error) {
+	if !strings.HasPrefix(x, "v1:") {
+		return nil, nil, fmt.Errorf("unsupported csrf token version")
 	}
 	x = x[3:]
 
-	var key [48]byte
-	_, err = base64.URLEncoding.Decode(key[:], []byte(x))
+	if len(x) > base64.RawURLEncoding.EncodedLen(48+MaxDataLen) {
+		return nil, nil, fmt.Errorf("malformed csrf v1 token")
+	}
+	key, err = base64.RawURLEncoding.DecodeString(x)
+	if err != nil || len(key) < 48 {
+		return nil, nil, fmt.Errorf("malformed csrf v1 token")
+	}
+
+	// verify hmac key, accepting all keys of the keyring
+	for _, k := range c.macKeys.get() {
+		var verify [32]byte
+		mac := hmac.New(sha256.New, k)
+		_, _ = mac.Write(key[32:])
+		mac.Sum(verify[:0])
+		if subtle.ConstantTimeCompare(key[:32], verify[:]) == 1 {
+			macKey = k
+		}
+	}
+	if macKey == nil {
+		return nil, nil, fmt.Errorf("csrf v1 MAC invalid")
+	}
+	return key, macKey, nil
+}
+
+// Check validates a token without consuming it and returns its ID and data.
+func (c *OneTime) Check(x string) (id uint64, data string, err error) {
+	key, _, err := c.parse(x)
 	if err != nil {
-		return 0, fmt.Errorf("malformed csrf v0 token")
+		return 0, "", err
 	}
 
 	// check if expired
 	timestamp := binary.LittleEndian.Uint64(key[40:48])
-	if time.Now().Unix()-int64(timestamp) > int64(csrfValidity)/int64(time.Second) {
-		return 0, fmt.Errorf("csrf v0 token expired")
-	}
-
-	// verify hmac key
-	var verify [32]byte
-	mac := hmac.New(sha256.New, c.macKey[:])
-	_, _ = mac.Write(key[32:48])
-	mac.Sum(verify[:0])
-	macValid := subtle.ConstantTimeCompare(key[:32], verify[:]) == 1
-	if !macValid {
-		return 0, fmt.Errorf("csrf v0 MAC invalid")
+	if time.Now().Unix()-int64(timestamp) > int64(c.validity/time.Second) {
+		return 0, "", fmt.Errorf("csrf v1 token expired")
 	}
 
-	// check if reused
+	// check if reused, the shared store is only checked when consuming
 	id = binary.LittleEndian.Uint64(key[32:40])
+	data = string(key[48:])
+	if c.store != nil {
+		return id, data, nil
+	}
 	reused, ok := c.ring.Contains(id)
 	if !ok {
-		return 0, fmt.Errorf("server forgot about csrf v0 token")
+		return 0, "", fmt.Errorf("server forgot about csrf v1 token")
 	}
 	if reused {
-		return 0, fmt.Errorf("csrf reuse detected")
+		return 0, "", fmt.Errorf("csrf reuse detected")
 	}
 	return
 }
 
 func (c *OneTime) Consume(id uint64) error {
-	exist, ok := c.ring.Insert(id)
-	if !ok {
-		return fmt.Errorf("server forgot about csrf v0 token")
+	if c.store != nil {
+		fresh, err := c.store.ConsumeOnce(context.Background(), id, time.Now().Add(c.validity))
+		if err != nil {
+			return fmt.Errorf("failed to record csrf token: %w", err)
+		}
+		if !fresh {
+			return fmt.Errorf("csrf reuse detected")
+		}
+		return nil
 	}
-	if exist {
+	switch err := c.ring.Use(id); err {
+	case bitring.ErrForgotten:
+		return fmt.Errorf("server forgot about csrf v1 token")
+	case bitring.ErrReused:
 		return fmt.Errorf("csrf reuse detected")
+	default:
+		return err
 	}
-	return nil
 }
@@ -0,0 +1,185 @@
+package csrf
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapStore is a ReplayStore shared by instances, like a database.
+type mapStore struct {
+	mu   sync.Mutex
+	used map[uint64]time.Time
+}
+
+func (s *mapStore) ConsumeOnce(ctx context.Context, id uint64, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used == nil {
+		s.used = make(map[uint64]time.Time)
+	}
+	if _, ok := s.used[id]; ok {
+		return false, nil
+	}
+	s.used[id] = expiresAt
+	return true, nil
+}
+
+// consume checks and consumes a token, as a login redirect does.
+func consume(c *OneTime, token string) (string, error) {
+	id, data, err := c.Check(token)
+	if err != nil {
+		return "", err
+	}
+	return data, c.Consume(id)
+}
+
+func TestOneTimeReplay(t *testing.T) {
+	keys := [][]byte{[]byte("persistent key")}
+	store := &mapStore{}
+	shared := func() *OneTime { return NewOneTime(Options{Keys: keys, Store: store}) }
+	memory := NewOneTime(Options{})
+
+	tests := []struct {
+		name string
+		// issuer issues the token, consumers consume it in turn
+		issuer    *OneTime
+		consumers []*OneTime
+		errs      []string // expected errors of each consumer, "" for success
+	}{
+		{
+			name:      "memory",
+			issuer:    memory,
+			consumers: []*OneTime{memory, memory},
+			errs:      []string{"", "reuse"},
+		},
+		{
+			name:      "shared store across restart",
+			issuer:    shared(),
+			consumers: []*OneTime{shared(), shared()},
+			errs:      []string{"", "reuse"},
+		},
+		{
+			name:      "shared store across replicas",
+			issuer:    shared(),
+			consumers: []*OneTime{shared(), shared(), shared()},
+			errs:      []string{"", "reuse", "reuse"},
+		},
+		{
+			name:      "memory after restart",
+			issuer:    NewOneTime(Options{}),
+			consumers: []*OneTime{NewOneTime(Options{})},
+			errs:      []string{"MAC invalid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.issuer.Issue("/return")
+			for i, c := range tt.consumers {
+				data, err := consume(c, token)
+				if tt.errs[i] == "" {
+					if err != nil || data != "/return" {
+						t.Fatalf("consumer %d: %q, %v", i, data, err)
+					}
+					continue
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.errs[i]) {
+					t.Fatalf("consumer %d: error %v, want %q", i, err, tt.errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOneTimePersistentKeysRequireStore(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewOneTime accepted persistent keys without a replay store")
+		}
+	}()
+	NewOneTime(Options{Keys: [][]byte{[]byte("persistent key")}})
+}
+
+func TestOneTimeSetKeys(t *testing.T) {
+	oldKey, newKey := []byte("old key"), []byte("new key")
+	c := NewOneTime(Options{Keys: [][]byte{oldKey}, Store: &mapStore{}})
+	before := c.Issue("before")
+	c.SetKeys([][]byte{newKey, oldKey})
+	after := c.Issue("after")
+
+	// Tokens in flight survive the rotation
+	for _, token := range []string{before, after} {
+		if _, err := consume(c, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newOnly := NewOneTime(Options{Keys: [][]byte{newKey}, Store: &mapStore{}})
+	if _, err := consume(newOnly, c.Issue("data")); err != nil {
+		t.Errorf("token not issued with the new key: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("SetKeys accepted persistent keys without a replay store")
+		}
+	}()
+	NewOneTime(Options{}).SetKeys([][]byte{newKey})
+}
+
+// reissue re-signs a token with another timestamp and data.
+func reissue(t *testing.T, macKey []byte, token string, issuedAt time.Time, data string) string {
+	t.Helper()
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, "v1:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key = append(key[:48], data...)
+	binary.LittleEndian.PutUint64(key[40:48], uint64(issuedAt.Unix()))
+	mac := hmac.New(sha256.New, macKey)
+	_, _ = mac.Write(key[32:])
+	mac.Sum(key[:0])
+	return "v1:" + base64.RawURLEncoding.EncodeToString(key)
+}
+
+func TestOneTimeCheck(t *testing.T) {
+	oldKey, newKey := []byte("old key"), []byte("new key")
+	c := NewOneTime(Options{Keys: [][]byte{newKey, oldKey}, Validity: time.Minute, Store: &mapStore{}})
+	token := c.Issue("data")
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"valid", token, ""},
+		{"previous key", reissue(t, oldKey, token, now, "data"), ""},
+		{"unknown key", reissue(t, []byte("other key"), token, now, "data"), "MAC invalid"},
+		{"expired", reissue(t, newKey, token, now.Add(-2*time.Minute), "data"), "expired"},
+		{"tampered data", strings.TrimSuffix(token, "ZGF0YQ") + "ZGF0Yg", "MAC invalid"},
+		{"truncated", token[:20], "malformed"},
+		{"oversized", "v1:" + strings.Repeat("A", 2000), "malformed"},
+		{"unknown version", "v2:" + strings.TrimPrefix(token, "v1:"), "unsupported"},
+		{"empty", "", "unsupported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, data, err := c.Check(tt.token)
+			if tt.err == "" {
+				if err != nil || data != "data" {
+					t.Fatalf("Check() = %q, %v", data, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
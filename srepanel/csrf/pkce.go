@@ -0,0 +1,65 @@
+package csrf
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// NewVerifier returns a random PKCE code verifier (RFC 7636).
+func NewVerifier() string {
+	return base64.RawURLEncoding.EncodeToString(randomKey())
+}
+
+// Challenge returns the S256 PKCE code challenge of a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// BindVerifier creates a PKCE code verifier for a new login and stores it
+// in a cookie of the browser starting it, scoped to the redirect URL.
+// An authorization code is thus useless to anyone but that browser,
+// even together with the state. The cookie expires with the state.
+func (c *OneTime) BindVerifier(wr http.ResponseWriter, cookieName, redirectURL string) string {
+	verifier := NewVerifier()
+	http.SetCookie(wr, &http.Cookie{
+		Name:     cookieName,
+		Value:    verifier,
+		Path:     cookiePath(redirectURL),
+		MaxAge:   int(c.validity/time.Second) + 1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return verifier
+}
+
+// BoundVerifier returns the code verifier stored by BindVerifier
+// and deletes its cookie.
+func BoundVerifier(wr http.ResponseWriter, req *http.Request, cookieName, redirectURL string) (string, error) {
+	cookie, err := req.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return "", errors.New("PKCE verifier missing, login started in another browser")
+	}
+	http.SetCookie(wr, &http.Cookie{
+		Name:     cookieName,
+		Path:     cookiePath(redirectURL),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return cookie.Value, nil
+}
+
+func cookiePath(redirectURL string) string {
+	parsed, err := url.Parse(redirectURL)
+	if err != nil || parsed.Path == "" {
+		return "/"
+	}
+	return parsed.Path
+}
@@ -0,0 +1,81 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChallenge(t *testing.T) {
+	// RFC 7636, appendix B
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	if got := Challenge(verifier); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Challenge(%q) = %q", verifier, got)
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		verifier := NewVerifier()
+		// RFC 7636 requires 43 to 128 unreserved characters
+		if len(verifier) != 43 {
+			t.Fatalf("verifier %q has length %d, want 43", verifier, len(verifier))
+		}
+		if seen[verifier] {
+			t.Fatalf("verifier %q repeated", verifier)
+		}
+		seen[verifier] = true
+	}
+}
+
+func TestBindVerifier(t *testing.T) {
+	c := NewOneTime(Options{Validity: time.Minute})
+	wr := httptest.NewRecorder()
+	verifier := c.BindVerifier(wr, "pkce_test", "https://panel.example/redirect/test")
+	cookies := wr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("%d cookies set, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != "pkce_test" || cookie.Value != verifier || cookie.Path != "/redirect/test" ||
+		cookie.MaxAge < 60 || cookie.MaxAge > 61 ||
+		!cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie %+v", cookie)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		ok     bool
+	}{
+		{"bound", cookie, true},
+		{"missing", nil, false},
+		{"empty", &http.Cookie{Name: "pkce_test"}, false},
+		{"other name", &http.Cookie{Name: "pkce_other", Value: verifier}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/redirect/test", nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			wr := httptest.NewRecorder()
+			got, err := BoundVerifier(wr, req, "pkce_test", "https://panel.example/redirect/test")
+			if (err == nil) != tt.ok {
+				t.Fatalf("BoundVerifier() error = %v, want success %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if got != verifier {
+				t.Errorf("BoundVerifier() = %q, want %q", got, verifier)
+			}
+			cleared := wr.Result().Cookies()
+			if len(cleared) != 1 || cleared[0].Name != "pkce_test" || cleared[0].Path != "/redirect/test" || cleared[0].MaxAge >= 0 {
+				t.Errorf("cookie not cleared: %v", cleared)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package csrf
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// FormField is the name of the form field carrying synchronizer tokens.
+const FormField = "csrf_token"
+
+// Synchronizer issues per-session synchronizer tokens for forms.
+//
+// Tokens are derived from the session ID, so they stay valid for the
+// lifetime of the session without being stored server-side.
+type Synchronizer struct {
+	macKeys keyring
+}
+
+// NewSynchronizer creates a Synchronizer. The first key issues tokens,
+// all keys verify them. A random key is used if none are given.
+func NewSynchronizer(keys [][]byte) *Synchronizer {
+	s := new(Synchronizer)
+	s.SetKeys(keys)
+	return s
+}
+
+// SetKeys replaces the keys, e.g. after key rotation.
+// A random key is used if none are given.
+func (s *Synchronizer) SetKeys(keys [][]byte) {
+	if len(keys) == 0 {
+		keys = [][]byte{randomKey()}
+	}
+	s.macKeys.set(keys)
+}
+
+// Token returns the synchronizer token of a session.
+func (s *Synchronizer) Token(sessionID string) string {
+	return sessionToken(s.macKeys.get()[0], sessionID)
+}
+
+// Verify checks the synchronizer token submitted for a session.
+func (s *Synchronizer) Verify(sessionID, token string) bool {
+	valid := false
+	for _, macKey := range s.macKeys.get() {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(sessionToken(macKey, sessionID))) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func sessionToken(macKey []byte, sessionID string) string {
+	var sum [32]byte
+	mac := hmac.New(sha256.New, macKey)
+	_, _ = mac.Write([]byte("session:"))
+	_, _ = mac.Write([]byte(sessionID))
+	mac.Sum(sum[:0])
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// CheckOrigin rejects cross-origin requests based on the Origin header,
+// falling back to the Referer header. Requests carrying neither are
+// rejected too, as their origin is unknown. Browsers send at least one
+// of them on same-origin form submissions.
+func CheckOrigin(req *http.Request, origin string) error {
+	if o := req.Header.Get("Origin"); o != "" {
+		if o != origin {
+			return fmt.Errorf("cross-origin request from %q", o)
+		}
+		return nil
+	}
+	if ref := req.Header.Get("Referer"); ref != "" {
+		parsed, err := url.Parse(ref)
+		if err != nil {
+			return fmt.Errorf("malformed referer")
+		}
+		if parsed.Scheme+"://"+parsed.Host != origin {
+			return fmt.Errorf("cross-origin request from %q", parsed.Host)
+		}
+		return nil
+	}
+	return fmt.Errorf("request without origin")
+}
+
+// Origin returns the origin (scheme and host) of a URL, e.g. "https://example.org".
+func Origin(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", fmt.Errorf("URL %q has no origin", rawURL)
+	}
+	return parsed.Scheme + "://" + parsed.Host, nil
+}
@@ -0,0 +1,88 @@
+package csrf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSynchronizer(t *testing.T) {
+	old := NewSynchronizer([][]byte{[]byte("old key")})
+	rotated := NewSynchronizer([][]byte{[]byte("new key"), []byte("old key")})
+
+	tests := []struct {
+		name    string
+		sync    *Synchronizer
+		session string
+		token   string
+		valid   bool
+	}{
+		{"same session", rotated, "a", rotated.Token("a"), true},
+		{"other session", rotated, "b", rotated.Token("a"), false},
+		{"previous key", rotated, "a", old.Token("a"), true},
+		{"removed key", old, "a", rotated.Token("a"), false},
+		{"empty", rotated, "a", "", false},
+		{"random key", NewSynchronizer(nil), "a", NewSynchronizer(nil).Token("a"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sync.Verify(tt.session, tt.token); got != tt.valid {
+				t.Errorf("Verify() = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	const origin = "https://panel.example"
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		allowed bool
+	}{
+		{"same origin", "Origin", origin, true},
+		{"cross origin", "Origin", "https://evil.example", false},
+		{"other scheme", "Origin", "http://panel.example", false},
+		{"null origin", "Origin", "null", false},
+		{"same origin referer", "Referer", origin + "/tokens?x=1", true},
+		{"cross origin referer", "Referer", "https://evil.example/panel.example", false},
+		{"malformed referer", "Referer", "https://%zz", false},
+		{"neither", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			if err := CheckOrigin(req, origin); (err == nil) != tt.allowed {
+				t.Errorf("CheckOrigin() = %v, want allowed %v", err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	tests := []struct {
+		url    string
+		origin string
+	}{
+		{"https://panel.example", "https://panel.example"},
+		{"https://panel.example:8443/ghidra/", "https://panel.example:8443"},
+		{"/relative", ""},
+		{"panel.example", ""},
+	}
+	for _, tt := range tests {
+		got, err := Origin(tt.url)
+		if tt.origin == "" {
+			if err == nil {
+				t.Errorf("Origin(%q) = %q, want error", tt.url, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.origin {
+			t.Errorf("Origin(%q) = %q, %v, want %q", tt.url, got, err, tt.origin)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+
+	"go.mkw.re/ghidra-panel/ghidra"
+)
+
+// GetManagedGrants returns the repo permissions granted to a user by automation.
+func (d *DB) GetManagedGrants(ctx context.Context, userID uint64) (map[string]ghidra.Grant, error) {
+	rows, err := d.QueryContext(ctx, "SELECT repo, perm, prior_perm FROM acl_grants WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make(map[string]ghidra.Grant)
+	for rows.Next() {
+		var repo string
+		var grant ghidra.Grant
+		if err := rows.Scan(&repo, &grant.Perm, &grant.Prior); err != nil {
+			return nil, err
+		}
+		grants[repo] = grant
+	}
+	return grants, rows.Err()
+}
+
+// SetManagedGrants replaces the repo permissions granted to a user by automation.
+func (d *DB) SetManagedGrants(ctx context.Context, userID uint64, grants map[string]ghidra.Grant) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM acl_grants WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for repo, grant := range grants {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO acl_grants (user_id, repo, perm, prior_perm) VALUES (?, ?, ?, ?)`,
+			userID, repo, grant.Perm, grant.Prior,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/ghidra"
+)
+
+func TestManagedGrants(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	tests := []struct {
+		name   string
+		grants map[string]ghidra.Grant
+	}{
+		{"new entries", map[string]ghidra.Grant{"re": {Perm: ghidra.PermWrite, Prior: ghidra.PermNone}}},
+		{"upgraded manual entries", map[string]ghidra.Grant{
+			"re":    {Perm: ghidra.PermAdmin, Prior: ghidra.PermRead},
+			"tools": {Perm: ghidra.PermWrite, Prior: ghidra.PermRead},
+		}},
+		{"none", map[string]ghidra.Grant{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := db.SetManagedGrants(ctx, 1, tt.grants); err != nil {
+				t.Fatal(err)
+			}
+			got, err := db.GetManagedGrants(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.grants) {
+				t.Errorf("GetManagedGrants() = %v, want %v", got, tt.grants)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+// APITokenAuth holds what is needed to authenticate with an API token.
+type APITokenAuth struct {
+	UserID    uint64
+	Scopes    []string
+	Hash      []byte
+	ExpiresAt time.Time
+}
+
+// CreateAPIToken records a new API token with the hash of its secret.
+func (d *DB) CreateAPIToken(ctx context.Context, userID uint64, tok *common.APIToken, hash []byte) error {
+	_, err := d.ExecContext(
+		ctx,
+		`INSERT INTO api_tokens (id, user_id, name, scopes, hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		tok.ID, userID, tok.Name, strings.Join(tok.Scopes, " "), hash, tok.CreatedAt.Unix(), tok.ExpiresAt.Unix(),
+	)
+	return err
+}
+
+// LookupAPIToken returns an API token that is neither revoked nor expired, or nil.
+func (d *DB) LookupAPIToken(ctx context.Context, id string) (*APITokenAuth, error) {
+	row := d.QueryRowContext(
+		ctx,
+		`SELECT user_id, scopes, hash, expires_at FROM api_tokens
+		WHERE id = ? AND revoked_at IS NULL AND expires_at > ?`,
+		id, time.Now().Unix(),
+	)
+	var auth APITokenAuth
+	var scopes string
+	var expiresAt int64
+	if err := row.Scan(&auth.UserID, &scopes, &auth.Hash, &expiresAt); errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	auth.Scopes = strings.Fields(scopes)
+	auth.ExpiresAt = time.Unix(expiresAt, 0)
+	return &auth, nil
+}
+
+// TouchAPIToken records when an API token was last used.
+func (d *DB) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := d.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, usedAt.Unix(), id)
+	return err
+}
+
+// ListAPITokens returns the active API tokens of a user, newest first.
+func (d *DB) ListAPITokens(ctx context.Context, userID uint64) ([]common.APIToken, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT id, name, scopes, created_at, expires_at, last_used_at FROM api_tokens
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY created_at DESC`,
+		userID, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []common.APIToken
+	for rows.Next() {
+		var tok common.APIToken
+		var scopes string
+		var createdAt, expiresAt int64
+		var lastUsedAt sql.NullInt64
+		if err := rows.Scan(&tok.ID, &tok.Name, &scopes, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		tok.Scopes = strings.Fields(scopes)
+		tok.CreatedAt = time.Unix(createdAt, 0)
+		tok.ExpiresAt = time.Unix(expiresAt, 0)
+		if lastUsedAt.Valid {
+			t := time.Unix(lastUsedAt.Int64, 0)
+			tok.LastUsedAt = &t
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes an API token of a user.
+// Returns false if the user has no such active token.
+func (d *DB) RevokeAPIToken(ctx context.Context, userID uint64, id string) (bool, error) {
+	res, err := d.ExecContext(
+		ctx,
+		`UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`,
+		time.Now().Unix(), id, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,18 @@
+package database
+
+import (
+	"context"
+	"log"
+)
+
+// Audit records a security-relevant action in the audit log.
+// The entry is also written to the process log.
+func (d *DB) Audit(ctx context.Context, userID uint64, action, detail string) error {
+	log.Printf("audit: user=%d action=%s %s", userID, action, detail)
+	_, err := d.ExecContext(
+		ctx,
+		`INSERT INTO audit_log (user_id, action, detail) VALUES (?, ?, ?)`,
+		userID, action, detail,
+	)
+	return err
+}
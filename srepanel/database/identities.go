@@ -0,0 +1,204 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+var (
+	// ErrIdentityLinked is returned when linking an identity that belongs to another account.
+	ErrIdentityLinked = errors.New("identity is already linked to another account")
+	// ErrLastIdentity is returned when unlinking the last usable identity of an account.
+	ErrLastIdentity = errors.New("cannot unlink the last way to log in")
+	// ErrIdentityUnlinked is returned when logging in with an identity that
+	// was unlinked from its account. It must be linked again to log in.
+	ErrIdentityUnlinked = errors.New("identity was unlinked from its account")
+)
+
+// ResolveIdentity returns the panel user an external identity belongs to.
+// Unknown identities create a new account with the identity's default user ID,
+// unless that account exists, i.e. the identity was unlinked from it.
+// Returns whether the identity is the primary identity of the account.
+func (d *DB) ResolveIdentity(ctx context.Context, ident *common.Identity) (userID uint64, primary bool, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT user_id, is_primary FROM identities WHERE provider = ? AND subject = ?", ident.Provider, ident.Subject).
+		Scan(&userID, &primary)
+	if err == nil {
+		_, err = d.ExecContext(
+			ctx,
+			`UPDATE identities SET username = ? WHERE provider = ? AND subject = ?`,
+			ident.Username, ident.Provider, ident.Subject,
+		)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	// First login with this identity
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	// Accounts created before identities were tracked have none yet
+	var exists bool
+	err = tx.
+		QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM identities WHERE user_id = ?)", ident.ID).
+		Scan(&exists)
+	if err != nil {
+		return 0, false, err
+	}
+	if exists {
+		return 0, false, ErrIdentityUnlinked
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO identities (provider, subject, user_id, username, is_primary) VALUES (?, ?, ?, ?, 1)`,
+		ident.Provider, ident.Subject, ident.ID, ident.Username,
+	); err != nil {
+		return 0, false, err
+	}
+	return ident.ID, true, tx.Commit()
+}
+
+// LinkIdentity adds an external identity to an existing panel account.
+// An identity already owned by another account is only moved over if that
+// account is otherwise unused, i.e. has no password and no other identities.
+func (d *DB) LinkIdentity(ctx context.Context, userID uint64, ident *common.Identity) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner uint64
+	err = tx.
+		QueryRowContext(ctx, "SELECT user_id FROM identities WHERE provider = ? AND subject = ?", ident.Provider, ident.Subject).
+		Scan(&owner)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case owner == userID:
+		return nil
+	default:
+		var used bool
+		err = tx.
+			QueryRowContext(
+				ctx,
+				`SELECT EXISTS(SELECT 1 FROM passwords WHERE id = ?1)
+				OR (SELECT COUNT(*) FROM identities WHERE user_id = ?1) > 1`,
+				owner,
+			).
+			Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used {
+			return ErrIdentityLinked
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM profiles WHERE id = ?`, owner); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO identities (provider, subject, user_id, username, is_primary) VALUES (?, ?, ?, ?, 0)
+		ON CONFLICT(provider, subject) DO UPDATE SET
+			user_id = excluded.user_id,
+			username = excluded.username,
+			is_primary = 0`,
+		ident.Provider, ident.Subject, userID, ident.Username,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnlinkIdentity removes an external identity from a panel account.
+// Refuses if none of the remaining identities belong to an enabled provider.
+// If the primary identity is removed, the oldest remaining identity is promoted.
+func (d *DB) UnlinkIdentity(ctx context.Context, userID uint64, provider, subject string, enabled []string) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`DELETE FROM identities WHERE user_id = ? AND provider = ? AND subject = ?`,
+		userID, provider, subject,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if len(enabled) == 0 {
+		return ErrLastIdentity
+	}
+	args := []any{userID}
+	for _, p := range enabled {
+		args = append(args, p)
+	}
+	var remaining int
+	err = tx.
+		QueryRowContext(
+			ctx,
+			`SELECT COUNT(*) FROM identities WHERE user_id = ? AND provider IN (?`+strings.Repeat(", ?", len(enabled)-1)+`)`,
+			args...,
+		).
+		Scan(&remaining)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		return ErrLastIdentity
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE identities SET is_primary = 1
+		WHERE rowid = (SELECT rowid FROM identities WHERE user_id = ?1 ORDER BY created_at, rowid LIMIT 1)
+		AND NOT EXISTS(SELECT 1 FROM identities WHERE user_id = ?1 AND is_primary)`,
+		userID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListIdentities returns the external identities linked to a panel account.
+func (d *DB) ListIdentities(ctx context.Context, userID uint64) ([]common.LinkedIdentity, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT provider, subject, username, is_primary FROM identities WHERE user_id = ? ORDER BY created_at, rowid`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var idents []common.LinkedIdentity
+	for rows.Next() {
+		var ident common.LinkedIdentity
+		if err := rows.Scan(&ident.Provider, &ident.Subject, &ident.Username, &ident.Primary); err != nil {
+			return nil, err
+		}
+		idents = append(idents, ident)
+	}
+	return idents, rows.Err()
+}
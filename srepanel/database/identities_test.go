@@ -0,0 +1,344 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+var (
+	discordBob = &common.Identity{ID: 100, Provider: "discord", Subject: "100", Username: "bob"}
+	corpBob    = &common.Identity{ID: 200, Provider: "corp", Subject: "bob-sub", Username: "corp-bob"}
+	gitlabBob  = &common.Identity{ID: 300, Provider: "gitlab", Subject: "42", Username: "gitlab-bob"}
+)
+
+// resolve logs in with an identity, failing the test on error.
+func resolve(t *testing.T, db *DB, ident *common.Identity) uint64 {
+	t.Helper()
+	userID, _, err := db.ResolveIdentity(context.Background(), ident)
+	if err != nil {
+		t.Fatal("ResolveIdentity() error: ", err)
+	}
+	return userID
+}
+
+func TestResolveIdentity(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, db *DB)
+		ident   *common.Identity
+		userID  uint64
+		primary bool
+		err     error
+	}{
+		{
+			name:    "first login",
+			ident:   discordBob,
+			userID:  100,
+			primary: true,
+		},
+		{
+			name:    "known identity",
+			setup:   func(t *testing.T, db *DB) { resolve(t, db, discordBob) },
+			ident:   discordBob,
+			userID:  100,
+			primary: true,
+		},
+		{
+			name: "account from before identities",
+			setup: func(t *testing.T, db *DB) {
+				if err := db.SetPassword(ctx, 100, "bob", "hunter2"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			ident:   discordBob,
+			userID:  100,
+			primary: true,
+		},
+		{
+			name: "linked identity",
+			setup: func(t *testing.T, db *DB) {
+				resolve(t, db, discordBob)
+				mustLink(t, db, 100, corpBob)
+			},
+			ident:  corpBob,
+			userID: 100,
+		},
+		{
+			name: "unlinked identity",
+			setup: func(t *testing.T, db *DB) {
+				resolve(t, db, discordBob)
+				mustLink(t, db, 100, corpBob)
+				if err := db.UnlinkIdentity(ctx, 100, "discord", "100", []string{"discord", "corp"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			ident: discordBob,
+			err:   ErrIdentityUnlinked,
+		},
+		{
+			name: "remaining identity after unlink",
+			setup: func(t *testing.T, db *DB) {
+				resolve(t, db, discordBob)
+				mustLink(t, db, 100, corpBob)
+				if err := db.UnlinkIdentity(ctx, 100, "discord", "100", []string{"discord", "corp"}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			ident:   corpBob,
+			userID:  100,
+			primary: true,
+		},
+		{
+			name: "linked again after unlink",
+			setup: func(t *testing.T, db *DB) {
+				resolve(t, db, discordBob)
+				mustLink(t, db, 100, corpBob)
+				if err := db.UnlinkIdentity(ctx, 100, "discord", "100", []string{"discord", "corp"}); err != nil {
+					t.Fatal(err)
+				}
+				mustLink(t, db, 100, discordBob)
+			},
+			ident:  discordBob,
+			userID: 100,
+		},
+		{
+			name: "identity moved to another account",
+			setup: func(t *testing.T, db *DB) {
+				resolve(t, db, discordBob)
+				resolve(t, db, corpBob)
+				// The Discord account is otherwise unused, so the identity moves
+				mustLink(t, db, 200, discordBob)
+			},
+			ident:  discordBob,
+			userID: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			if tt.setup != nil {
+				tt.setup(t, db)
+			}
+			userID, primary, err := db.ResolveIdentity(ctx, tt.ident)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("ResolveIdentity() error = %v, want %v", err, tt.err)
+				}
+				idents, err := db.ListIdentities(ctx, tt.ident.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, ident := range idents {
+					if ident.Provider == tt.ident.Provider && ident.Subject == tt.ident.Subject {
+						t.Error("refused identity was linked anyway")
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("ResolveIdentity() error: ", err)
+			}
+			if userID != tt.userID || primary != tt.primary {
+				t.Errorf("ResolveIdentity() = %d, %v, want %d, %v", userID, primary, tt.userID, tt.primary)
+			}
+		})
+	}
+}
+
+func TestResolveIdentityUsername(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	resolve(t, db, discordBob)
+	renamed := *discordBob
+	renamed.Username = "robert"
+	resolve(t, db, &renamed)
+	idents, err := db.ListIdentities(ctx, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idents) != 1 || idents[0].Username != "robert" {
+		t.Errorf("identities %+v, want username robert", idents)
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, db *DB)
+		err   error
+		owner uint64 // of corpBob afterwards
+	}{
+		{
+			name:  "new identity",
+			owner: 100,
+		},
+		{
+			name:  "already linked",
+			setup: func(t *testing.T, db *DB) { mustLink(t, db, 100, corpBob) },
+			owner: 100,
+		},
+		{
+			name:  "unused account",
+			setup: func(t *testing.T, db *DB) { resolve(t, db, corpBob) },
+			owner: 100,
+		},
+		{
+			name: "account with password",
+			setup: func(t *testing.T, db *DB) {
+				resolve(t, db, corpBob)
+				if err := db.SetPassword(ctx, 200, "corp-bob", "hunter2"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			err:   ErrIdentityLinked,
+			owner: 200,
+		},
+		{
+			name: "account with other identities",
+			setup: func(t *testing.T, db *DB) {
+				resolve(t, db, corpBob)
+				mustLink(t, db, 200, gitlabBob)
+			},
+			err:   ErrIdentityLinked,
+			owner: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			resolve(t, db, discordBob)
+			if tt.setup != nil {
+				tt.setup(t, db)
+			}
+			if err := db.LinkIdentity(ctx, 100, corpBob); !errors.Is(err, tt.err) {
+				t.Fatalf("LinkIdentity() error = %v, want %v", err, tt.err)
+			}
+			userID, primary, err := db.ResolveIdentity(ctx, corpBob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if userID != tt.owner {
+				t.Errorf("identity owned by %d, want %d", userID, tt.owner)
+			}
+			if userID == 100 && primary {
+				t.Error("linked identity replaced the primary identity")
+			}
+		})
+	}
+}
+
+func mustLink(t *testing.T, db *DB, userID uint64, ident *common.Identity) {
+	t.Helper()
+	if err := db.LinkIdentity(context.Background(), userID, ident); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name              string
+		linked            []*common.Identity // linked to account 100 after discordBob
+		provider, subject string
+		enabled           []string
+		err               error
+		remaining         []string // providers, primary first
+	}{
+		{
+			name:      "linked identity",
+			linked:    []*common.Identity{corpBob},
+			provider:  "corp",
+			subject:   "bob-sub",
+			enabled:   []string{"discord", "corp"},
+			remaining: []string{"discord"},
+		},
+		{
+			name:      "primary identity",
+			linked:    []*common.Identity{corpBob, gitlabBob},
+			provider:  "discord",
+			subject:   "100",
+			enabled:   []string{"discord", "corp", "gitlab"},
+			remaining: []string{"corp", "gitlab"},
+		},
+		{
+			name:      "last identity",
+			provider:  "discord",
+			subject:   "100",
+			enabled:   []string{"discord"},
+			err:       ErrLastIdentity,
+			remaining: []string{"discord"},
+		},
+		{
+			name:      "only identity of a disabled provider left",
+			linked:    []*common.Identity{corpBob},
+			provider:  "discord",
+			subject:   "100",
+			enabled:   []string{"discord"},
+			err:       ErrLastIdentity,
+			remaining: []string{"discord", "corp"},
+		},
+		{
+			name:      "no providers enabled",
+			linked:    []*common.Identity{corpBob},
+			provider:  "corp",
+			subject:   "bob-sub",
+			err:       ErrLastIdentity,
+			remaining: []string{"discord", "corp"},
+		},
+		{
+			name:      "not linked",
+			provider:  "corp",
+			subject:   "bob-sub",
+			enabled:   []string{"discord", "corp"},
+			err:       sql.ErrNoRows,
+			remaining: []string{"discord"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			resolve(t, db, discordBob)
+			for _, ident := range tt.linked {
+				mustLink(t, db, 100, ident)
+			}
+			// Another account's identity cannot be unlinked
+			resolve(t, db, &common.Identity{ID: 999, Provider: "corp", Subject: "other", Username: "corp-eve"})
+
+			err := db.UnlinkIdentity(ctx, 100, tt.provider, tt.subject, tt.enabled)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("UnlinkIdentity() error = %v, want %v", err, tt.err)
+			}
+			idents, err := db.ListIdentities(ctx, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var providers []string
+			primaries := 0
+			for _, ident := range idents {
+				if ident.Primary {
+					primaries++
+					providers = append([]string{ident.Provider}, providers...)
+				} else {
+					providers = append(providers, ident.Provider)
+				}
+			}
+			if primaries != 1 {
+				t.Errorf("%d primary identities, want 1", primaries)
+			}
+			if len(providers) != len(tt.remaining) {
+				t.Fatalf("identities %+v, want %q", idents, tt.remaining)
+			}
+			for i := range providers {
+				if providers[i] != tt.remaining[i] {
+					t.Fatalf("identities %+v, want %q with %s primary", idents, tt.remaining, tt.remaining[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// ConsumeOnce records a one-time token ID as used until it expires.
+// Returns false if the ID was used before. Expired IDs are pruned.
+func (d *DB) ConsumeOnce(ctx context.Context, id uint64, expiresAt time.Time) (bool, error) {
+	now := time.Now().Unix()
+	if _, err := d.ExecContext(ctx, `DELETE FROM consumed_nonces WHERE expires_at < ?`, now); err != nil {
+		return false, err
+	}
+	// database/sql does not support uint64 with the high bit set
+	res, err := d.ExecContext(
+		ctx,
+		`INSERT OR IGNORE INTO consumed_nonces (id, expires_at) VALUES (?, ?)`,
+		int64(id), expiresAt.Unix(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
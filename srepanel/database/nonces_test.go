@@ -0,0 +1,54 @@
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConsumeOnce(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "panel.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if fresh, err := db.ConsumeOnce(ctx, 1, now.Add(time.Hour)); err != nil || !fresh {
+		t.Fatalf("ConsumeOnce(1) = %v, %v", fresh, err)
+	}
+	if fresh, err := db.ConsumeOnce(ctx, 2, now.Add(-time.Second)); err != nil || !fresh {
+		t.Fatalf("ConsumeOnce(2) = %v, %v", fresh, err)
+	}
+	db.Close()
+
+	// Consumed IDs are remembered across restarts until they expire
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tests := []struct {
+		name  string
+		id    uint64
+		fresh bool
+	}{
+		{"consumed before restart", 1, false},
+		{"expired", 2, true},
+		{"new", 3, true},
+		{"high bit set", 1 << 63, true},
+		{"high bit set again", 1 << 63, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fresh, err := db.ConsumeOnce(ctx, tt.id, now.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fresh != tt.fresh {
+				t.Errorf("ConsumeOnce(%d) = %v, want %v", tt.id, fresh, tt.fresh)
+			}
+		})
+	}
+}
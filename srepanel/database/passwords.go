@@ -4,15 +4,19 @@ import (
 	"context"
 	"crypto/rand"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/mattn/go-sqlite3"
-	"golang.org/x/crypto/argon2"
+
+	"github.com/mattn/go-sqlite3"
 
 	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/passhash"
 )
 
 type DB struct {
 	*sql.DB
+	// Hasher hashes passwords. Open creates a pool with default limits.
+	Hasher *passhash.Pool
 }
 
 func Open(filePath string) (*DB, error) {
@@ -25,19 +29,50 @@ func Open(filePath string) (*DB, error) {
 		return nil, fmt.Errorf("migrations failed: %w", err)
 	}
 
-	return &DB{db}, nil
+	return &DB{
+		DB:     db,
+		Hasher: passhash.NewPool(passhash.DefaultParams, passhash.DefaultWorkers, passhash.DefaultQueueDepth),
+	}, nil
 }
 
 func (d *DB) GetUserState(ctx context.Context, id uint64) (*common.UserState, error) {
-	hasPass, err := d.HasPassword(ctx, id)
+	ghidraUsername, err := d.GetUsername(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	renamePending, err := d.RenamePending(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	isAdmin, err := d.HasRole(ctx, id, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	hasTOTP, err := d.HasTOTP(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	return &common.UserState{
-		HasPassword: hasPass,
+		IsAdmin:        isAdmin,
+		HasPassword:    ghidraUsername != "",
+		GhidraUsername: ghidraUsername,
+		RenamePending:  renamePending,
+		TOTPEnabled:    hasTOTP,
 	}, nil
 }
 
+// GetUsername returns the Ghidra username of a user.
+// Returns an empty string if the user has not set a password yet.
+func (d *DB) GetUsername(ctx context.Context, id uint64) (username string, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT username FROM passwords WHERE id = ?", id).
+		Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return
+}
+
 func (d *DB) HasPassword(ctx context.Context, id uint64) (exist bool, err error) {
 	err = d.
 		QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM passwords WHERE id = ?)", id).
@@ -45,6 +80,21 @@ func (d *DB) HasPassword(ctx context.Context, id uint64) (exist bool, err error)
 	return
 }
 
+// ErrUsernameTaken is returned when a Ghidra username belongs to another user.
+var ErrUsernameTaken = errors.New("Ghidra username belongs to another user")
+
+// UsernameAvailable returns whether a Ghidra username is unclaimed
+// or belongs to the given user.
+func (d *DB) UsernameAvailable(ctx context.Context, id uint64, username string) (available bool, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT NOT EXISTS(SELECT 1 FROM passwords WHERE username = ? AND id != ?)", username, id).
+		Scan(&available)
+	return
+}
+
+// SetPassword sets the Ghidra password of a user, creating their account
+// with the given username if they have none.
+// Returns ErrUsernameTaken if another user owns that username.
 func (d *DB) SetPassword(ctx context.Context, id uint64, username, password string) error {
 	var salt [16]byte
 	if _, err := rand.Read(salt[:]); err != nil {
@@ -52,9 +102,12 @@ func (d *DB) SetPassword(ctx context.Context, id uint64, username, password stri
 	}
 
 	// Hash password with Argon2id
-	hash := argon2.IDKey([]byte(password), salt[:], 1, 19456, 2, 32)
+	hash, err := d.Hasher.Hash(ctx, password, salt[:])
+	if err != nil {
+		return err
+	}
 
-	_, err := d.ExecContext(
+	_, err = d.ExecContext(
 		ctx,
 		`INSERT INTO passwords (id, username, hash, salt, format) VALUES (?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
@@ -64,14 +117,35 @@ func (d *DB) SetPassword(ctx context.Context, id uint64, username, password stri
 			updated_at = CURRENT_TIMESTAMP`,
 		id, username, hash, salt[:], 1,
 	)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return ErrUsernameTaken
+	}
 	return err
 }
 
+// SetUsername renames the Ghidra account of a user.
+// Resolves a pending rename if the new name matches the Discord username.
 func (d *DB) SetUsername(ctx context.Context, id uint64, username string) error {
-	_, err := d.ExecContext(
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(
 		ctx,
 		`UPDATE passwords SET username = ? WHERE id = ?`,
 		username, id,
-	)
-	return err
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE profiles SET rename_pending = (username != ?) WHERE id = ?`,
+		username, id,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSetPasswordUsernameTaken(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if err := db.SetPassword(ctx, 1, "bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		id        uint64
+		username  string
+		available bool
+		err       error
+	}{
+		{"owner", 1, "bob", true, nil},
+		{"other user", 2, "bob", false, ErrUsernameTaken},
+		{"unclaimed", 2, "corp-bob", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			available, err := db.UsernameAvailable(ctx, tt.id, tt.username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if available != tt.available {
+				t.Errorf("UsernameAvailable(%d, %q) = %v, want %v", tt.id, tt.username, available, tt.available)
+			}
+			if err := db.SetPassword(ctx, tt.id, tt.username, "password"); !errors.Is(err, tt.err) {
+				t.Errorf("SetPassword(%d, %q) error = %v, want %v", tt.id, tt.username, err, tt.err)
+			}
+		})
+	}
+
+	if username, err := db.GetUsername(ctx, 1); err != nil || username != "bob" {
+		t.Errorf("GetUsername(1) = %q, %v, want bob", username, err)
+	}
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+// ProfileChange describes how a Discord profile changed since the last login.
+type ProfileChange struct {
+	OldUsername    string
+	NewUsername    string
+	AvatarChanged  bool
+	GhidraUsername string // Ghidra account name, empty if no password set
+	RenamePending  bool   // Ghidra username needs to be reconciled
+}
+
+// UsernameChanged returns whether the unique Discord username changed.
+func (c *ProfileChange) UsernameChanged() bool {
+	return c.OldUsername != c.NewUsername
+}
+
+// SyncProfile stores the latest known Discord profile of a user.
+// Returns a non-nil change if the username or avatar differ from what was
+// previously stored, or if the Ghidra username no longer matches.
+func (d *DB) SyncProfile(ctx context.Context, ident *common.Identity) (change *ProfileChange, err error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		oldUsername   string
+		oldAvatar     string
+		renamePending bool
+		known         = true
+	)
+	err = tx.
+		QueryRowContext(ctx, "SELECT username, avatar, rename_pending FROM profiles WHERE id = ?", ident.ID).
+		Scan(&oldUsername, &oldAvatar, &renamePending)
+	if errors.Is(err, sql.ErrNoRows) {
+		// First login since profiles were introduced
+		known = false
+		oldUsername, oldAvatar = ident.Username, profileAvatar(ident)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ghidraUsername string
+	err = tx.
+		QueryRowContext(ctx, "SELECT username FROM passwords WHERE id = ?", ident.ID).
+		Scan(&ghidraUsername)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	mismatch := ghidraUsername != "" && ghidraUsername != ident.Username
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO profiles (id, username, global_name, avatar, rename_pending) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			username = excluded.username,
+			global_name = excluded.global_name,
+			avatar = excluded.avatar,
+			rename_pending = excluded.rename_pending,
+			updated_at = CURRENT_TIMESTAMP`,
+		ident.ID, ident.Username, ident.GlobalName, profileAvatar(ident), mismatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	change = &ProfileChange{
+		OldUsername:    oldUsername,
+		NewUsername:    ident.Username,
+		AvatarChanged:  known && oldAvatar != profileAvatar(ident),
+		GhidraUsername: ghidraUsername,
+		RenamePending:  mismatch,
+	}
+	// Only report a newly discovered mismatch once
+	if !change.UsernameChanged() && !change.AvatarChanged && (!mismatch || renamePending) {
+		return nil, nil
+	}
+	return change, nil
+}
+
+// RenamePending returns whether the user's Ghidra username awaits reconciliation.
+func (d *DB) RenamePending(ctx context.Context, id uint64) (pending bool, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM profiles WHERE id = ? AND rename_pending)", id).
+		Scan(&pending)
+	return
+}
+
+// GetProfile returns the last synced profile of a user, or nil if unknown.
+func (d *DB) GetProfile(ctx context.Context, id uint64) (*common.Identity, error) {
+	ident := &common.Identity{ID: id}
+	var avatar string
+	err := d.
+		QueryRowContext(ctx, "SELECT username, global_name, avatar FROM profiles WHERE id = ?", id).
+		Scan(&ident.Username, &ident.GlobalName, &avatar)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if strings.Contains(avatar, "://") {
+		ident.AvatarURL = avatar
+	} else {
+		ident.AvatarHash = avatar
+	}
+	return ident, nil
+}
+
+// profileAvatar returns the Discord avatar hash, or the avatar URL of other identities.
+func profileAvatar(ident *common.Identity) string {
+	if ident.AvatarHash != "" {
+		return ident.AvatarHash
+	}
+	return ident.AvatarURL
+}
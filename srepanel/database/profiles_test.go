@@ -0,0 +1,100 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+func TestSyncProfile(t *testing.T) {
+	ctx := context.Background()
+	bob := common.Identity{ID: 100, Provider: "discord", Subject: "100", Username: "bob", AvatarHash: "a1"}
+	renamed := bob
+	renamed.Username = "robert"
+	newAvatar := renamed
+	newAvatar.AvatarHash = "a2"
+	newGlobalName := newAvatar
+	newGlobalName.GlobalName = "Robert"
+
+	steps := []struct {
+		name  string
+		ident common.Identity
+		want  *ProfileChange // nil if nothing is reported
+	}{
+		{name: "first sight", ident: bob},
+		{name: "same profile", ident: bob},
+		{
+			name:  "username changed",
+			ident: renamed,
+			want:  &ProfileChange{OldUsername: "bob", NewUsername: "robert"},
+		},
+		{name: "username change seen", ident: renamed},
+		{
+			name:  "avatar changed",
+			ident: newAvatar,
+			want:  &ProfileChange{OldUsername: "robert", NewUsername: "robert", AvatarChanged: true},
+		},
+		{name: "global name changed", ident: newGlobalName},
+		{name: "repeated", ident: newGlobalName},
+	}
+	db := openTestDB(t)
+	for _, step := range steps {
+		ident := step.ident
+		change, err := db.SyncProfile(ctx, &ident)
+		if err != nil {
+			t.Fatalf("%s: SyncProfile() error: %v", step.name, err)
+		}
+		if (change == nil) != (step.want == nil) || (change != nil && *change != *step.want) {
+			t.Fatalf("%s: SyncProfile() = %+v, want %+v", step.name, change, step.want)
+		}
+		profile, err := db.GetProfile(ctx, ident.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile == nil || profile.Username != ident.Username || profile.GlobalName != ident.GlobalName || profile.AvatarHash != ident.AvatarHash {
+			t.Fatalf("%s: stored profile %+v, want %+v", step.name, profile, ident)
+		}
+	}
+}
+
+func TestSyncProfileGhidraUsername(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	bob := &common.Identity{ID: 100, Provider: "discord", Subject: "100", Username: "bob"}
+	if err := db.SetPassword(ctx, 100, "bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if change, err := db.SyncProfile(ctx, bob); err != nil || change != nil {
+		t.Fatalf("SyncProfile() = %+v, %v, want nothing reported", change, err)
+	}
+
+	renamed := *bob
+	renamed.Username = "robert"
+	change, err := db.SyncProfile(ctx, &renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProfileChange{OldUsername: "bob", NewUsername: "robert", GhidraUsername: "bob", RenamePending: true}
+	if change == nil || *change != want {
+		t.Fatalf("SyncProfile() = %+v, want %+v", change, want)
+	}
+	// The pending rename is reported once, but stays pending
+	if change, err := db.SyncProfile(ctx, &renamed); err != nil || change != nil {
+		t.Fatalf("repeated SyncProfile() = %+v, %v, want nothing reported", change, err)
+	}
+	if pending, err := db.RenamePending(ctx, 100); err != nil || !pending {
+		t.Fatalf("RenamePending() = %v, %v, want true", pending, err)
+	}
+
+	// Until the Ghidra account follows the new username
+	if err := db.SetUsername(ctx, 100, "robert"); err != nil {
+		t.Fatal(err)
+	}
+	if change, err := db.SyncProfile(ctx, &renamed); err != nil || change != nil {
+		t.Fatalf("SyncProfile() after rename = %+v, %v, want nothing reported", change, err)
+	}
+	if pending, err := db.RenamePending(ctx, 100); err != nil || pending {
+		t.Errorf("RenamePending() after rename = %v, %v, want false", pending, err)
+	}
+}
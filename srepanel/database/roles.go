@@ -0,0 +1,27 @@
+package database
+
+import (
+	"context"
+)
+
+// RoleAdmin allows managing other users through the panel.
+const RoleAdmin = "admin"
+
+// HasRole returns whether a user holds a role.
+func (d *DB) HasRole(ctx context.Context, userID uint64, role string) (has bool, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM roles WHERE user_id = ? AND role = ?)", userID, role).
+		Scan(&has)
+	return
+}
+
+// SetRole grants or removes a role.
+func (d *DB) SetRole(ctx context.Context, userID uint64, role string, grant bool) error {
+	var err error
+	if grant {
+		_, err = d.ExecContext(ctx, `INSERT OR IGNORE INTO roles (user_id, role) VALUES (?, ?)`, userID, role)
+	} else {
+		_, err = d.ExecContext(ctx, `DELETE FROM roles WHERE user_id = ? AND role = ?`, userID, role)
+	}
+	return err
+}
@@ -11,4 +11,102 @@ CREATE TABLE IF NOT EXISTS passwords (
 );
 
 CREATE UNIQUE INDEX IF NOT EXISTS idx_passwords_username ON passwords (username);
+
+CREATE TABLE IF NOT EXISTS profiles (
+	id UNSIGNED BIG INT PRIMARY KEY,
+	username TEXT NOT NULL,
+	global_name TEXT NOT NULL DEFAULT '',
+	avatar TEXT NOT NULL DEFAULT '',
+	rename_pending BOOLEAN NOT NULL DEFAULT 0,
+	updated_at INTEGER DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS identities (
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	user_id UNSIGNED BIG INT NOT NULL,
+	username TEXT NOT NULL,
+	is_primary BOOLEAN NOT NULL DEFAULT 0,
+	created_at INTEGER DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_identities_user_id ON identities (user_id);
+
+CREATE TABLE IF NOT EXISTS acl_grants (
+	user_id UNSIGNED BIG INT NOT NULL,
+	repo TEXT NOT NULL,
+	perm SHORT INT NOT NULL,
+	prior_perm SHORT INT NOT NULL DEFAULT -1,
+	updated_at INTEGER DEFAULT CURRENT_TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, repo)
+);
+
+CREATE TABLE IF NOT EXISTS user_status (
+	user_id UNSIGNED BIG INT PRIMARY KEY,
+	missing_since INTEGER,
+	disabled_at INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id UNSIGNED BIG INT NOT NULL,
+	device TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	last_seen_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	revoked_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS roles (
+	user_id UNSIGNED BIG INT NOT NULL,
+	role TEXT NOT NULL,
+	PRIMARY KEY (user_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id UNSIGNED BIG INT NOT NULL,
+	action TEXT NOT NULL,
+	detail TEXT NOT NULL,
+	created_at INTEGER DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS consumed_nonces (
+	id INTEGER PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_consumed_nonces_expires_at ON consumed_nonces (expires_at);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id TEXT PRIMARY KEY,
+	user_id UNSIGNED BIG INT NOT NULL,
+	name TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	hash BLOB NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	last_used_at INTEGER,
+	revoked_at INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS totp (
+	user_id UNSIGNED BIG INT PRIMARY KEY,
+	secret BLOB NOT NULL,
+	enabled_at INTEGER,
+	last_step INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	user_id UNSIGNED BIG INT NOT NULL,
+	hash BLOB NOT NULL,
+	used_at INTEGER,
+	PRIMARY KEY (user_id, hash)
+);
 `
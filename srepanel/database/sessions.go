@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+// RevokedSession identifies a revoked session whose token has not expired yet.
+type RevokedSession struct {
+	ID        string
+	ExpiresAt time.Time
+}
+
+// CreateSession records a newly issued session.
+func (d *DB) CreateSession(ctx context.Context, userID uint64, sess *common.Session) error {
+	_, err := d.ExecContext(
+		ctx,
+		`INSERT INTO sessions (id, user_id, device, ip, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		sess.ID, userID, sess.Device, sess.IP, sess.CreatedAt.Unix(), sess.LastSeenAt.Unix(), sess.ExpiresAt.Unix(),
+	)
+	return err
+}
+
+// TouchSession updates when and from where a session was last seen.
+func (d *DB) TouchSession(ctx context.Context, id, ip string, seenAt time.Time) error {
+	_, err := d.ExecContext(
+		ctx,
+		`UPDATE sessions SET last_seen_at = ?, ip = ? WHERE id = ?`,
+		seenAt.Unix(), ip, id,
+	)
+	return err
+}
+
+// ListSessions returns the active sessions of a user, most recently seen first.
+func (d *DB) ListSessions(ctx context.Context, userID uint64) ([]common.Session, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT id, device, ip, created_at, last_seen_at, expires_at FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC`,
+		userID, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []common.Session
+	for rows.Next() {
+		var sess common.Session
+		var createdAt, lastSeenAt, expiresAt int64
+		if err := rows.Scan(&sess.ID, &sess.Device, &sess.IP, &createdAt, &lastSeenAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		sess.CreatedAt = time.Unix(createdAt, 0)
+		sess.LastSeenAt = time.Unix(lastSeenAt, 0)
+		sess.ExpiresAt = time.Unix(expiresAt, 0)
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSessions revokes active sessions of a user.
+// If ids is empty, all sessions of the user except keep are revoked.
+// Returns the revoked sessions.
+func (d *DB) RevokeSessions(ctx context.Context, userID uint64, ids []string, keep string) ([]RevokedSession, error) {
+	sessions, err := d.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	want := make(map[string]bool)
+	for _, id := range ids {
+		want[id] = true
+	}
+
+	var revoked []RevokedSession
+	now := time.Now().Unix()
+	for _, sess := range sessions {
+		if sess.ID == keep || (len(ids) > 0 && !want[sess.ID]) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = ? WHERE id = ?`, now, sess.ID); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, RevokedSession{ID: sess.ID, ExpiresAt: sess.ExpiresAt})
+	}
+	return revoked, tx.Commit()
+}
+
+// ListRevokedSessions returns revoked sessions whose tokens are still unexpired.
+func (d *DB) ListRevokedSessions(ctx context.Context) ([]RevokedSession, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT id, expires_at FROM sessions WHERE revoked_at IS NOT NULL AND expires_at > ?`,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revoked []RevokedSession
+	for rows.Next() {
+		var sess RevokedSession
+		var expiresAt int64
+		if err := rows.Scan(&sess.ID, &expiresAt); err != nil {
+			return nil, err
+		}
+		sess.ExpiresAt = time.Unix(expiresAt, 0)
+		revoked = append(revoked, sess)
+	}
+	return revoked, rows.Err()
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+func TestRevokeSessions(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		keep    string
+		revoked []string
+		active  []string
+	}{
+		{"one session", []string{"b"}, "", []string{"b"}, []string{"a", "c"}},
+		{"all but current", nil, "a", []string{"b", "c"}, []string{"a"}},
+		{"all", nil, "", []string{"a", "b", "c"}, nil},
+		{"other user's session", []string{"x"}, "", nil, []string{"a", "b", "c"}},
+		{"keep wins", []string{"a", "b"}, "a", []string{"b"}, []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := openTestDB(t)
+			now := time.Now()
+			for i, id := range []string{"a", "b", "c", "x"} {
+				userID := uint64(1)
+				if id == "x" {
+					userID = 2
+				}
+				sess := &common.Session{
+					ID:         id,
+					CreatedAt:  now,
+					LastSeenAt: now.Add(-time.Duration(i) * time.Minute),
+					ExpiresAt:  now.Add(time.Hour),
+				}
+				if err := db.CreateSession(ctx, userID, sess); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Expired sessions are neither listed nor revoked
+			if err := db.CreateSession(ctx, 1, &common.Session{ID: "old", ExpiresAt: now.Add(-time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+
+			revoked, err := db.RevokeSessions(ctx, 1, tt.ids, tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var revokedIDs []string
+			for _, sess := range revoked {
+				revokedIDs = append(revokedIDs, sess.ID)
+			}
+			sort.Strings(revokedIDs)
+			if strings.Join(revokedIDs, ",") != strings.Join(tt.revoked, ",") {
+				t.Errorf("revoked %v, want %v", revokedIDs, tt.revoked)
+			}
+
+			sessions, err := db.ListSessions(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var activeIDs []string
+			for _, sess := range sessions {
+				activeIDs = append(activeIDs, sess.ID)
+			}
+			if strings.Join(activeIDs, ",") != strings.Join(tt.active, ",") {
+				t.Errorf("active %v, want %v (most recently seen first)", activeIDs, tt.active)
+			}
+
+			listed, err := db.ListRevokedSessions(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(listed) != len(tt.revoked) {
+				t.Errorf("ListRevokedSessions() = %v, want %v", listed, tt.revoked)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DiscordUser is a panel account linked to a Discord user.
+type DiscordUser struct {
+	UserID       uint64
+	DiscordID    string
+	MissingSince *time.Time // first time the user was found outside all required guilds
+}
+
+// ListDiscordUsers returns all enabled panel accounts linked to Discord.
+// Includes accounts created before identities were tracked, whose ID is the Discord ID.
+func (d *DB) ListDiscordUsers(ctx context.Context) ([]DiscordUser, error) {
+	rows, err := d.QueryContext(
+		ctx,
+		`SELECT u.user_id, u.discord_id, s.missing_since FROM (
+			SELECT user_id, subject AS discord_id FROM identities WHERE provider = 'discord'
+			UNION
+			SELECT id, CAST(id AS TEXT) FROM passwords WHERE id NOT IN (SELECT user_id FROM identities)
+		) u
+		LEFT JOIN user_status s ON s.user_id = u.user_id
+		WHERE s.disabled_at IS NULL`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []DiscordUser
+	for rows.Next() {
+		var user DiscordUser
+		var missingSince sql.NullInt64
+		if err := rows.Scan(&user.UserID, &user.DiscordID, &missingSince); err != nil {
+			return nil, err
+		}
+		if missingSince.Valid {
+			t := time.Unix(missingSince.Int64, 0)
+			user.MissingSince = &t
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// SetMissing records since when a user is no longer a member of the required guilds.
+// A nil time clears the record.
+func (d *DB) SetMissing(ctx context.Context, userID uint64, since *time.Time) error {
+	var value any
+	if since != nil {
+		value = since.Unix()
+	}
+	_, err := d.ExecContext(
+		ctx,
+		`INSERT INTO user_status (user_id, missing_since) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET missing_since = excluded.missing_since`,
+		userID, value,
+	)
+	return err
+}
+
+// DisableUser prevents a user from logging into the panel and Ghidra.
+func (d *DB) DisableUser(ctx context.Context, userID uint64) error {
+	_, err := d.ExecContext(
+		ctx,
+		`INSERT INTO user_status (user_id, disabled_at) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET disabled_at = excluded.disabled_at`,
+		userID, time.Now().Unix(),
+	)
+	return err
+}
+
+// EnableUser reverts DisableUser.
+func (d *DB) EnableUser(ctx context.Context, userID uint64) error {
+	_, err := d.ExecContext(
+		ctx,
+		`UPDATE user_status SET disabled_at = NULL, missing_since = NULL WHERE user_id = ?`,
+		userID,
+	)
+	return err
+}
+
+// IsDisabled returns whether a user was disabled.
+func (d *DB) IsDisabled(ctx context.Context, userID uint64) (disabled bool, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM user_status WHERE user_id = ? AND disabled_at IS NOT NULL)", userID).
+		Scan(&disabled)
+	return
+}
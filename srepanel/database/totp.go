@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GetTOTP returns the TOTP secret of a user and whether enrollment was confirmed.
+// Returns a nil secret if the user has not started enrollment.
+func (d *DB) GetTOTP(ctx context.Context, userID uint64) (secret []byte, enabled bool, err error) {
+	var enabledAt sql.NullInt64
+	err = d.
+		QueryRowContext(ctx, "SELECT secret, enabled_at FROM totp WHERE user_id = ?", userID).
+		Scan(&secret, &enabledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	return secret, enabledAt.Valid, err
+}
+
+// HasTOTP returns whether a user has confirmed TOTP enrollment.
+func (d *DB) HasTOTP(ctx context.Context, userID uint64) (has bool, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM totp WHERE user_id = ? AND enabled_at IS NOT NULL)", userID).
+		Scan(&has)
+	return
+}
+
+// SetPendingTOTP stores the secret of an enrollment awaiting confirmation.
+// Confirmed enrollments are left untouched.
+func (d *DB) SetPendingTOTP(ctx context.Context, userID uint64, secret []byte) error {
+	_, err := d.ExecContext(
+		ctx,
+		`INSERT INTO totp (user_id, secret) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0
+		WHERE totp.enabled_at IS NULL`,
+		userID, secret,
+	)
+	return err
+}
+
+// EnableTOTP confirms a pending enrollment with the time step of the code
+// the user entered, and replaces the recovery codes of the user.
+func (d *DB) EnableTOTP(ctx context.Context, userID uint64, step int64, recoveryHashes [][]byte) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE totp SET enabled_at = ?, last_step = ? WHERE user_id = ? AND enabled_at IS NULL`,
+		time.Now().Unix(), step, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.New("no pending TOTP enrollment")
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code of the given time step was accepted.
+// Returns false if a code of the same or a later step was accepted before.
+func (d *DB) UseTOTPStep(ctx context.Context, userID uint64, step int64) (bool, error) {
+	res, err := d.ExecContext(
+		ctx,
+		`UPDATE totp SET last_step = ? WHERE user_id = ? AND enabled_at IS NOT NULL AND last_step < ?`,
+		step, userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of a user.
+func (d *DB) DisableTOTP(ctx context.Context, userID uint64) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes replaces the recovery codes of a user.
+func (d *DB) ReplaceRecoveryCodes(ctx context.Context, userID uint64, hashes [][]byte) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uint64, hashes [][]byte) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?)`,
+			userID, hash,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks a recovery code as used.
+// Returns false if the user has no such unused code.
+func (d *DB) UseRecoveryCode(ctx context.Context, userID uint64, hash []byte) (bool, error) {
+	res, err := d.ExecContext(
+		ctx,
+		`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND hash = ? AND used_at IS NULL`,
+		time.Now().Unix(), userID, hash,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left.
+func (d *DB) CountRecoveryCodes(ctx context.Context, userID uint64) (n int, err error) {
+	err = d.
+		QueryRowContext(ctx, "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).
+		Scan(&n)
+	return
+}
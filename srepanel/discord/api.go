@@ -1,5 +1,15 @@
 package discord
 
+// --------- //
+// Guild API //
+// --------- //
+
+// https://discord.com/developers/docs/resources/guild#guild-member-object
+type GuildMember struct {
+	Nick  string   `json:"nick"`
+	Roles []string `json:"roles"`
+}
+
 // ----------- //
 // Channel API //
 // ----------- //
@@ -19,10 +29,11 @@ type EmbedField struct {
 
 // https://discord.com/developers/docs/resources/channel#embed-object
 type Embed struct {
-	Title  string       `json:"title"`
-	Color  int          `json:"color"`
-	Author EmbedAuthor  `json:"author"`
-	Fields []EmbedField `json:"fields"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Color       int          `json:"color"`
+	Author      EmbedAuthor  `json:"author"`
+	Fields      []EmbedField `json:"fields"`
 }
 
 // ----------- //
@@ -4,44 +4,120 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"golang.org/x/oauth2"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"go.mkw.re/ghidra-panel/common"
 	"go.mkw.re/ghidra-panel/csrf"
 )
 
+// DefaultAPIBase is the base URL of the Discord REST API.
+const DefaultAPIBase = "https://discord.com/api"
+
 var Endpoint = oauth2.Endpoint{
 	AuthURL:   "https://discord.com/oauth2/authorize",
-	TokenURL:  "https://discord.com/api/oauth2/token",
+	TokenURL:  DefaultAPIBase + "/oauth2/token",
 	AuthStyle: oauth2.AuthStyleInParams,
 }
 
+// ErrNotGuildMember is returned when a user is not in any of the required guilds.
+var ErrNotGuildMember = errors.New("user is not a member of a required guild")
+
+// Options configures optional behavior of Discord login.
+type Options struct {
+	// APIBase overrides DefaultAPIBase, e.g. to test against a fake API.
+	APIBase string
+	// RequiredGuilds restricts login to members of at least one of these guilds.
+	RequiredGuilds []string
+	// RoleGuilds lists guilds whose member roles are looked up at login.
+	RoleGuilds []string
+	// State configures protection of the OAuth state parameter.
+	State csrf.Options
+}
+
 type Auth struct {
 	oauth2.Config
-	prot *csrf.OneTime
+	prot           *csrf.OneTime
+	apiBase        string
+	requiredGuilds []string
+	memberGuilds   []string // required guilds and role guilds
 }
 
-func NewAuth(clientID, clientSecret, redirectURL string) *Auth {
+func NewAuth(clientID, clientSecret, redirectURL string, opts Options) *Auth {
+	endpoint := Endpoint
+	apiBase := DefaultAPIBase
+	if opts.APIBase != "" {
+		apiBase = strings.TrimSuffix(opts.APIBase, "/")
+		endpoint.TokenURL = apiBase + "/oauth2/token"
+	}
+
+	var memberGuilds []string
+	seen := make(map[string]bool)
+	for _, guildIDs := range [][]string{opts.RequiredGuilds, opts.RoleGuilds} {
+		for _, guildID := range guildIDs {
+			if !seen[guildID] {
+				seen[guildID] = true
+				memberGuilds = append(memberGuilds, guildID)
+			}
+		}
+	}
+
+	scopes := []string{"identify"}
+	if len(memberGuilds) > 0 {
+		scopes = append(scopes, "guilds.members.read")
+	}
+
 	config := &Auth{
 		Config: oauth2.Config{
 			ClientID:     clientID,
 			ClientSecret: clientSecret,
-			Endpoint:     Endpoint,
+			Endpoint:     endpoint,
 			RedirectURL:  redirectURL,
-			Scopes:       []string{"identify"},
+			Scopes:       scopes,
 		},
-		prot: csrf.NewOneTime(),
+		prot:           csrf.NewOneTime(opts.State),
+		apiBase:        apiBase,
+		requiredGuilds: opts.RequiredGuilds,
+		memberGuilds:   memberGuilds,
 	}
 	return config
 }
 
-func (c *Auth) AuthURL() string {
-	return c.Config.AuthCodeURL(c.prot.Issue(), oauth2.AccessTypeOnline)
+func (c *Auth) ID() string {
+	return "discord"
+}
+
+func (c *Auth) Name() string {
+	return "Discord"
+}
+
+// SetStateKeys replaces the keys of OAuth states, e.g. after key rotation.
+// Only possible if the state options had keys and a replay store.
+func (c *Auth) SetStateKeys(keys [][]byte) {
+	c.prot.SetKeys(keys)
+}
+
+// verifierCookie holds the PKCE code verifier of a login in progress.
+const verifierCookie = "pkce_discord"
+
+// AuthURL returns the authorization URL of a new login.
+// The return path is carried through the signed state.
+func (c *Auth) AuthURL(wr http.ResponseWriter, returnTo string) string {
+	state := c.prot.Issue(returnTo)
+	verifier := c.prot.BindVerifier(wr, verifierCookie, c.Config.RedirectURL)
+	return c.Config.AuthCodeURL(
+		state,
+		oauth2.AccessTypeOnline,
+		oauth2.SetAuthURLParam("code_challenge", csrf.Challenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
 }
 
 // HandleRedirect handles an OAuth2 redirect from the identity provider.
-func (c *Auth) HandleRedirect(wr http.ResponseWriter, req *http.Request) (ident *common.Identity, err error) {
+func (c *Auth) HandleRedirect(wr http.ResponseWriter, req *http.Request) (ident *common.Identity, returnTo string, err error) {
 	ctx := req.Context()
 
 	errID := req.FormValue("error")
@@ -49,10 +125,10 @@ func (c *Auth) HandleRedirect(wr http.ResponseWriter, req *http.Request) (ident
 	if errID != "" {
 		if errID == "access_denied" {
 			http.Redirect(wr, req, "/login", http.StatusTemporaryRedirect)
-			return nil, nil
+			return nil, "", nil
 		}
 		http.Error(wr, errDescription, http.StatusUnauthorized)
-		return nil, nil
+		return nil, "", nil
 	}
 
 	query := req.URL.Query()
@@ -60,21 +136,39 @@ func (c *Auth) HandleRedirect(wr http.ResponseWriter, req *http.Request) (ident
 	state := query.Get("state")
 
 	// Check CSRF token validity -- do not consume yet
-	csrfID, err := c.prot.Check(state)
+	csrfID, returnTo, err := c.prot.Check(state)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	verifier, err := csrf.BoundVerifier(wr, req, verifierCookie, c.Config.RedirectURL)
+	if err != nil {
+		return nil, "", err
 	}
 
 	// Request authorization token from Discord
-	token, err := c.Config.Exchange(ctx, code)
+	token, err := c.Config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Ask Discord for user ID/username associated with token
 	ident, err = c.GetDiscordIdentity(ctx, token)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	// Look up guild memberships
+	if len(c.memberGuilds) > 0 {
+		ident.GuildRoles, err = c.getGuildRoles(ctx, token)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Restrict login to guild members
+	if len(c.requiredGuilds) > 0 && !c.isGuildMember(ident) {
+		_ = c.prot.Consume(csrfID)
+		return nil, "", ErrNotGuildMember
 	}
 
 	// Prevent CSRF token reuse
@@ -83,7 +177,7 @@ func (c *Auth) HandleRedirect(wr http.ResponseWriter, req *http.Request) (ident
 }
 
 func (c *Auth) GetDiscordIdentity(ctx context.Context, token *oauth2.Token) (ident *common.Identity, err error) {
-	meReq, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://discord.com/api/oauth2/@me", nil)
+	meReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.apiBase+"/oauth2/@me", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -96,9 +190,10 @@ func (c *Auth) GetDiscordIdentity(ctx context.Context, token *oauth2.Token) (ide
 
 	var info struct {
 		User struct {
-			ID       uint64 `json:"id,string"`
-			Username string `json:"username"`
-			Avatar   string `json:"avatar"`
+			ID         uint64 `json:"id,string"`
+			Username   string `json:"username"`
+			GlobalName string `json:"global_name"`
+			Avatar     string `json:"avatar"`
 		} `json:"user"`
 	}
 	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
@@ -111,7 +206,65 @@ func (c *Auth) GetDiscordIdentity(ctx context.Context, token *oauth2.Token) (ide
 
 	return &common.Identity{
 		ID:         info.User.ID,
+		Provider:   c.ID(),
+		Subject:    strconv.FormatUint(info.User.ID, 10),
 		Username:   info.User.Username,
+		GlobalName: info.User.GlobalName,
 		AvatarHash: info.User.Avatar,
 	}, nil
 }
+
+// getGuildRoles returns the user's roles in each guild they are a member of.
+func (c *Auth) getGuildRoles(ctx context.Context, token *oauth2.Token) (map[string][]string, error) {
+	guildRoles := make(map[string][]string)
+	for _, guildID := range c.memberGuilds {
+		member, err := c.GetGuildMember(ctx, token, guildID)
+		if err != nil {
+			return nil, err
+		}
+		if member != nil {
+			guildRoles[guildID] = member.Roles
+		}
+	}
+	return guildRoles, nil
+}
+
+// isGuildMember returns whether the user is in at least one required guild.
+func (c *Auth) isGuildMember(ident *common.Identity) bool {
+	for _, guildID := range c.requiredGuilds {
+		if _, ok := ident.GuildRoles[guildID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetGuildMember returns the user's membership in a guild, or nil if not a member.
+// Requires the guilds.members.read scope.
+func (c *Auth) GetGuildMember(ctx context.Context, token *oauth2.Token, guildID string) (*GuildMember, error) {
+	memberReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.apiBase+"/users/@me/guilds/"+guildID+"/member", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.Config.Client(ctx, token).Do(memberReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// Unknown guild, i.e. user not a member
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("guild member request failed: %s", res.Status)
+	}
+
+	var member GuildMember
+	if err := json.NewDecoder(res.Body).Decode(&member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
@@ -0,0 +1,309 @@
+package discord
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPI is a Discord API that knows a single user.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	challenge string // PKCE challenge of the login in progress
+	meStatus  int
+	members   map[string]int // guild ID => status of the member request
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+	f := &fakeAPI{meStatus: http.StatusOK}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/token", func(wr http.ResponseWriter, req *http.Request) {
+		f.mu.Lock()
+		challenge := f.challenge
+		f.mu.Unlock()
+		sum := sha256.Sum256([]byte(req.PostFormValue("code_verifier")))
+		if req.PostFormValue("code") != "good" || req.PostFormValue("client_id") != "client" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			wr.Header().Set("content-type", "application/json")
+			wr.WriteHeader(http.StatusBadRequest)
+			_, _ = wr.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		wr.Header().Set("content-type", "application/json")
+		_, _ = wr.Write([]byte(`{"access_token":"access","token_type":"Bearer","expires_in":3600}`))
+	})
+	mux.HandleFunc("/oauth2/@me", func(wr http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("authorization") != "Bearer access" {
+			http.Error(wr, `{"message":"401: Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		f.mu.Lock()
+		status := f.meStatus
+		f.mu.Unlock()
+		if status != http.StatusOK {
+			http.Error(wr, `{"message":"error"}`, status)
+			return
+		}
+		_, _ = wr.Write([]byte(`{"user":{"id":"80351110224678912","username":"nelly","global_name":"Nelly","avatar":"8342729096ea3675442027381ff50dfe"}}`))
+	})
+	mux.HandleFunc("/users/@me/guilds/", func(wr http.ResponseWriter, req *http.Request) {
+		guildID := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/users/@me/guilds/"), "/member")
+		f.mu.Lock()
+		status, ok := f.members[guildID]
+		f.mu.Unlock()
+		if !ok {
+			status = http.StatusNotFound
+		}
+		if status != http.StatusOK {
+			http.Error(wr, `{"message":"error"}`, status)
+			return
+		}
+		_ = json.NewEncoder(wr).Encode(GuildMember{Roles: []string{"role-" + guildID}})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// login starts a login and returns its state and the cookies
+// binding it to the browser.
+func (f *fakeAPI) login(t *testing.T, auth *Auth, returnTo string) (string, []*http.Cookie) {
+	t.Helper()
+	wr := httptest.NewRecorder()
+	authURL, err := url.Parse(auth.AuthURL(wr, returnTo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("login without S256 PKCE challenge: %s", authURL)
+	}
+	f.mu.Lock()
+	f.challenge = query.Get("code_challenge")
+	f.mu.Unlock()
+	return query.Get("state"), wr.Result().Cookies()
+}
+
+func redirectRequest(query string, cookies ...*http.Cookie) (*httptest.ResponseRecorder, *http.Request) {
+	req := httptest.NewRequest(http.MethodGet, "/redirect?"+query, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return httptest.NewRecorder(), req
+}
+
+func TestHandleRedirect(t *testing.T) {
+	tests := []struct {
+		name       string
+		required   []string
+		roleGuilds []string
+		members    map[string]int
+		meStatus   int
+		code       string
+		roles      map[string][]string
+		err        error  // expected error, matched with errors.Is
+		errText    string // expected error text, if err is nil
+	}{
+		{
+			name: "no guilds",
+		},
+		{
+			name:     "member of required guild",
+			required: []string{"1"},
+			members:  map[string]int{"1": http.StatusOK},
+			roles:    map[string][]string{"1": {"role-1"}},
+		},
+		{
+			name:     "member of second required guild",
+			required: []string{"1", "2"},
+			members:  map[string]int{"2": http.StatusOK},
+			roles:    map[string][]string{"2": {"role-2"}},
+		},
+		{
+			name:     "not a member",
+			required: []string{"1"},
+			err:      ErrNotGuildMember,
+		},
+		{
+			name:       "role guild only",
+			roleGuilds: []string{"3"},
+			roles:      map[string][]string{},
+		},
+		{
+			name:       "required and role guilds",
+			required:   []string{"1"},
+			roleGuilds: []string{"1", "3"},
+			members:    map[string]int{"1": http.StatusOK, "3": http.StatusOK},
+			roles:      map[string][]string{"1": {"role-1"}, "3": {"role-3"}},
+		},
+		{
+			name:     "guild API error",
+			required: []string{"1"},
+			members:  map[string]int{"1": http.StatusInternalServerError},
+			errText:  "guild member request failed",
+		},
+		{
+			name:    "token exchange rejected",
+			code:    "bad",
+			errText: "invalid_grant",
+		},
+		{
+			name:     "identity request failed",
+			meStatus: http.StatusInternalServerError,
+			errText:  "invalid response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAPI(t)
+			f.members = tt.members
+			if tt.meStatus != 0 {
+				f.meStatus = tt.meStatus
+			}
+			auth := NewAuth("client", "secret", "https://panel.example/redirect", Options{
+				APIBase:        f.URL,
+				RequiredGuilds: tt.required,
+				RoleGuilds:     tt.roleGuilds,
+			})
+			state, cookies := f.login(t, auth, "/return")
+			code := tt.code
+			if code == "" {
+				code = "good"
+			}
+
+			wr, req := redirectRequest("code="+code+"&state="+url.QueryEscape(state), cookies...)
+			ident, returnTo, err := auth.HandleRedirect(wr, req)
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("HandleRedirect() error = %v, want %v", err, tt.err)
+				}
+				return
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("HandleRedirect() error = %v, want %q", err, tt.errText)
+				}
+				return
+			case err != nil:
+				t.Fatal("HandleRedirect() error: ", err)
+			}
+
+			if ident.ID != 80351110224678912 || ident.Username != "nelly" || ident.GlobalName != "Nelly" ||
+				ident.Provider != "discord" || ident.Subject != "80351110224678912" {
+				t.Errorf("identity %+v does not match the Discord user", ident)
+			}
+			if !reflect.DeepEqual(ident.GuildRoles, tt.roles) {
+				t.Errorf("guild roles %v, want %v", ident.GuildRoles, tt.roles)
+			}
+			if returnTo != "/return" {
+				t.Errorf("return path %q, want /return", returnTo)
+			}
+
+			// The state is consumed
+			wr, req = redirectRequest("code=good&state="+url.QueryEscape(state), cookies...)
+			if _, _, err := auth.HandleRedirect(wr, req); err == nil {
+				t.Error("replayed redirect succeeded")
+			}
+		})
+	}
+}
+
+func TestHandleRedirectProviderError(t *testing.T) {
+	f := newFakeAPI(t)
+	auth := NewAuth("client", "secret", "https://panel.example/redirect", Options{APIBase: f.URL})
+
+	tests := []struct {
+		query    string
+		status   int
+		location string
+	}{
+		{"error=access_denied", http.StatusTemporaryRedirect, "/login"},
+		{"error=server_error&error_description=broken", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			wr, req := redirectRequest(tt.query)
+			ident, _, err := auth.HandleRedirect(wr, req)
+			if ident != nil || err != nil {
+				t.Fatalf("HandleRedirect() = %v, %v, want response written", ident, err)
+			}
+			if wr.Code != tt.status || wr.Header().Get("location") != tt.location {
+				t.Errorf("response %d %q, want %d %q", wr.Code, wr.Header().Get("location"), tt.status, tt.location)
+			}
+		})
+	}
+}
+
+func TestHandleRedirectForgedState(t *testing.T) {
+	f := newFakeAPI(t)
+	auth := NewAuth("client", "secret", "https://panel.example/redirect", Options{APIBase: f.URL})
+	other := NewAuth("client", "secret", "https://panel.example/redirect", Options{APIBase: f.URL})
+
+	otherState, cookies := f.login(t, other, "/")
+	for _, state := range []string{"", "v1:AAAA", otherState} {
+		wr, req := redirectRequest("code=good&state="+url.QueryEscape(state), cookies...)
+		if _, _, err := auth.HandleRedirect(wr, req); err == nil {
+			t.Errorf("state %q accepted", state)
+		}
+	}
+}
+
+// The PKCE verifier is random and kept by the browser that started the
+// login, so the state alone does not redeem an authorization code.
+func TestHandleRedirectPKCE(t *testing.T) {
+	f := newFakeAPI(t)
+	auth := NewAuth("client", "secret", "https://panel.example/redirect", Options{APIBase: f.URL})
+
+	// An attacker starts a login in their browser, then
+	// the victim's code and state are replayed with the attacker's cookie.
+	_, attackerCookies := f.login(t, auth, "/")
+	state, cookies := f.login(t, auth, "/")
+	if len(cookies) != 1 {
+		t.Fatalf("login set %d cookies, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != verifierCookie || cookie.Path != "/redirect" || !cookie.HttpOnly || !cookie.Secure ||
+		cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+		t.Errorf("verifier cookie %+v not bound to the redirect", cookie)
+	}
+	if cookie.Value == attackerCookies[0].Value {
+		t.Fatal("verifiers of different logins are equal")
+	}
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		ok      bool
+	}{
+		{"no verifier", nil, false},
+		{"verifier of another login", attackerCookies, false},
+		{"verifier of the login", cookies, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wr, req := redirectRequest("code=good&state="+url.QueryEscape(state), tt.cookies...)
+			_, _, err := auth.HandleRedirect(wr, req)
+			if (err == nil) != tt.ok {
+				t.Fatalf("HandleRedirect() error = %v, want success %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			cleared := wr.Result().Cookies()
+			if len(cleared) != 1 || cleared[0].Name != verifierCookie || cleared[0].MaxAge >= 0 {
+				t.Errorf("verifier cookie not cleared: %v", cleared)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRateLimitRetries bounds how often a request is retried after being rate limited.
+const maxRateLimitRetries = 3
+
+// errUnknownMember is the JSON error code of users not in a guild.
+const errUnknownMember = 10007
+
+// Bot accesses the Discord API with a bot token.
+type Bot struct {
+	token   string
+	apiBase string
+	client  *http.Client
+}
+
+// NewBot creates a bot client. An empty apiBase selects DefaultAPIBase.
+func NewBot(token, apiBase string) *Bot {
+	if apiBase == "" {
+		apiBase = DefaultAPIBase
+	}
+	return &Bot{
+		token:   token,
+		apiBase: strings.TrimSuffix(apiBase, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetGuildMember returns a user's membership in a guild, or nil if not a member.
+// The bot must be a member of the guild, otherwise an error is returned.
+func (b *Bot) GetGuildMember(ctx context.Context, guildID, userID string) (*GuildMember, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.apiBase+"/guilds/"+guildID+"/members/"+userID, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("authorization", "Bot "+b.token)
+
+		res, err := b.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch res.StatusCode {
+		case http.StatusOK:
+			var member GuildMember
+			err := json.NewDecoder(res.Body).Decode(&member)
+			res.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			return &member, nil
+		case http.StatusNotFound:
+			// Also returned for unknown guilds, e.g. if the bot was removed,
+			// which must not be mistaken for every user having left
+			var apiErr struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			err := json.NewDecoder(res.Body).Decode(&apiErr)
+			res.Body.Close()
+			if err == nil && apiErr.Code == errUnknownMember {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("guild member request failed: %s (code %d: %s)", res.Status, apiErr.Code, apiErr.Message)
+		case http.StatusTooManyRequests:
+			res.Body.Close()
+			if attempt >= maxRateLimitRetries {
+				return nil, fmt.Errorf("guild member request rate limited")
+			}
+			retryAfter, _ := strconv.ParseFloat(res.Header.Get("retry-after"), 64)
+			if retryAfter <= 0 {
+				retryAfter = 1
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(retryAfter * float64(time.Second))):
+			}
+		default:
+			res.Body.Close()
+			return nil, fmt.Errorf("guild member request failed: %s", res.Status)
+		}
+	}
+}
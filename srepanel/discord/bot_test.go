@@ -0,0 +1,79 @@
+package discord
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// Statuses standing for other 404 responses than an unknown member.
+const (
+	statusUnknownGuild  = -1
+	statusNotFoundPlain = -2
+)
+
+func TestBotGetGuildMember(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // of consecutive requests, the last one repeats
+		member   bool
+		err      bool
+		requests int
+	}{
+		{"member", []int{http.StatusOK}, true, false, 1},
+		{"not a member", []int{http.StatusNotFound}, false, false, 1},
+		{"unknown guild", []int{statusUnknownGuild}, false, true, 1},
+		{"not found without code", []int{statusNotFoundPlain}, false, true, 1},
+		{"rate limited once", []int{http.StatusTooManyRequests, http.StatusOK}, true, false, 2},
+		{"rate limited", []int{http.StatusTooManyRequests}, false, true, maxRateLimitRetries + 1},
+		{"server error", []int{http.StatusBadGateway}, false, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+				n := int(requests.Add(1))
+				if req.Header.Get("authorization") != "Bot token" || req.URL.Path != "/guilds/1/members/42" {
+					http.Error(wr, "unexpected request", http.StatusBadRequest)
+					return
+				}
+				status := tt.statuses[len(tt.statuses)-1]
+				if n <= len(tt.statuses) {
+					status = tt.statuses[n-1]
+				}
+				switch status {
+				case http.StatusOK:
+					_, _ = wr.Write([]byte(`{"nick":"bobby","roles":["7"]}`))
+				case http.StatusTooManyRequests:
+					wr.Header().Set("retry-after", "0.001")
+					http.Error(wr, `{"message":"rate limited"}`, status)
+				case http.StatusNotFound:
+					http.Error(wr, `{"message":"Unknown Member","code":10007}`, status)
+				case statusUnknownGuild:
+					http.Error(wr, `{"message":"Unknown Guild","code":10004}`, http.StatusNotFound)
+				case statusNotFoundPlain:
+					http.Error(wr, "404 page not found", http.StatusNotFound)
+				default:
+					http.Error(wr, `{"message":"error"}`, status)
+				}
+			}))
+			defer srv.Close()
+
+			member, err := NewBot("token", srv.URL+"/").GetGuildMember(context.Background(), "1", "42")
+			if (err != nil) != tt.err {
+				t.Fatalf("GetGuildMember() error = %v, want error %v", err, tt.err)
+			}
+			if (member != nil) != tt.member {
+				t.Fatalf("GetGuildMember() = %+v, want member %v", member, tt.member)
+			}
+			if member != nil && (member.Nick != "bobby" || len(member.Roles) != 1 || member.Roles[0] != "7") {
+				t.Errorf("GetGuildMember() = %+v", member)
+			}
+			if n := int(requests.Load()); n != tt.requests {
+				t.Errorf("%d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+}
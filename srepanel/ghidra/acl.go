@@ -17,6 +17,9 @@ const (
 	PermAdminStr = "ADMIN"
 )
 
+// PermNone denotes the absence of an ACL entry.
+const PermNone = -1
+
 const AnonAllowedStr = "=ANONYMOUS_ALLOWED"
 
 var PermStrs = []string{
@@ -25,6 +28,16 @@ var PermStrs = []string{
 	PermAdmin: PermAdminStr,
 }
 
+// ParsePerm converts a permission string such as "WRITE" to a permission level.
+func ParsePerm(s string) (perm int, ok bool) {
+	for perm, str := range PermStrs {
+		if str == s {
+			return perm, true
+		}
+	}
+	return PermNone, false
+}
+
 // ACL is an in-memory representation of a repo access list.
 type ACL struct {
 	AnonymousAccess bool
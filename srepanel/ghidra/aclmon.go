@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -15,6 +16,9 @@ import (
 type ACLMon struct {
 	Dir  string
 	ACLs atomic.Pointer[ACLState]
+
+	writeLock sync.Mutex // serializes ACL file writes
+	failures  atomic.Uint64
 }
 
 // Run starts the ACL monitor main loop.
@@ -46,9 +50,18 @@ func (a *ACLMon) Run(ctx context.Context) error {
 }
 
 func (a *ACLMon) updateACLs() (*ACLState, error) {
+	acls, err := readACLs(a.Dir)
+	if err != nil {
+		a.failures.Add(1)
+	}
+	return acls, err
+}
+
+func readACLs(dir string) (*ACLState, error) {
 	// TODO if only reading one repo fails (e.g. due to perms),
 	//      perhaps should not fail entire batch.
-	repos, err := DiscoverRepos(a.Dir)
+	start := time.Now()
+	repos, err := DiscoverRepos(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to discover repos: %w", err)
 	}
@@ -58,9 +71,25 @@ func (a *ACLMon) updateACLs() (*ACLState, error) {
 			return nil, fmt.Errorf("failed to add repo: %w", err)
 		}
 	}
+	acls.RefreshDuration = time.Since(start)
 	return acls, nil
 }
 
+// Failures returns how many ACL refreshes failed.
+func (a *ACLMon) Failures() uint64 {
+	return a.failures.Load()
+}
+
+// Refresh re-reads all ACLs immediately, e.g. after they were modified.
+func (a *ACLMon) Refresh() {
+	acls, err := a.updateACLs()
+	if err != nil {
+		log.Printf("error updating ACLs: %v", err)
+		return
+	}
+	a.ACLs.Store(acls)
+}
+
 func (a *ACLMon) Get() *ACLState {
 	return a.ACLs.Load()
 }
@@ -87,10 +116,11 @@ func DiscoverRepos(dir string) ([]string, error) {
 // ACLState indexes all ACLs of a Ghidra instance at a given point in time.
 // Immutable object, safe to read concurrently.
 type ACLState struct {
-	UpdatedAt  time.Time
-	ACLs       map[string]*ACL             // repo => ACL
-	AnonAccess []string                    // repos with anon access
-	UserAccess map[string][]UserRepoAccess // user => repos
+	UpdatedAt       time.Time
+	RefreshDuration time.Duration               // time taken to read all ACLs
+	ACLs            map[string]*ACL             // repo => ACL
+	AnonAccess      []string                    // repos with anon access
+	UserAccess      map[string][]UserRepoAccess // user => repos
 }
 
 // UserRepoAccess represents a user's access to a repo.
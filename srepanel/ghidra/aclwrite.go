@@ -0,0 +1,70 @@
+package ghidra
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteUserPerm sets the permission of a user in a repo's ACL file.
+// PermNone removes the user's entry. Other lines are preserved.
+//
+// The file is replaced atomically. Note that the Ghidra server only picks
+// up external ACL changes when it reloads the repository.
+func WriteUserPerm(repoDir, user string, perm int) error {
+	aclPath := filepath.Join(repoDir, "userAccess.acl")
+	info, err := os.Stat(aclPath)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(aclPath)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	found := false
+	scn := bufio.NewScanner(bytes.NewReader(content))
+	for scn.Scan() {
+		line := scn.Text()
+		if !strings.HasPrefix(line, ";") {
+			parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+			if len(parts) == 2 && strings.TrimSpace(parts[0]) == user {
+				found = true
+				if perm != PermNone {
+					fmt.Fprintf(&out, "%s=%s\n", user, PermStrs[perm])
+				}
+				continue
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := scn.Err(); err != nil {
+		return err
+	}
+	if !found && perm != PermNone {
+		fmt.Fprintf(&out, "%s=%s\n", user, PermStrs[perm])
+	}
+
+	tmp, err := os.CreateTemp(repoDir, ".userAccess.acl.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), aclPath)
+}
@@ -0,0 +1,182 @@
+package ghidra
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ACLChange describes a change to a user's ACL entry in a repo.
+type ACLChange struct {
+	Repo string
+	User string
+	Old  int // PermNone if there was no entry
+	New  int // PermNone if the entry is removed
+}
+
+func (c ACLChange) String() string {
+	return fmt.Sprintf("%s: %s %s => %s", c.Repo, c.User, permString(c.Old), permString(c.New))
+}
+
+func permString(perm int) string {
+	if perm == PermNone {
+		return "NONE"
+	}
+	return PermStrs[perm]
+}
+
+// Grant is an ACL entry made by Reconcile.
+type Grant struct {
+	Perm  int // permission granted
+	Prior int // entry made by hand that the grant replaced, PermNone if there was none
+}
+
+// Reconcile brings a user's ACL entries in line with the permissions
+// granted by automation, such as role rules.
+//
+// want maps repos to the permission the user should hold.
+// managed maps repos to entries previously made by Reconcile.
+// Entries made by hand are never lowered: they are upgraded if automation
+// grants more, and restored once it no longer does. A managed entry that
+// was changed by hand is left alone.
+//
+// Returns the changes made (or that would be made, if dryRun is set)
+// and the new set of managed entries. If writing an entry fails, the
+// previous managed entries of that repo and the ones not yet visited are
+// kept, so the returned set can be stored regardless.
+func (a *ACLMon) Reconcile(user string, want map[string]int, managed map[string]Grant, dryRun bool) (changes []ACLChange, newManaged map[string]Grant, err error) {
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+
+	acls := a.Get()
+	if acls == nil {
+		return nil, managed, fmt.Errorf("ACLs not loaded yet")
+	}
+
+	newManaged = make(map[string]Grant)
+	repos := make([]string, 0, len(acls.ACLs))
+	for repo := range acls.ACLs {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	for i, repo := range repos {
+		current, ok := acls.ACLs[repo].Users[user]
+		if !ok {
+			current = PermNone
+		}
+		wantPerm, wanted := want[repo]
+		grant, isManaged := managed[repo]
+		// Entry changed by hand since we last touched it
+		if isManaged && current != grant.Perm {
+			isManaged = false
+		}
+		if !isManaged {
+			grant = Grant{Perm: current, Prior: current}
+		}
+
+		target := current
+		switch {
+		case wanted && wantPerm > grant.Prior:
+			target = wantPerm
+			newManaged[repo] = Grant{Perm: wantPerm, Prior: grant.Prior}
+		case isManaged:
+			// Automation grants no more than the entry made by hand
+			target = grant.Prior
+		}
+		if target == current {
+			continue
+		}
+
+		change := ACLChange{Repo: repo, User: user, Old: current, New: target}
+		if dryRun {
+			changes = append(changes, change)
+			continue
+		}
+		if err := WriteUserPerm(filepath.Join(a.Dir, repo), user, target); err != nil {
+			for _, repo := range repos[i:] {
+				if grant, ok := managed[repo]; ok {
+					newManaged[repo] = grant
+				} else {
+					delete(newManaged, repo)
+				}
+			}
+			if len(changes) > 0 {
+				a.Refresh()
+			}
+			return changes, newManaged, fmt.Errorf("failed to update ACL of %s: %w", repo, err)
+		}
+		changes = append(changes, change)
+	}
+
+	if dryRun {
+		return changes, managed, nil
+	}
+	if len(changes) > 0 {
+		a.Refresh()
+	}
+	return changes, newManaged, nil
+}
+
+// RemoveUser deletes all ACL entries of a user, e.g. when deprovisioning them.
+func (a *ACLMon) RemoveUser(user string) (changes []ACLChange, err error) {
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+
+	acls := a.Get()
+	if acls == nil {
+		return nil, fmt.Errorf("ACLs not loaded yet")
+	}
+	for _, access := range acls.QueryUser(user) {
+		change := ACLChange{Repo: access.Repo, User: user, Old: access.Perm, New: PermNone}
+		if err := WriteUserPerm(filepath.Join(a.Dir, access.Repo), user, PermNone); err != nil {
+			return changes, fmt.Errorf("failed to update ACL of %s: %w", access.Repo, err)
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) > 0 {
+		a.Refresh()
+	}
+	return changes, nil
+}
+
+var (
+	ErrUnknownRepo = errors.New("unknown repo")
+	ErrInvalidUser = errors.New("invalid user name")
+)
+
+// SetUserPerm sets the permission of a user in a repo, e.g. on behalf of an admin.
+// PermNone removes the user's entry.
+func (a *ACLMon) SetUserPerm(repo, user string, perm int) (change ACLChange, err error) {
+	if user == "" || user != strings.TrimSpace(user) || strings.HasPrefix(user, ";") ||
+		strings.ContainsAny(user, "=\n\r\x00") {
+		return change, ErrInvalidUser
+	}
+
+	a.writeLock.Lock()
+	defer a.writeLock.Unlock()
+
+	acls := a.Get()
+	if acls == nil {
+		return change, fmt.Errorf("ACLs not loaded yet")
+	}
+	acl, ok := acls.ACLs[repo]
+	if !ok {
+		return change, ErrUnknownRepo
+	}
+	current, ok := acl.Users[user]
+	if !ok {
+		current = PermNone
+	}
+	change = ACLChange{Repo: repo, User: user, Old: current, New: perm}
+	if current == perm {
+		return change, nil
+	}
+	if err := WriteUserPerm(filepath.Join(a.Dir, repo), user, perm); err != nil {
+		return change, fmt.Errorf("failed to update ACL of %s: %w", repo, err)
+	}
+	a.Refresh()
+	return change, nil
+}
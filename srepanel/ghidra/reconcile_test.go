@@ -0,0 +1,218 @@
+package ghidra
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestACLMon creates repos with the given ACL file contents.
+func newTestACLMon(t *testing.T, repos map[string]string) *ACLMon {
+	t.Helper()
+	dir := t.TempDir()
+	for repo, acl := range repos {
+		if err := os.Mkdir(filepath.Join(dir, repo), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, repo, "userAccess.acl"), []byte(acl), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := &ACLMon{Dir: dir}
+	a.Refresh()
+	if a.Get() == nil {
+		t.Fatal("failed to read ACLs")
+	}
+	return a
+}
+
+func (a *ACLMon) perm(repo, user string) int {
+	perm, ok := a.Get().ACLs[repo].Users[user]
+	if !ok {
+		return PermNone
+	}
+	return perm
+}
+
+func TestReconcile(t *testing.T) {
+	// step is a role sync after an admin optionally edited the ACL by hand.
+	type step struct {
+		edit    bool // whether an admin set the permission to manual
+		manual  int
+		want    map[string]int
+		perm    int // permission held after the sync
+		managed map[string]Grant
+	}
+
+	tests := []struct {
+		name  string
+		acl   string
+		steps []step
+	}{
+		{
+			name: "grant and revoke",
+			steps: []step{
+				{want: map[string]int{"re": PermWrite}, perm: PermWrite, managed: map[string]Grant{"re": {PermWrite, PermNone}}},
+				{want: map[string]int{"re": PermWrite}, perm: PermWrite, managed: map[string]Grant{"re": {PermWrite, PermNone}}},
+				{perm: PermNone, managed: map[string]Grant{}},
+			},
+		},
+		{
+			name: "upgrade manual entry and restore it",
+			acl:  "bob=READ_ONLY\n",
+			steps: []step{
+				{want: map[string]int{"re": PermWrite}, perm: PermWrite, managed: map[string]Grant{"re": {PermWrite, PermRead}}},
+				{perm: PermRead, managed: map[string]Grant{}},
+				{perm: PermRead, managed: map[string]Grant{}},
+			},
+		},
+		{
+			name: "manual entry above role",
+			acl:  "bob=ADMIN\n",
+			steps: []step{
+				{want: map[string]int{"re": PermWrite}, perm: PermAdmin, managed: map[string]Grant{}},
+				{perm: PermAdmin, managed: map[string]Grant{}},
+			},
+		},
+		{
+			name: "role lowered to manual entry",
+			acl:  "bob=READ_ONLY\n",
+			steps: []step{
+				{want: map[string]int{"re": PermAdmin}, perm: PermAdmin, managed: map[string]Grant{"re": {PermAdmin, PermRead}}},
+				{want: map[string]int{"re": PermWrite}, perm: PermWrite, managed: map[string]Grant{"re": {PermWrite, PermRead}}},
+				{want: map[string]int{"re": PermRead}, perm: PermRead, managed: map[string]Grant{}},
+			},
+		},
+		{
+			name: "grant changed by hand",
+			steps: []step{
+				{want: map[string]int{"re": PermWrite}, perm: PermWrite, managed: map[string]Grant{"re": {PermWrite, PermNone}}},
+				{edit: true, manual: PermAdmin, perm: PermAdmin, managed: map[string]Grant{}},
+			},
+		},
+		{
+			name: "grant removed by hand",
+			steps: []step{
+				{want: map[string]int{"re": PermWrite}, perm: PermWrite, managed: map[string]Grant{"re": {PermWrite, PermNone}}},
+				{edit: true, manual: PermNone, want: map[string]int{"re": PermWrite}, perm: PermWrite, managed: map[string]Grant{"re": {PermWrite, PermNone}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestACLMon(t, map[string]string{"re": tt.acl, "other": "alice=ADMIN\n"})
+			managed := map[string]Grant{}
+			for i, s := range tt.steps {
+				if s.edit {
+					if err := WriteUserPerm(filepath.Join(a.Dir, "re"), "bob", s.manual); err != nil {
+						t.Fatal(err)
+					}
+					a.Refresh()
+				}
+				var err error
+				_, managed, err = a.Reconcile("bob", s.want, managed, false)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if perm := a.perm("re", "bob"); perm != s.perm {
+					t.Errorf("step %d: permission %s, want %s", i, permString(perm), permString(s.perm))
+				}
+				if !reflect.DeepEqual(managed, s.managed) {
+					t.Errorf("step %d: managed %v, want %v", i, managed, s.managed)
+				}
+				if perm := a.perm("other", "alice"); perm != PermAdmin {
+					t.Errorf("step %d: other user changed to %s", i, permString(perm))
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	a := newTestACLMon(t, map[string]string{"re": "bob=READ_ONLY\n"})
+	managed := map[string]Grant{}
+	changes, newManaged, err := a.Reconcile("bob", map[string]int{"re": PermWrite}, managed, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ACLChange{{Repo: "re", User: "bob", Old: PermRead, New: PermWrite}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes %v, want %v", changes, want)
+	}
+	if !reflect.DeepEqual(newManaged, managed) {
+		t.Errorf("dry run changed managed grants to %v", newManaged)
+	}
+	a.Refresh()
+	if perm := a.perm("re", "bob"); perm != PermRead {
+		t.Errorf("dry run changed permission to %s", permString(perm))
+	}
+}
+
+// A failed write leaves the grants of the repos not updated to the next sync.
+func TestReconcileWriteFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		acl     string
+		managed map[string]Grant // before the failing sync
+		want    map[string]int   // of the failing sync
+		changes []string         // written by the failing sync
+		failed  map[string]Grant // managed after the failing sync
+		final   int              // permission in all repos after the next sync
+	}{
+		{
+			name:    "grant",
+			managed: map[string]Grant{},
+			want:    map[string]int{"a": PermWrite, "b": PermWrite, "c": PermWrite},
+			changes: []string{"a: bob NONE => WRITE"},
+			failed:  map[string]Grant{"a": {PermWrite, PermNone}},
+			final:   PermWrite,
+		},
+		{
+			name:    "revoke",
+			acl:     "bob=WRITE\n",
+			managed: map[string]Grant{"a": {PermWrite, PermNone}, "b": {PermWrite, PermNone}, "c": {PermWrite, PermNone}},
+			changes: []string{"a: bob WRITE => NONE"},
+			failed:  map[string]Grant{"b": {PermWrite, PermNone}, "c": {PermWrite, PermNone}},
+			final:   PermNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestACLMon(t, map[string]string{"a": tt.acl, "b": tt.acl, "c": tt.acl})
+			aclPath := filepath.Join(a.Dir, "b", "userAccess.acl")
+			if err := os.Remove(aclPath); err != nil {
+				t.Fatal(err)
+			}
+
+			changes, managed, err := a.Reconcile("bob", tt.want, tt.managed, false)
+			if err == nil {
+				t.Fatal("Reconcile() succeeded despite failed write")
+			}
+			var written []string
+			for _, change := range changes {
+				written = append(written, change.String())
+			}
+			if !reflect.DeepEqual(written, tt.changes) {
+				t.Errorf("changes %q, want %q", written, tt.changes)
+			}
+			if !reflect.DeepEqual(managed, tt.failed) {
+				t.Errorf("managed %v, want %v", managed, tt.failed)
+			}
+
+			// The next sync completes the work
+			if err := os.WriteFile(aclPath, []byte(tt.acl), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			a.Refresh()
+			if _, _, err := a.Reconcile("bob", tt.want, managed, false); err != nil {
+				t.Fatal(err)
+			}
+			for _, repo := range []string{"a", "b", "c"} {
+				if perm := a.perm(repo, "bob"); perm != tt.final {
+					t.Errorf("%s: permission %s, want %s", repo, permString(perm), permString(tt.final))
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
 	"go.mkw.re/ghidra-panel/ghidra"
@@ -11,9 +10,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"go.mkw.re/ghidra-panel/csrf"
 	"go.mkw.re/ghidra-panel/database"
 	"go.mkw.re/ghidra-panel/discord"
+	"go.mkw.re/ghidra-panel/membership"
+	"go.mkw.re/ghidra-panel/oidc"
+	"go.mkw.re/ghidra-panel/probe"
+	"go.mkw.re/ghidra-panel/tlsreload"
 	"go.mkw.re/ghidra-panel/token"
 	"go.mkw.re/ghidra-panel/web"
 )
@@ -39,6 +46,60 @@ func main() {
 				log.Fatal(err)
 			}
 			return
+		case "enable":
+			os.Args = os.Args[1:]
+			dbPath := flag.String("db", "ghidra_panel.db", "path to database file")
+			argUserID := flag.Uint64("user-id", 0, "ID of user to re-enable")
+			flag.Parse()
+
+			db, err := database.Open(*dbPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer db.Close()
+
+			if err := db.EnableUser(context.Background(), *argUserID); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "set-role":
+			os.Args = os.Args[1:]
+			dbPath := flag.String("db", "ghidra_panel.db", "path to database file")
+			argUserID := flag.Uint64("user-id", 0, "ID of user to change role of")
+			argRole := flag.String("role", database.RoleAdmin, "role to grant")
+			argRemove := flag.Bool("remove", false, "remove role instead of granting it")
+			flag.Parse()
+
+			db, err := database.Open(*dbPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer db.Close()
+
+			if err := db.SetRole(context.Background(), *argUserID, *argRole, !*argRemove); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "rotate-secrets":
+			os.Args = os.Args[1:]
+			configPath := flag.String("config", "ghidra_panel.json", "path to config file")
+			secretsPath := flag.String("secrets", "ghidra_panel.secrets.json", "path to secrets file")
+			retireAfter := flag.Duration("retire-after", 0, "how long previous keys keep verifying sessions (default session.lifetime of the config)")
+			flag.Parse()
+			if *retireAfter == 0 {
+				// Sessions signed just before the rotation must stay valid
+				cfg, err := readConfig(*configPath)
+				if err != nil {
+					log.Fatal(err)
+				}
+				*retireAfter = cfg.sessionLifetime()
+			}
+			keyID, err := rotateSecrets(*secretsPath, *retireAfter)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("Added key %s, send SIGHUP to the panel to start using it", keyID)
+			return
 		case "set-password":
 			os.Args = os.Args[1:]
 			dbPath := flag.String("db", "ghidra_panel.db", "path to database file")
@@ -63,17 +124,20 @@ func main() {
 
 	// Read config
 
-	configJSON, err := os.ReadFile(*configPath)
+	cfg, err := readConfig(*configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	var cfg config
-	if err := json.Unmarshal(configJSON, &cfg); err != nil {
-		log.Fatal(err)
-	}
 	if !*cmdInit {
-		cfg.validate()
+		if err := cfg.validate(); err != nil {
+			log.Fatalf("Invalid config %s:\n%v", *configPath, err)
+		}
+	}
+	// live holds the settings that can be reloaded, cfg those in use since startup
+	var live atomic.Pointer[config]
+	live.Store(cfg)
+	webhookURL := func() string {
+		return live.Load().Discord.WebhookURL
 	}
 
 	// Read secrets
@@ -93,6 +157,7 @@ func main() {
 		log.Fatal(err)
 	}
 	defer db.Close()
+	db.Hasher = cfg.hasher()
 
 	// Setup app context
 
@@ -112,40 +177,159 @@ func main() {
 		})
 	}
 
+	// Setup Ghidra server probe
+
+	var prober *probe.Prober
+	if cfg.Ghidra.Endpoint.Hostname != "" && !*cmdInit {
+		prober = &probe.Prober{
+			Endpoint:   cfg.Ghidra.Endpoint,
+			Interval:   cfg.Ghidra.ProbeInterval.or(probe.DefaultInterval),
+			Timeout:    cfg.Ghidra.ProbeTimeout.or(probe.DefaultTimeout),
+			WebhookURL: webhookURL,
+		}
+		group.Go(func() error {
+			log.Printf("Probing Ghidra server %s:%d every %s", prober.Endpoint.Hostname, prober.Endpoint.Port, prober.Interval)
+			return prober.Run(ctx)
+		})
+	}
+
+	// Setup guild membership checks
+
+	if cfg.Discord.BotToken != "" && !*cmdInit {
+		checker := membership.Checker{
+			DB:          db,
+			Bot:         discord.NewBot(cfg.Discord.BotToken, cfg.Discord.APIBase),
+			ACLs:        &acls,
+			Guilds:      cfg.Discord.RequiredGuilds,
+			Interval:    cfg.Discord.MembershipCheckInterval.or(6 * time.Hour),
+			GracePeriod: cfg.Discord.DeprovisionGracePeriod.or(72 * time.Hour),
+			WebhookURL:  webhookURL,
+
+			MaxDeprovisions: cfg.Discord.MaxDeprovisions,
+		}
+		group.Go(func() error {
+			log.Printf("Checking guild membership every %s", checker.Interval)
+			return checker.Run(ctx)
+		})
+	}
+
 	// Setup web server
 
 	if *cmdInit {
 		return
 	}
 
-	redirectURL := cfg.BaseURL + "/redirect"
+	// OAuth state keys are derived from the persistent keyring and replays
+	// are recorded in the database, so logins in flight survive restarts
+	// and work across instances. In memory, states die with the process.
+	stateOptions := func(providerID string) csrf.Options {
+		opts := csrf.Options{
+			Validity: time.Duration(cfg.OAuthState.Validity),
+		}
+		if cfg.OAuthState.ReplayStore != replayStoreMemory {
+			opts.Keys = secrets.HMACKeys.Derive(stateKeyPurpose + providerID)
+			opts.Store = db
+		}
+		return opts
+	}
 
-	auth := discord.NewAuth(cfg.Discord.ClientID, cfg.Discord.ClientSecret, redirectURL)
+	var providers []web.IdentityProvider
+	if cfg.Discord.ClientID != "" {
+		redirectURL := cfg.BaseURL + "/redirect"
+		discordOpts := discord.Options{
+			APIBase:        cfg.Discord.APIBase,
+			RequiredGuilds: cfg.Discord.RequiredGuilds,
+			RoleGuilds:     web.RoleGuilds(cfg.RoleRules),
+			State:          stateOptions("discord"),
+		}
+		providers = append(providers, discord.NewAuth(cfg.Discord.ClientID, cfg.Discord.ClientSecret, redirectURL, discordOpts))
+	}
+	for _, oidcConfig := range cfg.OIDC {
+		redirectURL := cfg.BaseURL + "/redirect/" + oidcConfig.ID
+		provider, err := oidc.Discover(ctx, oidcConfig, redirectURL, nil, stateOptions(oidcConfig.ID))
+		if err != nil {
+			log.Fatalf("failed to set up %s: %v", oidcConfig.ID, err)
+		}
+		providers = append(providers, provider)
+	}
+	// Providers whose state keys follow rotated secrets
+	var stateProviders []stateKeyed
+	if cfg.OAuthState.ReplayStore != replayStoreMemory {
+		for _, provider := range providers {
+			if provider, ok := provider.(stateKeyed); ok {
+				stateProviders = append(stateProviders, provider)
+			}
+		}
+	}
 
-	issuer := token.NewIssuer((*[32]byte)(secrets.HMACSecret))
+	issuer := token.NewIssuer(
+		secrets.HMACKeys,
+		cfg.BaseURL,
+		cfg.sessionLifetime(),
+		cfg.Session.IdleTimeout.or(token.DefaultIdleTimeout),
+	)
+	revoked, err := db.ListRevokedSessions(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, sess := range revoked {
+		issuer.Revoked.Add(sess.ID, sess.ExpiresAt)
+	}
 
+	origin, err := csrf.Origin(cfg.BaseURL)
+	if err != nil {
+		log.Fatal("invalid base_url: ", err)
+	}
+	var server *web.Server
 	webConfig := web.Config{
-		GhidraEndpoint:    &cfg.Ghidra.Endpoint,
-		Links:             cfg.Links,
-		DiscordWebhookURL: cfg.Discord.WebhookURL,
-		Dev:               *dev,
+		Origin:       origin,
+		CSRFKeys:     secrets.HMACKeys.Derive(csrfKeyPurpose),
+		Dev:          *dev,
+		RateLimits:   cfg.rateLimits(),
+		ServeMetrics: cfg.Metrics.Enabled && cfg.Metrics.Listen == "",
+		RotateSecrets: func() (string, error) {
+			keyID, err := rotateSecrets(*secretsPath, issuer.Lifetime)
+			if err != nil {
+				return "", err
+			}
+			return keyID, applySecrets(*secretsPath, server, stateProviders)
+		},
 	}
-	server, err := web.NewServer(&webConfig, db, auth, &issuer, &acls)
+	cfg.setReloadable(&webConfig)
+	server, err = web.NewServer(&webConfig, db, providers, &issuer, &acls)
 	if err != nil {
 		log.Fatal(err)
 	}
+	server.Prober = prober
 
 	mux := http.NewServeMux()
 	server.RegisterRoutes(mux)
 
-	log.Printf("Listening on %s", *listen)
-
 	httpServer := http.Server{
 		Addr:    *listen,
 		Handler: mux,
 	}
+	var cert *tlsreload.Certificate
+	if cfg.TLSCert != "" {
+		cert, err = tlsreload.Load(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpServer.TLSConfig = cert.Config()
+		group.Go(func() error {
+			return cert.Watch(ctx, tlsreload.DefaultWatchInterval)
+		})
+	}
+
+	log.Printf("Listening on %s", *listen)
+
 	go func() {
-		err := httpServer.ListenAndServe()
+		var err error
+		if cert != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal(err)
 		}
@@ -155,6 +339,78 @@ func main() {
 		return httpServer.Shutdown(ctx)
 	})
 
+	if cfg.TLSRedirectListen != "" {
+		log.Printf("Redirecting HTTP on %s to %s", cfg.TLSRedirectListen, origin)
+
+		redirectServer := http.Server{
+			Addr:    cfg.TLSRedirectListen,
+			Handler: web.RedirectHTTPS(origin),
+		}
+		go func() {
+			err := redirectServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+		group.Go(func() error {
+			<-ctx.Done()
+			return redirectServer.Shutdown(ctx)
+		})
+	}
+
+	// Reload on SIGHUP
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	group.Go(func() error {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-hup:
+			}
+			if next, err := reloadConfig(*configPath, cfg, server); err != nil {
+				log.Print("Keeping previous config: ", err)
+			} else {
+				live.Store(next)
+			}
+			if err := applySecrets(*secretsPath, server, stateProviders); err != nil {
+				log.Print("Keeping previous secrets: ", err)
+			} else {
+				log.Print("Reloaded secrets")
+			}
+			if cert != nil {
+				if err := cert.Reload(); err != nil {
+					log.Print("Keeping previous TLS certificate: ", err)
+				} else {
+					log.Print("Reloaded TLS certificate")
+				}
+			}
+		}
+	})
+
+	if cfg.Metrics.Listen != "" {
+		log.Printf("Serving metrics on %s", cfg.Metrics.Listen)
+
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", server.Metrics)
+		metricsServer := http.Server{
+			Addr:    cfg.Metrics.Listen,
+			Handler: metricsMux,
+		}
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+		group.Go(func() error {
+			<-ctx.Done()
+			return metricsServer.Shutdown(ctx)
+		})
+	}
+
 	if err := group.Wait(); err != nil {
 		log.Print(err)
 	}
@@ -0,0 +1,199 @@
+// Package membership periodically re-validates Discord guild membership
+// and deprovisions users who left.
+package membership
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/discord"
+	"go.mkw.re/ghidra-panel/ghidra"
+)
+
+// DefaultMaxDeprovisions is how many users a run deprovisions at most
+// if Checker.MaxDeprovisions is zero.
+const DefaultMaxDeprovisions = 5
+
+// errDeferred is returned for users left to a later run by MaxDeprovisions.
+var errDeferred = errors.New("deprovisioning deferred")
+
+// Checker re-checks the guild membership of all Discord-linked users.
+//
+// Users found outside all required guilds are disabled and removed from
+// all ACLs once the grace period has passed. Admins are notified through
+// the webhook when a user leaves and when they are deprovisioned.
+type Checker struct {
+	DB          *database.DB
+	Bot         *discord.Bot
+	ACLs        *ghidra.ACLMon
+	Guilds      []string // user must be in at least one
+	Interval    time.Duration
+	GracePeriod time.Duration
+	WebhookURL  func() string // admins are not notified if it returns ""
+	// MaxDeprovisions bounds how many users one run deprovisions,
+	// DefaultMaxDeprovisions if zero. The others wait for later runs,
+	// so admins can step in if many users seem to leave at once.
+	MaxDeprovisions int
+}
+
+// Run starts the membership check loop.
+// Returns reason for context termination as error.
+func (c *Checker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		if err := c.checkAll(ctx); err != nil {
+			log.Printf("membership check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) checkAll(ctx context.Context) error {
+	users, err := c.DB.ListDiscordUsers(ctx)
+	if err != nil {
+		return err
+	}
+	budget := c.MaxDeprovisions
+	if budget == 0 {
+		budget = DefaultMaxDeprovisions
+	}
+	deferred := 0
+	for _, user := range users {
+		member, err := c.isMember(ctx, user.DiscordID)
+		if err != nil {
+			// Not only this user is affected if e.g. the bot lost access to
+			// a guild, so stop before mistaking everyone for having left
+			return fmt.Errorf("membership check of user %d: %w", user.UserID, err)
+		}
+		err = c.check(ctx, &user, member, &budget)
+		if errors.Is(err, errDeferred) {
+			deferred++
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("membership check of user %d failed: %v", user.UserID, err)
+		}
+	}
+	if deferred > 0 {
+		log.Printf("Deferred deprovisioning %d users to the next membership check", deferred)
+		c.send(ctx, "Deprovisioning deferred", 0xFDFD96, fmt.Sprintf(
+			"Users past the grace period: %d more than one check may deprovision. Later checks deprovision them unless they rejoin.", deferred))
+	}
+	return nil
+}
+
+// check updates the state of a user, deprovisioning them if the budget
+// of the run allows.
+func (c *Checker) check(ctx context.Context, user *database.DiscordUser, member bool, budget *int) error {
+	now := time.Now()
+	switch {
+	case member && user.MissingSince != nil:
+		log.Printf("User %d rejoined the guild", user.UserID)
+		return c.DB.SetMissing(ctx, user.UserID, nil)
+	case member:
+		return nil
+	case user.MissingSince == nil:
+		if err := c.DB.SetMissing(ctx, user.UserID, &now); err != nil {
+			return err
+		}
+		if err := c.DB.Audit(ctx, user.UserID, "membership.missing", "user left all required guilds"); err != nil {
+			log.Print("Failed to write audit log: ", err)
+		}
+		c.notify(ctx, user.UserID, "left the Discord server", 0xFDFD96,
+			fmt.Sprintf("Access will be revoked <t:%d:R> unless they rejoin.", now.Add(c.GracePeriod).Unix()))
+		return nil
+	case now.Sub(*user.MissingSince) >= c.GracePeriod:
+		if *budget <= 0 {
+			return errDeferred
+		}
+		*budget--
+		return c.deprovision(ctx, user.UserID)
+	default:
+		return nil
+	}
+}
+
+// isMember returns whether a Discord user is in at least one required guild.
+func (c *Checker) isMember(ctx context.Context, discordID string) (bool, error) {
+	for _, guildID := range c.Guilds {
+		member, err := c.Bot.GetGuildMember(ctx, guildID, discordID)
+		if err != nil {
+			return false, err
+		}
+		if member != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Checker) deprovision(ctx context.Context, userID uint64) error {
+	if err := c.DB.DisableUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := c.DB.Audit(ctx, userID, "membership.disable", "grace period expired"); err != nil {
+		log.Print("Failed to write audit log: ", err)
+	}
+
+	username, err := c.DB.GetUsername(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if username != "" && c.ACLs.Get() != nil {
+		changes, err := c.ACLs.RemoveUser(username)
+		for _, change := range changes {
+			if err := c.DB.Audit(ctx, userID, "acl.deprovision", change.String()); err != nil {
+				log.Print("Failed to write audit log: ", err)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.DB.SetManagedGrants(ctx, userID, nil); err != nil {
+		return err
+	}
+
+	c.notify(ctx, userID, "was deprovisioned", 0xFF6961, "Their account was disabled and removed from all repositories.")
+	return nil
+}
+
+// notify tells admins about a user.
+func (c *Checker) notify(ctx context.Context, userID uint64, title string, color int, description string) {
+	if c.WebhookURL() == "" {
+		return
+	}
+	name := fmt.Sprintf("User %d", userID)
+	if profile, err := c.DB.GetProfile(ctx, userID); err == nil && profile != nil {
+		name = profile.Username
+	}
+	c.send(ctx, name+" "+title, color, description)
+}
+
+func (c *Checker) send(ctx context.Context, title string, color int, description string) {
+	webhookURL := c.WebhookURL()
+	if webhookURL == "" {
+		return
+	}
+	message := discord.WebhookMessage{
+		Username: "Panel",
+		Embeds: []discord.Embed{{
+			Title:       title,
+			Description: description,
+			Color:       color,
+		}},
+	}
+	if err := discord.SendWebhook(ctx, webhookURL, &message); err != nil {
+		log.Print("Failed to send membership notification: ", err)
+	}
+}
@@ -0,0 +1,243 @@
+package membership
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/discord"
+	"go.mkw.re/ghidra-panel/ghidra"
+)
+
+// webhookRecorder collects the titles of webhook messages.
+type webhookRecorder struct {
+	*httptest.Server
+	mu     sync.Mutex
+	titles []string
+}
+
+func newWebhookRecorder(t *testing.T) *webhookRecorder {
+	w := &webhookRecorder{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		var message discord.WebhookMessage
+		if err := json.NewDecoder(req.Body).Decode(&message); err != nil || len(message.Embeds) == 0 {
+			http.Error(wr, "bad message", http.StatusBadRequest)
+			return
+		}
+		w.mu.Lock()
+		w.titles = append(w.titles, message.Embeds[0].Title)
+		w.mu.Unlock()
+		wr.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhookRecorder) Titles() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.titles...)
+}
+
+// statusUnknownGuild stands for the 404 response to a guild the bot is not in.
+const statusUnknownGuild = -1
+
+// newBotAPI serves guild member requests with the given status.
+func newBotAPI(t *testing.T, status int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("authorization") != "Bot token" || !strings.HasPrefix(req.URL.Path, "/guilds/1/members/") {
+			http.Error(wr, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		switch status {
+		case http.StatusOK:
+			_, _ = wr.Write([]byte(`{"roles":[]}`))
+		case http.StatusNotFound:
+			http.Error(wr, `{"message":"Unknown Member","code":10007}`, status)
+		case statusUnknownGuild:
+			http.Error(wr, `{"message":"Unknown Guild","code":10004}`, http.StatusNotFound)
+		default:
+			http.Error(wr, `{"message":"error"}`, status)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheck(t *testing.T) {
+	const grace = time.Hour
+	tests := []struct {
+		name          string
+		status        int           // of the guild member request
+		missingFor    time.Duration // user missing before the check, unless zero
+		err           bool
+		missing       bool // user missing after the check
+		deprovisioned bool
+		webhooks      []string
+	}{
+		{name: "member", status: http.StatusOK},
+		{name: "rejoined", status: http.StatusOK, missingFor: time.Minute},
+		{name: "left", status: http.StatusNotFound, missing: true, webhooks: []string{"User 42 left the Discord server"}},
+		{name: "grace period", status: http.StatusNotFound, missingFor: time.Minute, missing: true},
+		{name: "grace period expired", status: http.StatusNotFound, missingFor: 2 * grace, missing: true, deprovisioned: true,
+			webhooks: []string{"User 42 was deprovisioned"}},
+		{name: "API error", status: http.StatusInternalServerError, missingFor: 2 * grace, missing: true, err: true},
+		{name: "unknown guild", status: statusUnknownGuild, err: true},
+		{name: "unknown guild after grace period", status: statusUnknownGuild, missingFor: 2 * grace, missing: true, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db, err := database.Open(filepath.Join(t.TempDir(), "panel.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if _, _, err := db.ResolveIdentity(ctx, &common.Identity{ID: 42, Provider: "discord", Subject: "42", Username: "bob"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.SetPassword(ctx, 42, "bob", "hunter2"); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.SetManagedGrants(ctx, 42, map[string]ghidra.Grant{"re": {Perm: ghidra.PermWrite, Prior: ghidra.PermNone}}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.missingFor != 0 {
+				since := time.Now().Add(-tt.missingFor)
+				if err := db.SetMissing(ctx, 42, &since); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			repoDir := filepath.Join(t.TempDir(), "re")
+			if err := os.Mkdir(repoDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(repoDir, "userAccess.acl"), []byte("bob=WRITE\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			acls := &ghidra.ACLMon{Dir: filepath.Dir(repoDir)}
+			acls.Refresh()
+
+			webhook := newWebhookRecorder(t)
+			c := &Checker{
+				DB:          db,
+				Bot:         discord.NewBot("token", newBotAPI(t, tt.status).URL),
+				ACLs:        acls,
+				Guilds:      []string{"1"},
+				GracePeriod: grace,
+				WebhookURL:  func() string { return webhook.URL },
+			}
+			if err := c.checkAll(ctx); (err != nil) != tt.err {
+				t.Fatalf("checkAll() error = %v, want error %v", err, tt.err)
+			}
+
+			disabled, err := db.IsDisabled(ctx, 42)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if disabled != tt.deprovisioned {
+				t.Errorf("disabled = %v, want %v", disabled, tt.deprovisioned)
+			}
+			if hasACL := len(acls.Get().QueryUser("bob")) > 0; hasACL == tt.deprovisioned {
+				t.Errorf("ACL entry kept = %v, want %v", hasACL, !tt.deprovisioned)
+			}
+			grants, err := db.GetManagedGrants(ctx, 42)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := len(grants) > 0; kept == tt.deprovisioned {
+				t.Errorf("managed grants kept = %v, want %v", kept, !tt.deprovisioned)
+			}
+			if !tt.deprovisioned {
+				users, err := db.ListDiscordUsers(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if missing := users[0].MissingSince != nil; missing != tt.missing {
+					t.Errorf("missing = %v, want %v", missing, tt.missing)
+				}
+			}
+			if titles := webhook.Titles(); strings.Join(titles, "\n") != strings.Join(tt.webhooks, "\n") {
+				t.Errorf("webhooks %q, want %q", titles, tt.webhooks)
+			}
+		})
+	}
+}
+
+func TestCheckMaxDeprovisions(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const users = 5
+	since := time.Now().Add(-2 * time.Hour)
+	for id := uint64(1); id <= users; id++ {
+		ident := &common.Identity{ID: id, Provider: "discord", Subject: strconv.FormatUint(id, 10), Username: "user"}
+		if _, _, err := db.ResolveIdentity(ctx, ident); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetMissing(ctx, id, &since); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	webhook := newWebhookRecorder(t)
+	c := &Checker{
+		DB:              db,
+		Bot:             discord.NewBot("token", newBotAPI(t, http.StatusNotFound).URL),
+		ACLs:            &ghidra.ACLMon{},
+		Guilds:          []string{"1"},
+		GracePeriod:     time.Hour,
+		WebhookURL:      func() string { return webhook.URL },
+		MaxDeprovisions: 2,
+	}
+	disabled := func() int {
+		t.Helper()
+		n := 0
+		for id := uint64(1); id <= users; id++ {
+			isDisabled, err := db.IsDisabled(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isDisabled {
+				n++
+			}
+		}
+		return n
+	}
+	deferred := func() int {
+		n := 0
+		for _, title := range webhook.Titles() {
+			if title == "Deprovisioning deferred" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Each check deprovisions up to the limit, admins hear about the rest
+	for run, want := range []int{2, 4, 5} {
+		if err := c.checkAll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := disabled(); got != want {
+			t.Fatalf("check %d: %d users disabled, want %d", run, got, want)
+		}
+	}
+	if got := deferred(); got != 2 {
+		t.Errorf("notified of deferred deprovisioning %d times, want 2", got)
+	}
+}
@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests by route\\code.\nSecond line.", "route", "code")
+	latency := r.NewHistogramVec("latency_seconds", "Latencies.", []float64{1, 0.1}, "route")
+	r.NewGaugeFunc("up", "Whether it is up.", func() float64 { return 1 })
+	r.NewCounterFunc("bytes_total", "Bytes.", func() float64 { return 1.5e9 })
+	r.NewGaugeFunc("nan", "Not a number.", math.NaN)
+	r.NewCounterVec("unused_total", "Never incremented.")
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "500")
+	requests.Inc("/b", "200")
+	requests.Inc(`/"quoted"\path`+"\n", "404")
+	for _, v := range []float64{0.05, 0.1, 0.5, 1, 3} {
+		latency.Observe(v, "/a")
+	}
+
+	const want = `# HELP requests_total Requests by route\\code.\nSecond line.
+# TYPE requests_total counter
+requests_total{route="/\"quoted\"\\path\n",code="404"} 1
+requests_total{route="/a",code="500"} 2
+requests_total{route="/b",code="200"} 2
+# HELP latency_seconds Latencies.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 4
+latency_seconds_bucket{route="/a",le="+Inf"} 5
+latency_seconds_sum{route="/a"} 4.65
+latency_seconds_count{route="/a"} 5
+# HELP up Whether it is up.
+# TYPE up gauge
+up 1
+# HELP bytes_total Bytes.
+# TYPE bytes_total counter
+bytes_total 1.5e+09
+# HELP nan Not a number.
+# TYPE nan gauge
+nan NaN
+# HELP unused_total Never incremented.
+# TYPE unused_total counter
+`
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTo() wrote\n%s\nwant\n%s", got, want)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo() = %d, wrote %d bytes", n, buf.Len())
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{0.25, "0.25"},
+		{1e-9, "1e-09"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	r := NewRegistry()
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{"counter", func() { r.NewCounterVec("c", "", "a").Inc() }},
+		{"histogram", func() { r.NewHistogramVec("h", "", DefaultBuckets, "a").Observe(1, "x", "y") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("wrong number of label values accepted")
+				}
+			}()
+			tt.f()
+		})
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("up", "Whether it is up.", func() float64 { return 1 })
+	tests := []struct {
+		method string
+		status int
+		body   bool
+	}{
+		{http.MethodGet, http.StatusOK, true},
+		{http.MethodHead, http.StatusOK, false},
+		{http.MethodPost, http.StatusMethodNotAllowed, false},
+	}
+	for _, tt := range tests {
+		wr := httptest.NewRecorder()
+		r.ServeHTTP(wr, httptest.NewRequest(tt.method, "/metrics", nil))
+		if wr.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.method, wr.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if ct := wr.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+			t.Errorf("%s: Content-Type %q", tt.method, ct)
+		}
+		if tt.body && !strings.Contains(wr.Body.String(), "\nup 1\n") {
+			t.Errorf("%s: body %q", tt.method, wr.Body)
+		}
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("c", "", "worker")
+	h := r.NewHistogramVec("h", "", DefaultBuckets)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Inc("shared")
+				h.Observe(0.01)
+				if j%100 == 0 {
+					_, _ = r.WriteTo(&bytes.Buffer{})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var buf bytes.Buffer
+	_, _ = r.WriteTo(&buf)
+	for _, want := range []string{`c{worker="shared"} 4000`, "h_count 4000", `h_bucket{le="0.01"} 4000`} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("output lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
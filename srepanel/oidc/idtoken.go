@@ -0,0 +1,213 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is the tolerance applied to ID token timestamps.
+const clockSkew = time.Minute
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// idToken holds the claims of a verified ID token.
+// Registered claims are decoded eagerly, all others are kept raw for mapping.
+type idToken struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Expiry   time.Time
+	Nonce    string
+	Claims   map[string]json.RawMessage
+}
+
+// audience decodes the "aud" claim, which is either a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(buf []byte) error {
+	var single string
+	if err := json.Unmarshal(buf, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(buf, (*[]string)(a))
+}
+
+// verifyIDToken checks the signature and registered claims of an ID token.
+// https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+func (p *Provider) verifyIDToken(ctx context.Context, raw, nonce string) (*idToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	// Decode header
+	headerBuf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerBuf, &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+
+	// Verify signature
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+	key, err := p.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	// Decode claims
+	claimsBuf, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	var registered struct {
+		Iss   string   `json:"iss"`
+		Sub   string   `json:"sub"`
+		Aud   audience `json:"aud"`
+		Azp   string   `json:"azp"`
+		Exp   int64    `json:"exp"`
+		Nonce string   `json:"nonce"`
+	}
+	if err := json.Unmarshal(claimsBuf, &registered); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	tok := &idToken{
+		Issuer:   registered.Iss,
+		Subject:  registered.Sub,
+		Audience: registered.Aud,
+		Expiry:   time.Unix(registered.Exp, 0),
+		Nonce:    registered.Nonce,
+	}
+	if err := json.Unmarshal(claimsBuf, &tok.Claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	// Check registered claims
+	if tok.Issuer != p.issuer {
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", tok.Issuer, p.issuer)
+	}
+	if tok.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	if !tok.hasAudience(p.Config.ClientID) {
+		return nil, errors.New("ID token not issued for this client")
+	}
+	if len(tok.Audience) > 1 && registered.Azp != "" && registered.Azp != p.Config.ClientID {
+		return nil, errors.New("ID token authorized for another party")
+	}
+	if registered.Exp == 0 || time.Now().Add(-clockSkew).After(tok.Expiry) {
+		return nil, errors.New("ID token expired")
+	}
+	if tok.Nonce != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return tok, nil
+}
+
+func (t *idToken) hasAudience(clientID string) bool {
+	for _, aud := range t.Audience {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// stringClaim returns a string-valued claim, or an empty string if absent.
+func (t *idToken) stringClaim(name string) string {
+	raw, ok := t.Claims[name]
+	if !ok {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+var ecdsaCurves = map[string]string{
+	"ES256": "P-256",
+	"ES384": "P-384",
+	"ES512": "P-521",
+}
+
+// verifySignature checks a JWS signature using an asymmetric algorithm.
+// Symmetric and "none" algorithms are rejected on purpose.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch alg[0] {
+	case 'R':
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("ID token algorithm does not match key type")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig); err != nil {
+			return errors.New("ID token signature invalid")
+		}
+	case 'P':
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("ID token algorithm does not match key type")
+		}
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		if err := rsa.VerifyPSS(pub, hash, digest, sig, opts); err != nil {
+			return errors.New("ID token signature invalid")
+		}
+	case 'E':
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("ID token algorithm does not match key type")
+		}
+		if pub.Curve.Params().Name != ecdsaCurves[alg] {
+			return errors.New("ID token algorithm does not match curve")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("ID token signature invalid")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("ID token signature invalid")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval rate limits JWKS fetches triggered by unknown key IDs.
+const jwksRefreshInterval = time.Minute
+
+// https://www.rfc-editor.org/rfc/rfc7517#section-4
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type publicKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+// keySet caches the signing keys of an identity provider.
+type keySet struct {
+	url    string
+	client *http.Client
+
+	lock      sync.Mutex
+	keys      []publicKey
+	fetchedAt time.Time
+}
+
+// get returns the public key with the given ID.
+// Refreshes the key set if the key is unknown, as the provider might have rotated keys.
+func (k *keySet) get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if key := k.find(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(k.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := k.refresh(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	if key := k.find(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (k *keySet) find(kid string) crypto.PublicKey {
+	// Tokens without key ID are only acceptable if there is no ambiguity
+	if kid == "" {
+		if len(k.keys) == 1 {
+			return k.keys[0].key
+		}
+		return nil
+	}
+	for _, key := range k.keys {
+		if key.kid == kid {
+			return key.key
+		}
+	}
+	return nil
+}
+
+func (k *keySet) refresh(ctx context.Context) error {
+	k.fetchedAt = time.Now()
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, k.client, k.url, &doc); err != nil {
+		return err
+	}
+
+	keys := make([]publicKey, 0, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			// Skip key types we do not understand
+			continue
+		}
+		keys = append(keys, publicKey{kid: jwk.Kid, key: key})
+	}
+	if len(keys) == 0 {
+		return errors.New("no usable signing keys")
+	}
+	k.keys = keys
+	return nil
+}
+
+func (j *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, errors.New("empty integer")
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,239 @@
+// Package oidc implements login via a generic OpenID Connect identity provider.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/csrf"
+)
+
+// Config configures an OpenID Connect identity provider.
+type Config struct {
+	ID            string   `json:"id"`   // stable identifier used in URLs and the database
+	Name          string   `json:"name"` // shown on the login page, e.g. "GitLab"
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"client_id"`
+	ClientSecret  string   `json:"client_secret"`
+	Scopes        []string `json:"scopes"`
+	UsernameClaim string   `json:"username_claim"` // defaults to "preferred_username", prefixed with ID
+	AvatarClaim   string   `json:"avatar_claim"`   // defaults to "picture"
+}
+
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider authenticates users via OpenID Connect.
+type Provider struct {
+	oauth2.Config
+	id            string
+	name          string
+	issuer        string
+	usernameClaim string
+	avatarClaim   string
+	client        *http.Client
+	keys          *keySet
+	prot          *csrf.OneTime
+}
+
+// Discover creates a provider from the issuer's discovery document.
+// The client is used for all requests to the provider, nil selects a default.
+// The state options protect the OAuth state parameter and nonce.
+func Discover(ctx context.Context, cfg *Config, redirectURL string, client *http.Client, state csrf.Options) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	issuer := strings.TrimSuffix(cfg.Issuer, "/")
+	var doc discoveryDocument
+	if err := getJSON(ctx, client, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", doc.Issuer, cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document incomplete")
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range cfg.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	p := &Provider{
+		Config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  doc.AuthorizationEndpoint,
+				TokenURL: doc.TokenEndpoint,
+			},
+			RedirectURL: redirectURL,
+			Scopes:      scopes,
+		},
+		id:            cfg.ID,
+		name:          cfg.Name,
+		issuer:        doc.Issuer,
+		usernameClaim: cfg.UsernameClaim,
+		avatarClaim:   cfg.AvatarClaim,
+		client:        client,
+		keys:          &keySet{url: doc.JWKSURI, client: client},
+		prot:          csrf.NewOneTime(state),
+	}
+	if p.name == "" {
+		p.name = "OpenID Connect"
+	}
+	if p.usernameClaim == "" {
+		p.usernameClaim = "preferred_username"
+	}
+	if p.avatarClaim == "" {
+		p.avatarClaim = "picture"
+	}
+	return p, nil
+}
+
+func (p *Provider) ID() string {
+	return p.id
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// SetStateKeys replaces the keys of OAuth states, e.g. after key rotation.
+// Only possible if the state options had keys and a replay store.
+func (p *Provider) SetStateKeys(keys [][]byte) {
+	p.prot.SetKeys(keys)
+}
+
+// AuthURL returns the authorization URL of a new login.
+// The return path is carried through the signed state.
+func (p *Provider) AuthURL(wr http.ResponseWriter, returnTo string) string {
+	// The state doubles as nonce, binding the ID token to this login attempt
+	state := p.prot.Issue(returnTo)
+	verifier := p.prot.BindVerifier(wr, p.verifierCookie(), p.Config.RedirectURL)
+	return p.Config.AuthCodeURL(
+		state,
+		oauth2.SetAuthURLParam("nonce", state),
+		oauth2.SetAuthURLParam("code_challenge", csrf.Challenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+}
+
+// HandleRedirect handles an OAuth2 redirect from the identity provider.
+func (p *Provider) HandleRedirect(wr http.ResponseWriter, req *http.Request) (ident *common.Identity, returnTo string, err error) {
+	ctx := context.WithValue(req.Context(), oauth2.HTTPClient, p.client)
+
+	errID := req.FormValue("error")
+	errDescription := req.FormValue("error_description")
+	if errID != "" {
+		if errID == "access_denied" {
+			http.Redirect(wr, req, "/login", http.StatusTemporaryRedirect)
+			return nil, "", nil
+		}
+		http.Error(wr, errDescription, http.StatusUnauthorized)
+		return nil, "", nil
+	}
+
+	query := req.URL.Query()
+	code := query.Get("code")
+	state := query.Get("state")
+
+	// Check CSRF token validity -- do not consume yet
+	csrfID, returnTo, err := p.prot.Check(state)
+	if err != nil {
+		return nil, "", err
+	}
+	verifier, err := csrf.BoundVerifier(wr, req, p.verifierCookie(), p.Config.RedirectURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Request tokens from identity provider
+	token, err := p.Config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, "", err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, "", errors.New("token response contains no ID token")
+	}
+
+	idTok, err := p.verifyIDToken(ctx, rawIDToken, state)
+	if err != nil {
+		return nil, "", err
+	}
+	ident, err = p.mapIdentity(idTok)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Prevent CSRF token reuse
+	err = p.prot.Consume(csrfID)
+	return
+}
+
+// verifierCookie returns the name of the cookie holding the PKCE code
+// verifier of a login in progress.
+func (p *Provider) verifierCookie() string {
+	return "pkce_" + p.id
+}
+
+func (p *Provider) mapIdentity(tok *idToken) (*common.Identity, error) {
+	username := tok.stringClaim(p.usernameClaim)
+	if username == "" {
+		return nil, fmt.Errorf("ID token lacks %q claim", p.usernameClaim)
+	}
+	if strings.ContainsAny(username, "=\n\r\x00") {
+		return nil, fmt.Errorf("username %q contains forbidden characters", username)
+	}
+	globalName := tok.stringClaim("name")
+	if globalName == "" {
+		globalName = username
+	}
+	return &common.Identity{
+		ID:         SubjectID(tok.Issuer, tok.Subject),
+		Provider:   p.id,
+		Subject:    tok.Subject,
+		Username:   Username(p.id, username),
+		GlobalName: globalName,
+		AvatarURL:  tok.stringClaim(p.avatarClaim),
+	}, nil
+}
+
+// Username returns the panel and Ghidra username of an OIDC user.
+//
+// Usernames claimed by an identity provider are neither unique nor
+// verified, so they are prefixed with the provider ID to keep them
+// apart from Discord usernames, which cannot contain "-".
+func Username(providerID, claimed string) string {
+	return providerID + "-" + claimed
+}
+
+// SubjectID derives a stable panel user ID from an OIDC subject.
+// It is used as the ID of accounts created by logging in with this subject.
+//
+// Bit 62 is always set, keeping IDs apart from Discord snowflakes,
+// which will not reach it until 2049. Bit 63 is always clear,
+// as database/sql does not support such uint64 values.
+func SubjectID(issuer, subject string) uint64 {
+	h := sha256.Sum256([]byte(issuer + "\x00" + subject))
+	return binary.BigEndian.Uint64(h[:8])&(1<<62-1) | 1<<62
+}
@@ -0,0 +1,336 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/csrf"
+)
+
+// fakeIssuer is an OpenID Connect provider issuing ID tokens with
+// claims chosen by the test.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	claims    map[string]any
+	challenge string // PKCE challenge of the login in progress
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(wr http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(wr).Encode(discoveryDocument{
+			Issuer:                f.URL,
+			AuthorizationEndpoint: f.URL + "/authorize",
+			TokenEndpoint:         f.URL + "/token",
+			JWKSURI:               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(wr http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(wr).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: "test",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(wr http.ResponseWriter, req *http.Request) {
+		f.mu.Lock()
+		challenge := f.challenge
+		f.mu.Unlock()
+		if req.PostFormValue("code") != "test-code" || csrf.Challenge(req.PostFormValue("code_verifier")) != challenge {
+			wr.Header().Set("content-type", "application/json")
+			wr.WriteHeader(http.StatusBadRequest)
+			_, _ = wr.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		f.mu.Lock()
+		idToken := f.sign(t, f.claims)
+		f.mu.Unlock()
+		wr.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(wr).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeIssuer) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(jwtHeader{Alg: "RS256", Kid: "test"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (f *fakeIssuer) provider(t *testing.T, id string) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), &Config{
+		ID:       id,
+		Issuer:   f.URL,
+		ClientID: "panel",
+	}, "https://panel.example/redirect/"+id, f.Client(), csrf.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// login starts a login and returns the query parameters sent to the
+// authorization endpoint and the cookies binding it to the browser.
+func (f *fakeIssuer) login(t *testing.T, p *Provider, returnTo string) (url.Values, []*http.Cookie) {
+	t.Helper()
+	wr := httptest.NewRecorder()
+	authURL, err := url.Parse(p.AuthURL(wr, returnTo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("login without S256 PKCE challenge: %s", authURL)
+	}
+	f.mu.Lock()
+	f.challenge = query.Get("code_challenge")
+	f.mu.Unlock()
+	return query, wr.Result().Cookies()
+}
+
+// redirect completes a login with the given state.
+func redirect(p *Provider, state string, cookies ...*http.Cookie) (*httptest.ResponseRecorder, *http.Request) {
+	req := httptest.NewRequest(http.MethodGet, "/redirect/"+p.ID()+"?code=test-code&state="+url.QueryEscape(state), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return httptest.NewRecorder(), req
+}
+
+func TestHandleRedirect(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := f.provider(t, "corp")
+
+	tests := []struct {
+		name       string
+		claims     func(claims map[string]any)
+		username   string
+		globalName string
+		err        string
+	}{
+		{
+			name:       "valid",
+			username:   "corp-bob",
+			globalName: "Bob",
+		},
+		{
+			name:       "no name",
+			claims:     func(c map[string]any) { delete(c, "name") },
+			username:   "corp-bob",
+			globalName: "bob",
+		},
+		{
+			name:   "no username",
+			claims: func(c map[string]any) { delete(c, "preferred_username") },
+			err:    `lacks "preferred_username" claim`,
+		},
+		{
+			name:   "forbidden username",
+			claims: func(c map[string]any) { c["preferred_username"] = "bob\n" },
+			err:    "forbidden characters",
+		},
+		{
+			name:   "wrong issuer",
+			claims: func(c map[string]any) { c["iss"] = "https://evil.example" },
+			err:    "ID token issued by",
+		},
+		{
+			name:   "wrong audience",
+			claims: func(c map[string]any) { c["aud"] = "other" },
+			err:    "not issued for this client",
+		},
+		{
+			name:   "expired",
+			claims: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			err:    "expired",
+		},
+		{
+			name:   "wrong nonce",
+			claims: func(c map[string]any) { c["nonce"] = "other" },
+			err:    "nonce mismatch",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, cookies := f.login(t, p, "/return")
+			claims := map[string]any{
+				"iss":                f.URL,
+				"sub":                "1234",
+				"aud":                "panel",
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"nonce":              params.Get("nonce"),
+				"preferred_username": "bob",
+				"name":               "Bob",
+			}
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			f.mu.Lock()
+			f.claims = claims
+			f.mu.Unlock()
+
+			wr, req := redirect(p, params.Get("state"), cookies...)
+			ident, returnTo, err := p.HandleRedirect(wr, req)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("HandleRedirect() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("HandleRedirect() error: ", err)
+			}
+			if ident.Username != tt.username || ident.GlobalName != tt.globalName {
+				t.Errorf("identity %q (%q), want %q (%q)", ident.Username, ident.GlobalName, tt.username, tt.globalName)
+			}
+			if ident.Provider != "corp" || ident.Subject != "1234" || ident.ID != SubjectID(f.URL, "1234") {
+				t.Errorf("identity %+v not bound to subject", ident)
+			}
+			if returnTo != "/return" {
+				t.Errorf("return path %q, want /return", returnTo)
+			}
+		})
+	}
+}
+
+func TestHandleRedirectReplay(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := f.provider(t, "corp")
+	params, cookies := f.login(t, p, "")
+	f.claims = map[string]any{
+		"iss":                f.URL,
+		"sub":                "1234",
+		"aud":                "panel",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              params.Get("nonce"),
+		"preferred_username": "bob",
+	}
+	if _, _, err := p.HandleRedirect(redirect(p, params.Get("state"), cookies...)); err != nil {
+		t.Fatal("first redirect failed: ", err)
+	}
+	if _, _, err := p.HandleRedirect(redirect(p, params.Get("state"), cookies...)); err == nil {
+		t.Fatal("replayed redirect succeeded")
+	}
+}
+
+// The PKCE verifier is random and kept by the browser that started the
+// login, so the state alone does not redeem an authorization code.
+func TestHandleRedirectPKCE(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := f.provider(t, "corp")
+
+	// An attacker starts a login in their browser, then
+	// the victim's code and state are replayed with the attacker's cookie.
+	_, attackerCookies := f.login(t, p, "")
+	params, cookies := f.login(t, p, "")
+	if len(cookies) != 1 {
+		t.Fatalf("login set %d cookies, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != "pkce_corp" || cookie.Path != "/redirect/corp" || !cookie.HttpOnly || !cookie.Secure ||
+		cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+		t.Errorf("verifier cookie %+v not bound to the redirect", cookie)
+	}
+	f.claims = map[string]any{
+		"iss":                f.URL,
+		"sub":                "1234",
+		"aud":                "panel",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              params.Get("nonce"),
+		"preferred_username": "bob",
+	}
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		ok      bool
+	}{
+		{"no verifier", nil, false},
+		{"verifier of another login", attackerCookies, false},
+		{"verifier of the login", cookies, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wr, req := redirect(p, params.Get("state"), tt.cookies...)
+			_, _, err := p.HandleRedirect(wr, req)
+			if (err == nil) != tt.ok {
+				t.Fatalf("HandleRedirect() error = %v, want success %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			cleared := wr.Result().Cookies()
+			if len(cleared) != 1 || cleared[0].Name != "pkce_corp" || cleared[0].MaxAge >= 0 {
+				t.Errorf("verifier cookie not cleared: %v", cleared)
+			}
+		})
+	}
+}
+
+// Usernames claimed at identity providers must not take over the Ghidra
+// accounts of Discord users or of users of other providers.
+func TestUsernameNamespace(t *testing.T) {
+	tests := []struct {
+		provider, claimed string
+		want              string
+	}{
+		{"corp", "bob", "corp-bob"},
+		{"gitlab", "bob", "gitlab-bob"},
+		{"corp", "corp-bob", "corp-corp-bob"},
+	}
+	seen := make(map[string]bool)
+	for _, tt := range tests {
+		got := Username(tt.provider, tt.claimed)
+		if got != tt.want {
+			t.Errorf("Username(%q, %q) = %q, want %q", tt.provider, tt.claimed, got, tt.want)
+		}
+		// Discord usernames consist of [a-z0-9_.] only
+		if !strings.Contains(got, "-") {
+			t.Errorf("Username(%q, %q) = %q could be a Discord username", tt.provider, tt.claimed, got)
+		}
+		if seen[got] {
+			t.Errorf("Username(%q, %q) = %q collides", tt.provider, tt.claimed, got)
+		}
+		seen[got] = true
+	}
+}
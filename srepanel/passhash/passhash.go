@@ -0,0 +1,135 @@
+// Package passhash hashes passwords with Argon2id on a bounded worker pool.
+//
+// Each hash allocates the full Argon2 memory cost, so running many at once
+// can exhaust the memory of small hosts. A Pool runs at most a fixed number
+// of hashes concurrently and queues a bounded number of callers; callers
+// beyond that are rejected right away instead of piling up.
+package passhash
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrBusy is returned when the queue of a Pool is full.
+var ErrBusy = errors.New("password hashing queue is full")
+
+// Params are Argon2id parameters.
+type Params struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+	KeyLen  uint32
+}
+
+// DefaultParams are the parameters of hash format 1, as verified by the
+// Ghidra authentication plugin. Changing them requires a new format.
+var DefaultParams = Params{Time: 1, Memory: 19456, Threads: 2, KeyLen: 32}
+
+const (
+	// DefaultWorkers is how many hashes run concurrently by default.
+	DefaultWorkers = 2
+	// DefaultQueueDepth is how many callers may wait for a worker by default.
+	DefaultQueueDepth = 16
+)
+
+// Pool runs password hashes with bounded concurrency.
+type Pool struct {
+	params  Params
+	workers chan struct{} // held while hashing
+	slots   chan struct{} // held while waiting or hashing
+
+	completed atomic.Uint64
+	rejected  atomic.Uint64
+	canceled  atomic.Uint64
+	waitNanos atomic.Uint64
+}
+
+// NewPool creates a pool running at most workers hashes at once,
+// with up to queueDepth callers waiting.
+func NewPool(params Params, workers, queueDepth int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+	return &Pool{
+		params:  params,
+		workers: make(chan struct{}, workers),
+		slots:   make(chan struct{}, workers+queueDepth),
+	}
+}
+
+// Hash derives the hash of a password, waiting for a free worker.
+// Fails with ErrBusy if the queue is full, or with the context error if
+// ctx ends while waiting. A hash that started runs to completion.
+func (p *Pool) Hash(ctx context.Context, password string, salt []byte) ([]byte, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		p.rejected.Add(1)
+		return nil, ErrBusy
+	}
+	defer func() { <-p.slots }()
+
+	start := time.Now()
+	select {
+	case p.workers <- struct{}{}:
+	case <-ctx.Done():
+		p.canceled.Add(1)
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.workers }()
+	p.waitNanos.Add(uint64(time.Since(start)))
+
+	hash := argon2.IDKey([]byte(password), salt, p.params.Time, p.params.Memory, p.params.Threads, p.params.KeyLen)
+	p.completed.Add(1)
+	return hash, nil
+}
+
+// Verify checks a password against a hash created with the same salt.
+func (p *Pool) Verify(ctx context.Context, password string, salt, hash []byte) (bool, error) {
+	derived, err := p.Hash(ctx, password, salt)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(derived, hash) == 1, nil
+}
+
+// Stats is a snapshot of the state of a Pool.
+type Stats struct {
+	Workers   int           // maximum concurrent hashes
+	Capacity  int           // maximum callers, hashing or waiting
+	Active    int           // hashes running
+	Queued    int           // callers waiting for a worker
+	Completed uint64        // hashes finished
+	Rejected  uint64        // callers turned away with ErrBusy
+	Canceled  uint64        // callers whose context ended while waiting
+	WaitTime  time.Duration // total time callers waited for a worker
+}
+
+// Stats returns a snapshot of the state of the pool.
+func (p *Pool) Stats() Stats {
+	active := len(p.workers)
+	queued := len(p.slots) - active
+	if queued < 0 {
+		// slots are taken before workers and released after them
+		queued = 0
+	}
+	return Stats{
+		Workers:   cap(p.workers),
+		Capacity:  cap(p.slots),
+		Active:    active,
+		Queued:    queued,
+		Completed: p.completed.Load(),
+		Rejected:  p.rejected.Load(),
+		Canceled:  p.canceled.Load(),
+		WaitTime:  time.Duration(p.waitNanos.Load()),
+	}
+}
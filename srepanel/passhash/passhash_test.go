@@ -0,0 +1,162 @@
+package passhash
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fastParams keep tests quick where the hash itself does not matter.
+var fastParams = Params{Time: 1, Memory: 64, Threads: 1, KeyLen: 32}
+
+// Hashes of format 1 are stored and verified by the Ghidra authentication
+// plugin, so DefaultParams must keep producing the same hash.
+func TestDefaultParams(t *testing.T) {
+	p := NewPool(DefaultParams, 1, 0)
+	hash, err := p.Hash(context.Background(), "hunter2", []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "f23b0613c6c3689c30754cd9ce11484f35687ab74d4dca49ea1244536b622b3c"
+	if got := hex.EncodeToString(hash); got != want {
+		t.Errorf("hash %s, want %s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p := NewPool(fastParams, 1, 0)
+	salt := []byte("salt salt salt!!")
+	hash, err := p.Hash(context.Background(), "hunter2", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		salt     []byte
+		ok       bool
+	}{
+		{"hunter2", salt, true},
+		{"hunter3", salt, false},
+		{"", salt, false},
+		{"hunter2", []byte("other salt value"), false},
+	}
+	for _, tt := range tests {
+		ok, err := p.Verify(context.Background(), tt.password, tt.salt, hash)
+		if err != nil || ok != tt.ok {
+			t.Errorf("Verify(%q, %q) = %v, %v, want %v", tt.password, tt.salt, ok, err, tt.ok)
+		}
+	}
+}
+
+// saturate occupies all workers of a pool, as if hashes were running,
+// and returns a function releasing them.
+func saturate(p *Pool) (release func()) {
+	for i := 0; i < cap(p.workers); i++ {
+		p.slots <- struct{}{}
+		p.workers <- struct{}{}
+	}
+	return func() {
+		for i := 0; i < cap(p.workers); i++ {
+			<-p.workers
+			<-p.slots
+		}
+	}
+}
+
+// waitQueued waits until n callers wait for a worker.
+func waitQueued(t *testing.T, p *Pool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stats().Queued != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want %d", p.Stats().Queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolBusy(t *testing.T) {
+	tests := []struct {
+		name               string
+		workers            int
+		queueDepth         int
+		wantWorkers, queue int // after defaults
+	}{
+		{"no queue", 1, 0, 1, 0},
+		{"queue", 2, 3, 2, 3},
+		{"invalid sizes", 0, -1, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPool(fastParams, tt.workers, tt.queueDepth)
+			workers, queueDepth := tt.wantWorkers, tt.queue
+			release := saturate(p)
+
+			// Fill the queue
+			results := make(chan error, queueDepth)
+			for i := 0; i < queueDepth; i++ {
+				go func() {
+					_, err := p.Hash(context.Background(), "hunter2", []byte("salt"))
+					results <- err
+				}()
+			}
+			waitQueued(t, p, queueDepth)
+
+			// Callers beyond the queue are rejected right away
+			for i := 0; i < 2; i++ {
+				if _, err := p.Hash(context.Background(), "hunter2", []byte("salt")); !errors.Is(err, ErrBusy) {
+					t.Fatalf("Hash() error = %v, want ErrBusy", err)
+				}
+			}
+			st := p.Stats()
+			want := Stats{Workers: workers, Capacity: workers + queueDepth, Active: workers, Queued: queueDepth, Rejected: 2}
+			st.WaitTime = 0
+			if st != want {
+				t.Errorf("Stats() = %+v, want %+v", st, want)
+			}
+
+			// Queued callers run once workers are free
+			release()
+			for i := 0; i < queueDepth; i++ {
+				if err := <-results; err != nil {
+					t.Errorf("queued Hash() error = %v", err)
+				}
+			}
+			if st := p.Stats(); st.Completed != uint64(queueDepth) || st.Active != 0 || st.Queued != 0 {
+				t.Errorf("Stats() = %+v after release", st)
+			}
+			if _, err := p.Hash(context.Background(), "hunter2", []byte("salt")); err != nil {
+				t.Errorf("Hash() error = %v after release", err)
+			}
+		})
+	}
+}
+
+func TestPoolCancel(t *testing.T) {
+	p := NewPool(fastParams, 1, 1)
+	release := saturate(p)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() {
+		_, err := p.Hash(ctx, "hunter2", []byte("salt"))
+		result <- err
+	}()
+	waitQueued(t, p, 1)
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Hash() error = %v, want context.Canceled", err)
+	}
+	// The slot of the canceled caller is free again
+	if st := p.Stats(); st.Canceled != 1 || st.Queued != 0 {
+		t.Errorf("Stats() = %+v", st)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Hash(ctx, "hunter2", []byte("salt")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Hash() error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,229 @@
+// Package probe periodically checks that the Ghidra server accepts
+// connections and notifies admins when it goes down or comes back.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/discord"
+)
+
+const (
+	// DefaultInterval is how often the Ghidra server is probed by default.
+	DefaultInterval = 30 * time.Second
+	// DefaultTimeout bounds each connection attempt by default.
+	DefaultTimeout = 5 * time.Second
+	// DefaultThreshold is how many consecutive probes must agree
+	// before the state changes.
+	DefaultThreshold = 2
+	// historySize is how many probes are kept, an hour at the default interval.
+	historySize = 120
+)
+
+// Ports returns the ports a Ghidra server listens on: the RMI registry
+// at the configured port, followed by the RMI server and block stream
+// ports derived from it.
+func Ports(endpoint common.GhidraEndpoint) []uint16 {
+	return []uint16{endpoint.Port, endpoint.Port + 1, endpoint.Port + 2}
+}
+
+// Status is the state of the Ghidra server as of the last probe.
+type Status struct {
+	Up        bool
+	Since     time.Time // when the server entered the current state
+	CheckedAt time.Time
+	Ports     []PortStatus
+	History   []Sample // oldest first
+}
+
+// PortStatus is the result of probing a single port.
+type PortStatus struct {
+	Port    uint16
+	Up      bool
+	Latency time.Duration
+	Error   string
+}
+
+// Sample is the result of a past probe.
+type Sample struct {
+	At      time.Time
+	Up      bool
+	Latency time.Duration // slowest port that answered
+}
+
+// Prober opens TCP connections to all ports of the Ghidra server.
+//
+// The server is up if all ports accept connections. Admins are notified
+// through the webhook when the state changes, after Threshold
+// consecutive probes agree to avoid flapping.
+type Prober struct {
+	Endpoint   common.GhidraEndpoint
+	Interval   time.Duration
+	Timeout    time.Duration
+	Threshold  int
+	WebhookURL func() string // admins are not notified if it returns ""
+
+	mu      sync.Mutex
+	status  *Status
+	streak  int // consecutive probes disagreeing with status.Up
+	history []Sample
+}
+
+// Run starts the probe loop.
+// Returns reason for context termination as error.
+func (p *Prober) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		p.Probe(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the state as of the last probe, or nil before the first one.
+func (p *Prober) Status() *Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.status == nil {
+		return nil
+	}
+	status := *p.status
+	status.History = append([]Sample(nil), p.history...)
+	return &status
+}
+
+// Probe checks all ports once and updates the state.
+func (p *Prober) Probe(ctx context.Context) {
+	now := time.Now()
+	ports := p.probePorts(ctx)
+	sample := Sample{At: now, Up: true}
+	for _, port := range ports {
+		sample.Up = sample.Up && port.Up
+		if port.Up && port.Latency > sample.Latency {
+			sample.Latency = port.Latency
+		}
+	}
+
+	changed, prev := p.record(sample, ports)
+	if changed {
+		p.notify(ctx, sample.Up, prev)
+	}
+}
+
+// record stores a probe result. Returns whether the state changed,
+// and since when the server was in the previous state.
+func (p *Prober) record(sample Sample, ports []PortStatus) (changed bool, prevSince time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.history = append(p.history, sample)
+	if len(p.history) > historySize {
+		p.history = append(p.history[:0], p.history[len(p.history)-historySize:]...)
+	}
+
+	if p.status == nil {
+		log.Printf("Ghidra server is %s", upDown(sample.Up))
+		p.status = &Status{Up: sample.Up, Since: sample.At}
+	} else if sample.Up != p.status.Up {
+		p.streak++
+		if p.streak >= p.threshold() {
+			log.Printf("Ghidra server is %s", upDown(sample.Up))
+			changed, prevSince = true, p.status.Since
+			p.status.Up = sample.Up
+			p.status.Since = sample.At
+			p.streak = 0
+		}
+	} else {
+		p.streak = 0
+	}
+	p.status.CheckedAt = sample.At
+	p.status.Ports = ports
+	return changed, prevSince
+}
+
+func (p *Prober) threshold() int {
+	if p.Threshold < 1 {
+		return DefaultThreshold
+	}
+	return p.Threshold
+}
+
+// probePorts connects to all ports concurrently.
+func (p *Prober) probePorts(ctx context.Context) []PortStatus {
+	ports := Ports(p.Endpoint)
+	results := make([]PortStatus, len(ports))
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		i, port := i, port
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.probePort(ctx, port)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (p *Prober) probePort(ctx context.Context, port uint16) PortStatus {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(p.Endpoint.Hostname, strconv.Itoa(int(port)))
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return PortStatus{Port: port, Error: err.Error()}
+	}
+	latency := time.Since(start)
+	conn.Close()
+	return PortStatus{Port: port, Up: true, Latency: latency}
+}
+
+func (p *Prober) notify(ctx context.Context, up bool, prevSince time.Time) {
+	webhookURL := p.WebhookURL()
+	if webhookURL == "" {
+		return
+	}
+	addr := net.JoinHostPort(p.Endpoint.Hostname, strconv.Itoa(int(p.Endpoint.Port)))
+	embed := discord.Embed{
+		Title:       "Ghidra server is down",
+		Description: fmt.Sprintf("%s stopped accepting connections after being up for %s.", addr, time.Since(prevSince).Round(time.Second)),
+		Color:       0xFF6961,
+	}
+	if up {
+		embed.Title = "Ghidra server is back up"
+		embed.Description = fmt.Sprintf("%s accepts connections again after being down for %s.", addr, time.Since(prevSince).Round(time.Second))
+		embed.Color = 0x77DD77
+	}
+	message := discord.WebhookMessage{
+		Username: "Panel",
+		Embeds:   []discord.Embed{embed},
+	}
+	if err := discord.SendWebhook(ctx, webhookURL, &message); err != nil {
+		log.Print("Failed to send Ghidra status notification: ", err)
+	}
+}
+
+func upDown(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
@@ -0,0 +1,320 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/discord"
+)
+
+// ghidraServer accepts connections on the three consecutive ports of a
+// Ghidra server.
+type ghidraServer struct {
+	t         *testing.T
+	endpoint  common.GhidraEndpoint
+	listeners []net.Listener
+}
+
+func listenGhidra(t *testing.T) *ghidraServer {
+	t.Helper()
+	for attempt := 0; attempt < 20; attempt++ {
+		first, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := &ghidraServer{
+			t:         t,
+			endpoint:  common.GhidraEndpoint{Hostname: "127.0.0.1", Port: uint16(first.Addr().(*net.TCPAddr).Port)},
+			listeners: []net.Listener{first},
+		}
+		for _, port := range Ports(g.endpoint)[1:] {
+			ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+			if err != nil {
+				break
+			}
+			g.listeners = append(g.listeners, ln)
+		}
+		if len(g.listeners) == len(Ports(g.endpoint)) {
+			for _, ln := range g.listeners {
+				go accept(ln)
+			}
+			t.Cleanup(g.close)
+			return g
+		}
+		// The following ports are taken, try elsewhere
+		for _, ln := range g.listeners {
+			ln.Close()
+		}
+	}
+	t.Fatal("no three consecutive free ports")
+	return nil
+}
+
+func accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+}
+
+func (g *ghidraServer) close() {
+	for _, ln := range g.listeners {
+		if ln != nil {
+			ln.Close()
+		}
+	}
+}
+
+// set starts or stops listening on the block stream port, the last one.
+func (g *ghidraServer) set(up bool) {
+	g.t.Helper()
+	last := len(g.listeners) - 1
+	if !up {
+		if g.listeners[last] != nil {
+			g.listeners[last].Close()
+			g.listeners[last] = nil
+		}
+		return
+	}
+	if g.listeners[last] != nil {
+		return
+	}
+	port := Ports(g.endpoint)[last]
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	g.listeners[last] = ln
+	go accept(ln)
+}
+
+// webhook records the notifications it receives.
+type webhook struct {
+	*httptest.Server
+	mu     sync.Mutex
+	titles []string
+	bodies []string
+}
+
+func newWebhook(t *testing.T) *webhook {
+	t.Helper()
+	w := &webhook{}
+	w.Server = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		var message discord.WebhookMessage
+		if err := json.NewDecoder(req.Body).Decode(&message); err != nil || len(message.Embeds) != 1 {
+			t.Errorf("malformed notification: %v", err)
+			wr.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.mu.Lock()
+		w.titles = append(w.titles, message.Embeds[0].Title)
+		w.bodies = append(w.bodies, message.Embeds[0].Description)
+		w.mu.Unlock()
+		wr.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(w.Close)
+	return w
+}
+
+func (w *webhook) received() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.titles...)
+}
+
+const (
+	notifiedDown = "Ghidra server is down"
+	notifiedUp   = "Ghidra server is back up"
+)
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		server    []bool // whether the server is up at each probe
+		want      []bool // reported state after each probe
+		notified  []string
+	}{
+		{
+			name:   "up",
+			server: []bool{true, true},
+			want:   []bool{true, true},
+		},
+		{
+			name:   "starts down",
+			server: []bool{false, false},
+			want:   []bool{false, false},
+		},
+		{
+			name:     "goes down",
+			server:   []bool{true, false, false, false},
+			want:     []bool{true, true, false, false},
+			notified: []string{notifiedDown},
+		},
+		{
+			name:     "comes back",
+			server:   []bool{false, true, true},
+			want:     []bool{false, false, true},
+			notified: []string{notifiedUp},
+		},
+		{
+			name:   "flapping",
+			server: []bool{true, false, true, false, true, false},
+			want:   []bool{true, true, true, true, true, true},
+		},
+		{
+			name:      "threshold",
+			threshold: 3,
+			server:    []bool{true, false, false, true, false, false, false, true, true, true},
+			want:      []bool{true, true, true, true, true, true, false, false, false, true},
+			notified:  []string{notifiedDown, notifiedUp},
+		},
+		{
+			name:      "no threshold",
+			threshold: 1,
+			server:    []bool{true, false, true},
+			want:      []bool{true, false, true},
+			notified:  []string{notifiedDown, notifiedUp},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := listenGhidra(t)
+			w := newWebhook(t)
+			p := &Prober{
+				Endpoint:   g.endpoint,
+				Timeout:    time.Second,
+				Threshold:  tt.threshold,
+				WebhookURL: func() string { return w.URL },
+			}
+			if p.Status() != nil {
+				t.Fatal("status before the first probe")
+			}
+			for i, up := range tt.server {
+				g.set(up)
+				p.Probe(context.Background())
+				status := p.Status()
+				if status.Up != tt.want[i] {
+					t.Fatalf("probe %d: up = %v, want %v", i, status.Up, tt.want[i])
+				}
+				if len(status.History) != i+1 || status.History[i].Up != up {
+					t.Fatalf("probe %d: history %+v does not end with up = %v", i, status.History, up)
+				}
+				checkPorts(t, g, status.Ports, up)
+			}
+			if got := w.received(); strings.Join(got, "\n") != strings.Join(tt.notified, "\n") {
+				t.Errorf("notified %q, want %q", got, tt.notified)
+			}
+		})
+	}
+}
+
+// checkPorts checks that only the block stream port is down if the
+// server is down.
+func checkPorts(t *testing.T, g *ghidraServer, ports []PortStatus, up bool) {
+	t.Helper()
+	want := Ports(g.endpoint)
+	if len(ports) != len(want) {
+		t.Fatalf("probed %d ports, want %d", len(ports), len(want))
+	}
+	for i, port := range ports {
+		portUp := up || i < len(ports)-1
+		if port.Port != want[i] || port.Up != portUp || (port.Error == "") != portUp {
+			t.Errorf("port %+v, want %d up = %v", port, want[i], portUp)
+		}
+		if !port.Up && port.Latency != 0 {
+			t.Errorf("port %d down with latency %s", port.Port, port.Latency)
+		}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	g := listenGhidra(t)
+	w := newWebhook(t)
+	webhookURL := ""
+	p := &Prober{
+		Endpoint:   g.endpoint,
+		Timeout:    time.Second,
+		Threshold:  1,
+		WebhookURL: func() string { return webhookURL },
+	}
+	p.Probe(context.Background())
+
+	// Admins are not notified without a webhook
+	g.set(false)
+	p.Probe(context.Background())
+	if got := w.received(); len(got) != 0 {
+		t.Fatalf("notified %q without a webhook", got)
+	}
+
+	webhookURL = w.URL
+	g.set(true)
+	p.Probe(context.Background())
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(int(g.endpoint.Port)))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.titles) != 1 || w.titles[0] != notifiedUp {
+		t.Fatalf("notified %q, want %q", w.titles, notifiedUp)
+	}
+	if !strings.Contains(w.bodies[0], addr) || !strings.Contains(w.bodies[0], "after being down for") {
+		t.Errorf("notification %q does not name %s and the downtime", w.bodies[0], addr)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		probes int
+		want   int
+	}{
+		{1, 1},
+		{historySize - 1, historySize - 1},
+		{historySize, historySize},
+		{historySize + 1, historySize},
+		{3*historySize + 7, historySize},
+	}
+	for _, tt := range tests {
+		p := &Prober{}
+		for i := 0; i < tt.probes; i++ {
+			p.record(Sample{At: start.Add(time.Duration(i) * time.Second), Up: true}, nil)
+		}
+		status := p.Status()
+		if len(status.History) != tt.want {
+			t.Fatalf("%d probes: %d samples kept, want %d", tt.probes, len(status.History), tt.want)
+		}
+		// The oldest samples are dropped
+		for i, sample := range status.History {
+			want := start.Add(time.Duration(tt.probes-tt.want+i) * time.Second)
+			if !sample.At.Equal(want) {
+				t.Fatalf("%d probes: sample %d at %s, want %s", tt.probes, i, sample.At, want)
+			}
+		}
+		if cap(p.history) > 2*historySize {
+			t.Errorf("%d probes: history grew to capacity %d", tt.probes, cap(p.history))
+		}
+		// Callers get a copy
+		status.History[0].Up = false
+		if !p.Status().History[0].Up {
+			t.Errorf("%d probes: status shares the history", tt.probes)
+		}
+	}
+}
+
+func TestPorts(t *testing.T) {
+	got := Ports(common.GhidraEndpoint{Hostname: "ghidra", Port: 13100})
+	if len(got) != 3 || got[0] != 13100 || got[1] != 13101 || got[2] != 13102 {
+		t.Errorf("Ports() = %v, want [13100 13101 13102]", got)
+	}
+}
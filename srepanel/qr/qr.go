@@ -0,0 +1,470 @@
+// Package qr encodes short strings as QR codes (ISO/IEC 18004).
+//
+// Only what the panel needs is supported: byte mode, error correction
+// level M and versions 1 to 10, which fit up to 213 bytes.
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTooLong is returned for data that does not fit into a version 10 code.
+var ErrTooLong = errors.New("qr: data too long")
+
+// Code is a QR code symbol.
+type Code struct {
+	Size    int
+	modules [][]bool // [y][x], true is dark
+}
+
+// Dark returns whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// versionInfo describes the error correction blocks of a version at level M.
+type versionInfo struct {
+	ecPerBlock int
+	blocks1    int // blocks in group 1
+	data1      int // data codewords per block in group 1
+	blocks2    int // blocks in group 2, which hold one more data codeword
+	alignment  []int
+}
+
+var versions = [...]versionInfo{
+	1:  {10, 1, 16, 0, nil},
+	2:  {16, 1, 28, 0, []int{6, 18}},
+	3:  {26, 1, 44, 0, []int{6, 22}},
+	4:  {18, 2, 32, 0, []int{6, 26}},
+	5:  {24, 2, 43, 0, []int{6, 30}},
+	6:  {16, 4, 27, 0, []int{6, 34}},
+	7:  {18, 4, 31, 0, []int{6, 22, 38}},
+	8:  {22, 2, 38, 2, []int{6, 24, 42}},
+	9:  {22, 3, 36, 2, []int{6, 26, 46}},
+	10: {26, 4, 43, 1, []int{6, 28, 50}},
+}
+
+func (v *versionInfo) dataCodewords() int {
+	return v.blocks1*v.data1 + v.blocks2*(v.data1+1)
+}
+
+// Encode encodes data as a QR code of the smallest fitting version.
+func Encode(data string) (*Code, error) {
+	for version := 1; version < len(versions); version++ {
+		info := &versions[version]
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) > 8*info.dataCodewords() {
+			continue
+		}
+		codewords := addErrorCorrection(info, encodeData(info, countBits, data))
+		return build(version, info, codewords), nil
+	}
+	return nil, ErrTooLong
+}
+
+// encodeData returns the data codewords of a byte mode segment.
+func encodeData(info *versionInfo, countBits int, data string) []byte {
+	var bits bitBuffer
+	bits.append(0b0100, 4) // byte mode
+	bits.append(uint(len(data)), countBits)
+	for i := 0; i < len(data); i++ {
+		bits.append(uint(data[i]), 8)
+	}
+
+	capacity := 8 * info.dataCodewords()
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := uint(0xEC); len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes()
+}
+
+// addErrorCorrection splits data into blocks and interleaves them with their
+// Reed-Solomon error correction codewords.
+func addErrorCorrection(info *versionInfo, data []byte) []byte {
+	divisor := rsDivisor(info.ecPerBlock)
+	var blocks, ecBlocks [][]byte
+	for i, off := 0, 0; i < info.blocks1+info.blocks2; i++ {
+		n := info.data1
+		if i >= info.blocks1 {
+			n++
+		}
+		block := data[off : off+n]
+		off += n
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+	}
+
+	var out []byte
+	for i := 0; i <= info.data1; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < info.ecPerBlock; i++ {
+		for _, ec := range ecBlocks {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// builder holds a symbol under construction.
+type builder struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // modules not holding data
+}
+
+func build(version int, info *versionInfo, codewords []byte) *Code {
+	size := 17 + 4*version
+	b := &builder{size: size, modules: makeGrid(size), function: makeGrid(size)}
+	b.drawFunctionPatterns(version, info)
+	b.drawCodewords(codewords)
+
+	// Pick the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		b.applyMask(mask)
+		b.drawFormatBits(mask)
+		if p := b.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		b.applyMask(mask) // undo
+	}
+	b.applyMask(best)
+	b.drawFormatBits(best)
+	return &Code{Size: size, modules: b.modules}
+}
+
+func makeGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for y := range grid {
+		grid[y] = make([]bool, size)
+	}
+	return grid
+}
+
+func (b *builder) set(x, y int, dark bool) {
+	b.modules[y][x] = dark
+	b.function[y][x] = true
+}
+
+func (b *builder) drawFunctionPatterns(version int, info *versionInfo) {
+	// Timing patterns
+	for i := 0; i < b.size; i++ {
+		b.set(6, i, i%2 == 0)
+		b.set(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	for _, c := range [][2]int{{3, 3}, {b.size - 4, 3}, {3, b.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= b.size || y < 0 || y >= b.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				b.set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, except where they would overlap finder patterns
+	pos := info.alignment
+	for i := range pos {
+		for j := range pos {
+			last := len(pos) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					b.set(pos[i]+dx, pos[j]+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve format information, drawn after masking
+	b.drawFormatBits(0)
+
+	// Version information
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 != 0
+			a, c := b.size-11+i%3, i/3
+			b.set(a, c, dark)
+			b.set(c, a, dark)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information
+// for error correction level M and the given mask.
+func (b *builder) drawFormatBits(mask int) {
+	data := 0b00<<3 | mask // level M
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		b.set(8, i, bit(i))
+	}
+	b.set(8, 7, bit(6))
+	b.set(8, 8, bit(7))
+	b.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		b.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		b.set(b.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		b.set(8, b.size-15+i, bit(i))
+	}
+	b.set(8, b.size-8, true) // dark module
+}
+
+// drawCodewords places the codewords in the zigzag order of the symbol.
+func (b *builder) drawCodewords(codewords []byte) {
+	i := 0
+	for right := b.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < b.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if upward {
+					y = b.size - 1 - vert
+				}
+				if b.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				b.modules[y][x] = (codewords[i/8]>>(7-i%8))&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips data modules according to a mask pattern.
+// Applying the same mask twice undoes it.
+func (b *builder) applyMask(mask int) {
+	for y := 0; y < b.size; y++ {
+		for x := 0; x < b.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !b.function[y][x] {
+				b.modules[y][x] = !b.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to read, lower is better.
+func (b *builder) penalty() int {
+	n := b.size
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return b.modules[x][y]
+		}
+		return b.modules[y][x]
+	}
+
+	score := 0
+	finderLike := []string{"10111010000", "00001011101"}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			// Runs of five or more modules of the same color
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			// Patterns resembling finder patterns
+			var line strings.Builder
+			for x := 0; x < n; x++ {
+				if at(x, y, transpose) {
+					line.WriteByte('1')
+				} else {
+					line.WriteByte('0')
+				}
+			}
+			for _, pattern := range finderLike {
+				for s := line.String(); ; {
+					i := strings.Index(s, pattern)
+					if i < 0 {
+						break
+					}
+					score += 40
+					s = s[i+1:]
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if b.modules[y][x] {
+				dark++
+			}
+			if x < n-1 && y < n-1 {
+				c := b.modules[y][x]
+				if c == b.modules[y][x+1] && c == b.modules[y+1][x] && c == b.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// Imbalance of dark and light modules
+	total := n * n
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	score += k * 10
+	return score
+}
+
+// SVG renders the code as an SVG image with a quiet zone,
+// scaled to the given width in pixels.
+func (c *Code) SVG(width int) string {
+	const quiet = 4
+	dim := c.Size + 2*quiet
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x+quiet, y+quiet)
+			}
+		}
+	}
+	return fmt.Sprintf(
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+			`<rect width="100%%" height="100%%" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		width, width, dim, dim, path.String(),
+	)
+}
+
+// bitBuffer is a sequence of bits, most significant bit first.
+type bitBuffer []bool
+
+func (b *bitBuffer) append(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (v>>i)&1 != 0)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, (len(b)+7)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// without its leading coefficient.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMul(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
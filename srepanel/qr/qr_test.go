@@ -0,0 +1,365 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrorCorrection(t *testing.T) {
+	// "HELLO WORLD" at version 1-M, from the worked example at
+	// https://www.thonky.com/qr-code-tutorial/error-correction-coding
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	got := addErrorCorrection(&versions[1], data)
+	if !bytes.Equal(got[:len(data)], data) || !bytes.Equal(got[len(data):], want) {
+		t.Errorf("codewords %v, want error correction %v", got, want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int
+	}{
+		{"empty", "", 1},
+		{"version 1", strings.Repeat("a", 14), 1},
+		{"version 2", strings.Repeat("a", 15), 2},
+		{"binary", "\x00\xff\x80 \n", 1},
+		{"otpauth URI", "otpauth://totp/Ghidra%20Panel:bob?algorithm=SHA1&digits=6&issuer=Ghidra+Panel&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 8},
+		{"version 7", strings.Repeat("b", 120), 7},
+		{"version 9", strings.Repeat("c", 180), 9},
+		{"version 10", strings.Repeat("d", 213), 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code.Size != 17+4*tt.version {
+				t.Errorf("size %d, want version %d", code.Size, tt.version)
+			}
+			if got := decode(t, code); got != tt.data {
+				t.Errorf("decoded %q, want %q", got, tt.data)
+			}
+		})
+	}
+
+	if _, err := Encode(strings.Repeat("e", 214)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode() of 214 bytes error = %v, want ErrTooLong", err)
+	}
+}
+
+// Encode picks the mask pattern, so each one is decoded separately.
+func TestMasks(t *testing.T) {
+	const data = "otpauth://totp/x"
+	for _, version := range []int{2, 7} {
+		info := &versions[version]
+		countBits := 8
+		codewords := addErrorCorrection(info, encodeData(info, countBits, data))
+		for mask := 0; mask < 8; mask++ {
+			size := 17 + 4*version
+			b := &builder{size: size, modules: makeGrid(size), function: makeGrid(size)}
+			b.drawFunctionPatterns(version, info)
+			b.drawCodewords(codewords)
+			b.applyMask(mask)
+			b.drawFormatBits(mask)
+			if got := decode(t, &Code{Size: size, modules: b.modules}); got != data {
+				t.Errorf("version %d, mask %d: decoded %q, want %q", version, mask, got, data)
+			}
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	code, err := Encode("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := code.SVG(200)
+	if !strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" width="200" height="200" viewBox="0 0 29 29"`) {
+		t.Errorf("SVG %.100s", svg)
+	}
+	dark := 0
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Dark(x, y) {
+				dark++
+			}
+		}
+	}
+	if n := strings.Count(svg, "h1v1h-1z"); n != dark {
+		t.Errorf("%d modules drawn, want %d", n, dark)
+	}
+}
+
+// Codewords per symbol by version (ISO/IEC 18004, table 1)
+var totalCodewords = [...]int{1: 26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+
+// Alignment pattern centers by version (ISO/IEC 18004, annex E)
+var alignmentCenters = [...][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+// decode reads a symbol like a QR code reader and returns its data,
+// failing the test on any deviation from the standard.
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+	size := c.Size
+	version := (size - 17) / 4
+	if size != 17+4*version || version < 1 || version >= len(totalCodewords) {
+		t.Fatalf("invalid size %d", size)
+	}
+
+	// Finder patterns and timing patterns
+	for _, corner := range [][2]int{{0, 0}, {size - 7, 0}, {0, size - 7}} {
+		for dy := 0; dy < 7; dy++ {
+			for dx := 0; dx < 7; dx++ {
+				dist := max(abs(dx-3), abs(dy-3))
+				if c.Dark(corner[0]+dx, corner[1]+dy) != (dist != 2) {
+					t.Fatalf("broken finder pattern at %v", corner)
+				}
+			}
+		}
+	}
+	for i := 8; i < size-8; i++ {
+		if c.Dark(i, 6) != (i%2 == 0) || c.Dark(6, i) != (i%2 == 0) {
+			t.Fatalf("broken timing pattern at %d", i)
+		}
+	}
+	if !c.Dark(8, size-8) {
+		t.Fatal("dark module missing")
+	}
+
+	// Format information, both copies
+	var format1, format2 int
+	for i := 0; i < 15; i++ {
+		var x1, y1, x2, y2 int
+		switch {
+		case i < 6:
+			x1, y1 = 8, i
+		case i < 8:
+			x1, y1 = 8, i+1
+		case i == 8:
+			x1, y1 = 7, 8
+		default:
+			x1, y1 = 14-i, 8
+		}
+		if i < 8 {
+			x2, y2 = size-1-i, 8
+		} else {
+			x2, y2 = 8, size-15+i
+		}
+		if c.Dark(x1, y1) {
+			format1 |= 1 << i
+		}
+		if c.Dark(x2, y2) {
+			format2 |= 1 << i
+		}
+	}
+	if format1 != format2 {
+		t.Fatalf("format information copies differ: %015b, %015b", format1, format2)
+	}
+	mask := -1
+	for data := 0; data < 32; data++ {
+		if bchFormat(data) == format1 {
+			if data>>3 != 0b00 {
+				t.Fatalf("error correction level %02b, want M", data>>3)
+			}
+			mask = data & 7
+		}
+	}
+	if mask < 0 {
+		t.Fatalf("invalid format information %015b", format1)
+	}
+
+	// Version information, both copies
+	if version >= 7 {
+		want := bchVersion(version)
+		for i := 0; i < 18; i++ {
+			bit := (want>>i)&1 != 0
+			if c.Dark(size-11+i%3, i/3) != bit || c.Dark(i/3, size-11+i%3) != bit {
+				t.Fatalf("broken version information bit %d", i)
+			}
+		}
+	}
+
+	// Modules holding data
+	function := make([][]bool, size)
+	for y := range function {
+		function[y] = make([]bool, size)
+	}
+	fill := func(x0, y0, w, h int) {
+		for y := y0; y < y0+h; y++ {
+			for x := x0; x < x0+w; x++ {
+				function[y][x] = true
+			}
+		}
+	}
+	fill(0, 0, 9, 9)
+	fill(size-8, 0, 8, 9)
+	fill(0, size-8, 9, 8)
+	fill(6, 0, 1, size)
+	fill(0, 6, size, 1)
+	centers := alignmentCenters[version]
+	for i, cy := range centers {
+		for j, cx := range centers {
+			last := len(centers) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			fill(cx-2, cy-2, 5, 5)
+		}
+	}
+	if version >= 7 {
+		fill(size-11, 0, 3, 6)
+		fill(0, size-11, 6, 3)
+	}
+
+	// Read codewords in zigzag order, removing the mask
+	var bits []bool
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		upward := (right+1)&2 == 0
+		for i := 0; i < size; i++ {
+			y := i
+			if upward {
+				y = size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if !function[y][x] {
+					bits = append(bits, c.Dark(x, y) != masked(mask, x, y))
+				}
+			}
+		}
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for _, bit := range bits[8*i : 8*i+8] {
+			codewords[i] <<= 1
+			if bit {
+				codewords[i] |= 1
+			}
+		}
+	}
+	if len(codewords) != totalCodewords[version] {
+		t.Fatalf("%d codewords, want %d", len(codewords), totalCodewords[version])
+	}
+
+	// De-interleave blocks and check their error correction
+	info := &versions[version]
+	nBlocks := info.blocks1 + info.blocks2
+	blocks := make([][]byte, nBlocks)
+	i := 0
+	for k := 0; k <= info.data1; k++ {
+		for b := range blocks {
+			if k < info.data1 || b >= info.blocks1 {
+				blocks[b] = append(blocks[b], codewords[i])
+				i++
+			}
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, block...)
+	}
+	for k := 0; k < info.ecPerBlock; k++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[i])
+			i++
+		}
+	}
+	if i != len(codewords) {
+		t.Fatalf("%d codewords left over", len(codewords)-i)
+	}
+	for b, block := range blocks {
+		root := byte(1)
+		for k := 0; k < info.ecPerBlock; k++ {
+			var syndrome byte
+			for _, cw := range block {
+				syndrome = gfMul(syndrome, root) ^ cw
+			}
+			if syndrome != 0 {
+				t.Fatalf("block %d has syndrome %d at root %d", b, syndrome, k)
+			}
+			root = gfMul(root, 2)
+		}
+	}
+
+	// Byte mode segment
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	read := func(off, n int) int {
+		v := 0
+		for i := off; i < off+n; i++ {
+			v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+	if mode := read(0, 4); mode != 0b0100 {
+		t.Fatalf("mode %04b, want byte mode", mode)
+	}
+	n := read(4, countBits)
+	if 4+countBits+8*n > 8*len(data) {
+		t.Fatalf("count %d exceeds the data", n)
+	}
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = byte(read(4+countBits+8*i, 8))
+	}
+	return string(out)
+}
+
+// masked reports whether mask pattern m flips the module at x, y.
+func masked(m, x, y int) bool {
+	switch m {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+(y*x)%3)%2 == 0
+	default:
+		return ((y+x)%2+(y*x)%3)%2 == 0
+	}
+}
+
+// bchFormat returns the masked 15-bit format information of 5 data bits.
+func bchFormat(data int) int {
+	return (data<<10 | polyMod(data<<10, 0b10100110111)) ^ 0b101010000010010
+}
+
+// bchVersion returns the 18-bit version information.
+func bchVersion(version int) int {
+	return version<<12 | polyMod(version<<12, 0b1111100100101)
+}
+
+// polyMod returns the remainder of the GF(2) polynomial division v / g.
+func polyMod(v, g int) int {
+	deg := 0
+	for g>>(deg+1) != 0 {
+		deg++
+	}
+	for i := 30; i >= deg; i-- {
+		if v>>i&1 != 0 {
+			v ^= g << (i - deg)
+		}
+	}
+	return v
+}
@@ -0,0 +1,120 @@
+// Package ratelimit provides in-process token bucket rate limiting.
+package ratelimit
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that refilled completely are dropped.
+const sweepInterval = 10 * time.Minute
+
+// Rule configures a token bucket: one token is added every Every,
+// up to Burst tokens.
+type Rule struct {
+	Every time.Duration
+	Burst int
+}
+
+// Validate checks that the rule allows requests at all.
+func (r Rule) Validate() error {
+	if r.Every <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if r.Burst <= 0 {
+		return errors.New("burst must be positive")
+	}
+	return nil
+}
+
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	limited bool // denied since the last allowed request
+}
+
+// Limiter tracks one token bucket per key.
+type Limiter struct {
+	rule Rule
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a Limiter. The rule must be valid.
+func New(rule Rule) *Limiter {
+	return &Limiter{
+		rule:      rule,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the buckets of all keys, if each has one left.
+// Otherwise returns how long to wait until all do, and whether this is the
+// first denial for one of the keys since it was last allowed.
+func (l *Limiter) Allow(keys ...string) (ok bool, retryAfter time.Duration, first bool) {
+	return l.allowAt(time.Now(), keys...)
+}
+
+func (l *Limiter) allowAt(now time.Time, keys ...string) (ok bool, retryAfter time.Duration, first bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	ok = true
+	for _, key := range keys {
+		b := l.refill(key, now)
+		if b.tokens >= 1 {
+			continue
+		}
+		ok = false
+		if wait := time.Duration((1 - b.tokens) * float64(l.rule.Every)); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	for _, key := range keys {
+		b := l.buckets[key]
+		if ok {
+			b.tokens--
+			b.limited = false
+		} else if !b.limited && b.tokens < 1 {
+			b.limited = true
+			first = true
+		}
+	}
+	return ok, retryAfter, first
+}
+
+// refill returns the bucket of a key, topped up for the elapsed time.
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rule.Burst), last: now}
+		l.buckets[key] = b
+		return b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(l.rule.Every)
+	if max := float64(l.rule.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+	return b
+}
+
+// sweep drops buckets that are full again, as they are equal to new ones.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.rule.Burst) * l.rule.Every
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		ok   bool
+	}{
+		{Rule{Every: time.Second, Burst: 1}, true},
+		{Rule{Every: 0, Burst: 1}, false},
+		{Rule{Every: -time.Second, Burst: 1}, false},
+		{Rule{Every: time.Second, Burst: 0}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v.Validate() = %v, want valid %v", tt.rule, err, tt.ok)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	// step is a request at an offset from the start
+	type step struct {
+		at         time.Duration
+		keys       []string
+		ok         bool
+		retryAfter time.Duration
+		first      bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "burst and refill",
+			steps: []step{
+				{at: 0, keys: []string{"a"}, ok: true},
+				{at: 0, keys: []string{"a"}, ok: true},
+				{at: 0, keys: []string{"a"}, retryAfter: 10 * time.Second, first: true},
+				{at: 0, keys: []string{"a"}, retryAfter: 10 * time.Second},
+				{at: 5 * time.Second, keys: []string{"a"}, retryAfter: 5 * time.Second},
+				{at: 10 * time.Second, keys: []string{"a"}, ok: true},
+				{at: 10 * time.Second, keys: []string{"a"}, retryAfter: 10 * time.Second, first: true},
+			},
+		},
+		{
+			name: "refill up to burst",
+			steps: []step{
+				{at: 0, keys: []string{"a"}, ok: true},
+				{at: time.Hour, keys: []string{"a"}, ok: true},
+				{at: time.Hour, keys: []string{"a"}, ok: true},
+				{at: time.Hour, keys: []string{"a"}, retryAfter: 10 * time.Second, first: true},
+			},
+		},
+		{
+			name: "keys are independent",
+			steps: []step{
+				{at: 0, keys: []string{"a"}, ok: true},
+				{at: 0, keys: []string{"a"}, ok: true},
+				{at: 0, keys: []string{"b"}, ok: true},
+				{at: 0, keys: []string{"a"}, retryAfter: 10 * time.Second, first: true},
+			},
+		},
+		{
+			name: "all keys must allow",
+			steps: []step{
+				{at: 0, keys: []string{"ip", "user"}, ok: true},
+				{at: 0, keys: []string{"ip"}, ok: true},
+				// ip is empty, user keeps its token
+				{at: 0, keys: []string{"ip", "user"}, retryAfter: 10 * time.Second, first: true},
+				{at: 0, keys: []string{"user"}, ok: true},
+				{at: 2 * time.Second, keys: []string{"ip", "user"}, retryAfter: 8 * time.Second, first: true},
+				{at: 5 * time.Second, keys: []string{"ip", "user"}, retryAfter: 5 * time.Second},
+				{at: 10 * time.Second, keys: []string{"ip", "user"}, ok: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(Rule{Every: 10 * time.Second, Burst: 2})
+			start := time.Now()
+			for i, s := range tt.steps {
+				ok, retryAfter, first := l.allowAt(start.Add(s.at), s.keys...)
+				if ok != s.ok || retryAfter != s.retryAfter || first != s.first {
+					t.Errorf("step %d: Allow(%q) = %v, %v, %v, want %v, %v, %v",
+						i, s.keys, ok, retryAfter, first, s.ok, s.retryAfter, s.first)
+				}
+			}
+		})
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(Rule{Every: time.Second, Burst: 2})
+	start := time.Now()
+	l.allowAt(start, "idle")
+	l.allowAt(start.Add(sweepInterval-time.Second), "busy")
+	l.allowAt(start.Add(sweepInterval-time.Second), "busy")
+	l.allowAt(start.Add(sweepInterval), "new")
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("full bucket kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket dropped before it refilled")
+	}
+	if n := len(l.buckets); n != 2 {
+		t.Errorf("%d buckets, want 2", n)
+	}
+}
+
+func TestConcurrentAllow(t *testing.T) {
+	const burst = 50
+	l := New(Rule{Every: time.Hour, Burst: burst})
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if ok, _, _ := l.Allow("shared", fmt.Sprint("own", i)); ok {
+					allowed.Add(1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != burst {
+		t.Errorf("%d requests allowed, want %d", n, burst)
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
-	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
+
+	"go.mkw.re/ghidra-panel/token"
+	"go.mkw.re/ghidra-panel/web"
 )
 
 type Secrets struct {
-	HMACSecret []byte `json:"hmac_secret"`
+	// HMACSecret is the single key used before key rotation was supported.
+	// It is migrated into HMACKeys when read.
+	HMACSecret []byte        `json:"hmac_secret,omitempty"`
+	HMACKeys   token.Keyring `json:"hmac_keys"`
 }
 
 func ReadSecrets(filePath string) (secrets *Secrets, err error) {
@@ -17,27 +25,95 @@ func ReadSecrets(filePath string) (secrets *Secrets, err error) {
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(secretsJSON, secrets)
-	return
+	if err = json.Unmarshal(secretsJSON, secrets); err != nil {
+		return nil, err
+	}
+
+	if secrets.HMACSecret != nil {
+		if len(secrets.HMACSecret) != token.KeySize {
+			return nil, fmt.Errorf("hmac_secret has %d bytes, expected %d", len(secrets.HMACSecret), token.KeySize)
+		}
+		if len(secrets.HMACKeys) == 0 {
+			secrets.HMACKeys = token.Keyring{{ID: "legacy", Secret: secrets.HMACSecret}}
+		}
+		secrets.HMACSecret = nil
+	}
+	if err := secrets.HMACKeys.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid hmac_keys: %w", err)
+	}
+	return secrets, nil
 }
 
 func RandomSecrets() *Secrets {
-	var hmacSecret [32]byte
-	if _, err := rand.Read(hmacSecret[:]); err != nil {
-		log.Fatal(err)
-	}
 	return &Secrets{
-		HMACSecret: hmacSecret[:],
+		HMACKeys: token.Keyring{token.NewKey()},
 	}
 }
 
 func generateSecrets(filePath string) {
-	secrets := RandomSecrets()
+	if err := writeSecrets(filePath, RandomSecrets()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeSecrets atomically replaces the secrets file.
+func writeSecrets(filePath string, secrets *Secrets) error {
 	secretsJSON, err := json.MarshalIndent(secrets, "", "\t")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	if err := os.WriteFile(filePath, secretsJSON, 0600); err != nil {
-		log.Fatal(err)
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".secrets.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(secretsJSON); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp already restricts the file to the owner
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// rotateSecrets adds a new signing key and retires the previous ones
+// after retireAfter. Returns the ID of the new key.
+func rotateSecrets(filePath string, retireAfter time.Duration) (keyID string, err error) {
+	secrets, err := ReadSecrets(filePath)
+	if err != nil {
+		return "", err
+	}
+	secrets.HMACKeys = secrets.HMACKeys.Rotate(retireAfter)
+	if err := writeSecrets(filePath, secrets); err != nil {
+		return "", err
+	}
+	return secrets.HMACKeys.Signing().ID, nil
+}
+
+// Purposes of keys derived from the keyring.
+const (
+	csrfKeyPurpose  = "csrf-session"
+	stateKeyPurpose = "oauth-state:" // followed by the provider ID
+)
+
+// stateKeyed is an identity provider with persistent OAuth state keys.
+type stateKeyed interface {
+	ID() string
+	SetStateKeys(keys [][]byte)
+}
+
+// applySecrets reads the secrets file and starts using its keys for
+// sessions, forms and the OAuth states of providers.
+func applySecrets(filePath string, server *web.Server, providers []stateKeyed) error {
+	secrets, err := ReadSecrets(filePath)
+	if err != nil {
+		return err
+	}
+	server.SetKeys(secrets.HMACKeys, secrets.HMACKeys.Derive(csrfKeyPurpose))
+	for _, provider := range providers {
+		provider.SetStateKeys(secrets.HMACKeys.Derive(stateKeyPurpose + provider.ID()))
 	}
+	return nil
 }
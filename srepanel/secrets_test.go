@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/csrf"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/ghidra"
+	"go.mkw.re/ghidra-panel/token"
+	"go.mkw.re/ghidra-panel/web"
+)
+
+// stubProvider records the OAuth state keys it is given.
+type stubProvider struct {
+	stateKeys [][]byte
+}
+
+func (*stubProvider) ID() string   { return "stub" }
+func (*stubProvider) Name() string { return "Stub" }
+func (*stubProvider) AuthURL(http.ResponseWriter, string) string {
+	return "https://idp.example/authorize"
+}
+func (*stubProvider) HandleRedirect(http.ResponseWriter, *http.Request) (*common.Identity, string, error) {
+	return nil, "", nil
+}
+func (p *stubProvider) SetStateKeys(keys [][]byte) { p.stateKeys = keys }
+
+// kid returns the key ID in the header of a session token.
+func kid(t *testing.T, jwt string) string {
+	t.Helper()
+	hdrJSON, err := base64.RawURLEncoding.DecodeString(strings.Split(jwt, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr struct {
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(hdrJSON, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	return hdr.Kid
+}
+
+func TestRotateSecretsLive(t *testing.T) {
+	secretsPath := filepath.Join(t.TempDir(), "secrets.json")
+	generateSecrets(secretsPath)
+	secrets, err := ReadSecrets(secretsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeyID := secrets.HMACKeys.Signing().ID
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	provider := new(stubProvider)
+	issuer := token.NewIssuer(secrets.HMACKeys, "https://panel.example", time.Hour, time.Hour)
+	server, err := web.NewServer(
+		&web.Config{CSRFKeys: secrets.HMACKeys.Derive(csrfKeyPurpose)},
+		db, []web.IdentityProvider{provider}, &issuer, &ghidra.ACLMon{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ident := &common.Identity{ID: 1, Provider: "stub", Subject: "1", Username: "bob"}
+	oldJWT, oldClaims := server.Issuer().Issue(ident)
+	if got := kid(t, oldJWT); got != oldKeyID {
+		t.Fatalf("signed with %q before rotation, want %q", got, oldKeyID)
+	}
+	oldCSRF := server.CSRF.Token(oldClaims.Jti)
+
+	keyID, err := rotateSecrets(secretsPath, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID == oldKeyID {
+		t.Fatal("rotation kept the signing key")
+	}
+	if err := applySecrets(secretsPath, server, []stateKeyed{provider}); err != nil {
+		t.Fatal(err)
+	}
+
+	jwt, claims := server.Issuer().Issue(ident)
+	if got := kid(t, jwt); got != keyID {
+		t.Errorf("signed with %q after rotation, want %q", got, keyID)
+	}
+	if _, ok := server.Issuer().VerifyClaims(jwt); !ok {
+		t.Error("session signed after rotation does not verify")
+	}
+	// Until the previous key retires
+	if _, ok := server.Issuer().VerifyClaims(oldJWT); !ok {
+		t.Error("session signed before rotation no longer verifies")
+	}
+	if !server.CSRF.Verify(oldClaims.Jti, oldCSRF) {
+		t.Error("form token issued before rotation no longer verifies")
+	}
+	if server.CSRF.Token(claims.Jti) == csrf.NewSynchronizer(issuer.Keys.Derive(csrfKeyPurpose)).Token(claims.Jti) {
+		t.Error("form tokens still issued with the previous key")
+	}
+	rotated, err := ReadSecrets(secretsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := rotated.HMACKeys.Derive(stateKeyPurpose + "stub"); len(provider.stateKeys) == 0 || !bytes.Equal(provider.stateKeys[0], want[0]) {
+		t.Error("OAuth states not issued with the new key")
+	}
+}
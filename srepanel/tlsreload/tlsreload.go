@@ -0,0 +1,127 @@
+// Package tlsreload serves a TLS certificate that is reloaded from disk
+// when it is renewed, without restarting listeners or dropping connections.
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWatchInterval is how often certificate files are checked for changes.
+const DefaultWatchInterval = 10 * time.Second
+
+// Certificate is a certificate and key pair loaded from files.
+// Handshakes use the last pair that loaded successfully.
+type Certificate struct {
+	certFile, keyFile string
+
+	cert atomic.Pointer[tls.Certificate]
+
+	mu    sync.Mutex // serializes reloads
+	stamp [2]fileStamp
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// Load reads a PEM certificate chain and key pair.
+func Load(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again. The previous pair stays in use on error.
+func (c *Certificate) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reload()
+}
+
+func (c *Certificate) reload() error {
+	certStamp, err := stampOf(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyStamp, err := stampOf(c.keyFile)
+	if err != nil {
+		return err
+	}
+	// Remember failed versions too, to report each broken version only once
+	c.stamp = [2]fileStamp{certStamp, keyStamp}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+// changed returns whether the files differ from the last load attempt.
+func (c *Certificate) changed() bool {
+	certStamp, err := stampOf(c.certFile)
+	if err != nil {
+		return false
+	}
+	keyStamp, err := stampOf(c.keyFile)
+	if err != nil {
+		return false
+	}
+	return [2]fileStamp{certStamp, keyStamp} != c.stamp
+}
+
+// Watch reloads the pair whenever its files change, until ctx ends.
+// Returns reason for context termination as error.
+func (c *Certificate) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		if c.changed() {
+			if err := c.reload(); err != nil {
+				log.Print("Keeping previous TLS certificate: ", err)
+			} else {
+				log.Print("Reloaded TLS certificate")
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// GetCertificate returns the current pair, for use in tls.Config.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// Config returns a server configuration serving the current pair
+// with TLS 1.2 or newer.
+func (c *Certificate) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
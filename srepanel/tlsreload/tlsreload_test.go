@@ -0,0 +1,300 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pair is a PEM encoded self-signed certificate and its key.
+type pair struct {
+	cert, key []byte
+}
+
+func newPair(t *testing.T, name string) pair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair{
+		cert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// files are the certificate and key files of a test.
+type files struct {
+	t                 *testing.T
+	certFile, keyFile string
+	version           int
+}
+
+func newFiles(t *testing.T) *files {
+	dir := t.TempDir()
+	return &files{
+		t:        t,
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+	}
+}
+
+// write replaces the files, giving them a new modification time
+// even on file systems with coarse timestamps.
+func (f *files) write(cert, key []byte) {
+	f.t.Helper()
+	f.version++
+	modTime := time.Now().Add(time.Duration(f.version) * time.Minute)
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{f.certFile, cert}, {f.keyFile, key}} {
+		if err := os.WriteFile(file.path, file.data, 0o600); err != nil {
+			f.t.Fatal(err)
+		}
+		if err := os.Chtimes(file.path, modTime, modTime); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// served returns the common name of the certificate in use.
+func served(t *testing.T, c *Certificate) string {
+	t.Helper()
+	cert, err := c.GetCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("GetCertificate() = %v, %v", cert, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestLoad(t *testing.T) {
+	a, b := newPair(t, "a.example"), newPair(t, "b.example")
+	tests := []struct {
+		name      string
+		cert, key []byte
+		missing   string // file not written
+		ok        bool
+	}{
+		{name: "valid", cert: a.cert, key: a.key, ok: true},
+		{name: "no certificate", cert: a.cert, key: a.key, missing: "cert.pem"},
+		{name: "no key", cert: a.cert, key: a.key, missing: "key.pem"},
+		{name: "key of another certificate", cert: a.cert, key: b.key},
+		{name: "not PEM", cert: []byte("garbage"), key: a.key},
+		{name: "truncated certificate", cert: a.cert[:len(a.cert)/2], key: a.key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFiles(t)
+			f.write(tt.cert, tt.key)
+			if tt.missing != "" {
+				if err := os.Remove(filepath.Join(filepath.Dir(f.certFile), tt.missing)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, err := Load(f.certFile, f.keyFile)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Load() error = %v, want success %v", err, tt.ok)
+			}
+			if tt.ok && served(t, c) != "a.example" {
+				t.Errorf("serving %q, want a.example", served(t, c))
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	a, b, c := newPair(t, "a.example"), newPair(t, "b.example"), newPair(t, "c.example")
+	tests := []struct {
+		name      string
+		cert, key []byte
+		remove    bool
+		ok        bool
+		want      string // certificate served afterwards
+	}{
+		{name: "renewed", cert: b.cert, key: b.key, ok: true, want: "b.example"},
+		{name: "unchanged", cert: b.cert, key: b.key, ok: true, want: "b.example"},
+		{name: "key not yet renewed", cert: c.cert, key: b.key, want: "b.example"},
+		{name: "broken", cert: []byte("garbage"), key: c.key, want: "b.example"},
+		{name: "removed", remove: true, want: "b.example"},
+		{name: "fixed", cert: c.cert, key: c.key, ok: true, want: "c.example"},
+	}
+	f := newFiles(t)
+	f.write(a.cert, a.key)
+	cert, err := Load(f.certFile, f.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if tt.remove {
+			if err := os.Remove(f.certFile); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			f.write(tt.cert, tt.key)
+		}
+		err := cert.Reload()
+		if (err == nil) != tt.ok {
+			t.Fatalf("%s: Reload() error = %v, want success %v", tt.name, err, tt.ok)
+		}
+		if got := served(t, cert); got != tt.want {
+			t.Errorf("%s: serving %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	a, b, c := newPair(t, "a.example"), newPair(t, "b.example"), newPair(t, "c.example")
+	f := newFiles(t)
+	f.write(a.cert, a.key)
+	cert, err := Load(f.certFile, f.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- cert.Watch(ctx, time.Millisecond) }()
+
+	// seen waits until Watch attempted to load the current files.
+	seen := func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			cert.mu.Lock()
+			changed := cert.changed()
+			cert.mu.Unlock()
+			if !changed {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("Watch did not notice the changed files")
+	}
+
+	steps := []struct {
+		cert, key []byte
+		want      string
+	}{
+		{b.cert, b.key, "b.example"},
+		{[]byte("garbage"), b.key, "b.example"},
+		{c.cert, c.key, "c.example"},
+	}
+	for i, step := range steps {
+		f.write(step.cert, step.key)
+		seen()
+		if got := served(t, cert); got != step.want {
+			t.Errorf("step %d: serving %q, want %q", i, got, step.want)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Watch() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after cancellation")
+	}
+}
+
+// Connections after a reload get the new certificate from the same listener.
+func TestConfig(t *testing.T) {
+	a, b := newPair(t, "a.example"), newPair(t, "b.example")
+	f := newFiles(t)
+	f.write(a.cert, a.key)
+	cert, err := Load(f.certFile, f.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cert.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	dial := func(maxVersion uint16) (string, error) {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         maxVersion,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	tests := []struct {
+		name       string
+		reload     []byte // certificate to reload first, with b's key
+		maxVersion uint16
+		want       string
+	}{
+		{name: "initial", want: "a.example"},
+		{name: "TLS 1.2", maxVersion: tls.VersionTLS12, want: "a.example"},
+		{name: "TLS 1.1", maxVersion: tls.VersionTLS11},
+		{name: "reloaded", reload: b.cert, want: "b.example"},
+	}
+	for _, tt := range tests {
+		if tt.reload != nil {
+			f.write(tt.reload, b.key)
+			if err := cert.Reload(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := dial(tt.maxVersion)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: handshake succeeded", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: handshake failed: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: served %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
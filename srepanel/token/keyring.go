@@ -0,0 +1,139 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// KeySize is the size of an HMAC key in bytes.
+const KeySize = 32
+
+// Key is an HMAC key used to sign panel tokens.
+type Key struct {
+	ID        string     `json:"id"`
+	Secret    []byte     `json:"secret"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"` // no longer verifies tokens after this time
+}
+
+// NewKey generates a random key.
+func NewKey() Key {
+	var id [6]byte
+	secret := make([]byte, KeySize)
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("crypto rand read failed: " + err.Error())
+	}
+	if _, err := rand.Read(secret); err != nil {
+		panic("crypto rand read failed: " + err.Error())
+	}
+	return Key{
+		ID:        base64.RawURLEncoding.EncodeToString(id[:]),
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// retired returns whether the key may no longer verify tokens.
+func (k *Key) retired(now time.Time) bool {
+	return k.RetiredAt != nil && !now.Before(*k.RetiredAt)
+}
+
+// Keyring holds the keys accepted for panel tokens.
+type Keyring []Key
+
+// Validate checks that the keyring is usable.
+func (r Keyring) Validate() error {
+	if len(r) == 0 {
+		return errors.New("keyring is empty")
+	}
+	ids := make(map[string]bool)
+	for _, key := range r {
+		if key.ID == "" {
+			return errors.New("key without ID")
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		ids[key.ID] = true
+		if len(key.Secret) != KeySize {
+			return fmt.Errorf("key %q has %d bytes, expected %d", key.ID, len(key.Secret), KeySize)
+		}
+	}
+	if r.Signing() == nil {
+		return errors.New("keyring has no active key")
+	}
+	return nil
+}
+
+// Signing returns the newest key not scheduled for retirement, or nil.
+func (r Keyring) Signing() *Key {
+	var newest *Key
+	for i := range r {
+		key := &r[i]
+		if key.RetiredAt != nil {
+			continue
+		}
+		if newest == nil || key.CreatedAt.After(newest.CreatedAt) {
+			newest = key
+		}
+	}
+	return newest
+}
+
+// Lookup returns the key with the given ID if it may verify tokens.
+func (r Keyring) Lookup(id string) *Key {
+	now := time.Now()
+	for i := range r {
+		if r[i].ID == id && !r[i].retired(now) {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+// Derive returns keys for another purpose derived from the keys
+// that may verify tokens, starting with the one of the signing key.
+func (r Keyring) Derive(purpose string) [][]byte {
+	now := time.Now()
+	signing := r.Signing()
+	var derived [][]byte
+	for i := range r {
+		key := &r[i]
+		if key.retired(now) {
+			continue
+		}
+		mac := hmac.New(sha256.New, key.Secret)
+		_, _ = mac.Write([]byte(purpose))
+		if key == signing {
+			derived = append([][]byte{mac.Sum(nil)}, derived...)
+		} else {
+			derived = append(derived, mac.Sum(nil))
+		}
+	}
+	return derived
+}
+
+// Rotate adds a new signing key. Previously active keys keep verifying
+// tokens for retireAfter, which should be at least the session lifetime.
+// Keys retired earlier are dropped.
+func (r Keyring) Rotate(retireAfter time.Duration) Keyring {
+	now := time.Now().UTC()
+	retireAt := now.Add(retireAfter)
+
+	rotated := make(Keyring, 0, len(r)+1)
+	for _, key := range r {
+		if key.retired(now) {
+			continue
+		}
+		if key.RetiredAt == nil {
+			key.RetiredAt = &retireAt
+		}
+		rotated = append(rotated, key)
+	}
+	return append(rotated, NewKey())
+}
@@ -0,0 +1,166 @@
+package token
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+func TestKeyringValidate(t *testing.T) {
+	key := NewKey()
+	retired := NewKey()
+	past := time.Now().Add(-time.Hour)
+	retired.RetiredAt = &past
+	short := NewKey()
+	short.Secret = short.Secret[:16]
+
+	tests := []struct {
+		name string
+		keys Keyring
+		err  string
+	}{
+		{"valid", Keyring{key}, ""},
+		{"with retired key", Keyring{retired, key}, ""},
+		{"empty", nil, "empty"},
+		{"only retired keys", Keyring{retired}, "no active key"},
+		{"duplicate ID", Keyring{key, key}, "duplicate key ID"},
+		{"missing ID", Keyring{{Secret: key.Secret}}, "without ID"},
+		{"short secret", Keyring{short}, "has 16 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.keys.Validate()
+			if tt.err == "" && err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("Validate() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	ident := &common.Identity{ID: 1, Username: "bob"}
+	issuer := NewIssuer(Keyring{NewKey()}, "https://panel.example", time.Hour, time.Hour)
+	oldJWT, _ := issuer.Issue(ident)
+	oldKid := issuer.Keys.Signing().ID
+
+	// Rotate with a grace period, then without
+	tests := []struct {
+		name        string
+		retireAfter time.Duration
+		keys        int
+		oldValid    bool
+	}{
+		{"grace period", time.Hour, 2, true},
+		{"immediate retirement", 0, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotated := issuer
+			rotated.Keys = issuer.Keys.Rotate(tt.retireAfter)
+			if err := rotated.Keys.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if len(rotated.Keys) != tt.keys {
+				t.Errorf("%d keys after rotation, want %d", len(rotated.Keys), tt.keys)
+			}
+			signing := rotated.Keys.Signing()
+			if signing.ID == oldKid {
+				t.Fatal("rotation kept signing key")
+			}
+			if issuer.Keys[0].RetiredAt != nil {
+				t.Fatal("rotation modified the original keyring")
+			}
+
+			// New tokens are signed with the new key
+			newJWT, _ := rotated.Issue(ident)
+			if _, ok := rotated.Verify(newJWT); !ok {
+				t.Error("new token does not verify")
+			}
+			if _, ok := issuer.Verify(newJWT); ok {
+				t.Error("new token verifies with the old keyring")
+			}
+			if _, ok := rotated.Verify(oldJWT); ok != tt.oldValid {
+				t.Errorf("old token valid = %v, want %v", ok, tt.oldValid)
+			}
+
+			// Keys retired earlier are dropped on the next rotation
+			again := rotated.Keys.Rotate(time.Hour)
+			if tt.oldValid && again.Lookup(oldKid) == nil {
+				t.Error("key in grace period dropped")
+			}
+			if !tt.oldValid && len(again) != 2 {
+				t.Errorf("%d keys after second rotation, want 2", len(again))
+			}
+		})
+	}
+}
+
+func TestKeyringKid(t *testing.T) {
+	old := NewKey()
+	issuer := NewIssuer(Keyring{old}.Rotate(time.Hour), "https://panel.example", time.Hour, time.Hour)
+	signing := issuer.Keys.Signing()
+	_, claims := issuer.Issue(&common.Identity{ID: 1})
+	retired := NewKey()
+	past := time.Now().Add(-time.Minute)
+	retired.RetiredAt = &past
+	issuer.Keys = append(issuer.Keys, retired)
+
+	tests := []struct {
+		name  string
+		kid   string
+		key   *Key
+		valid bool
+	}{
+		{"signing key", signing.ID, signing, true},
+		{"key in grace period", old.ID, &old, true},
+		{"kid of another key", signing.ID, &old, false},
+		{"retired key", retired.ID, &retired, false},
+		{"unknown kid", "unknown", signing, false},
+		{"no kid", "", signing, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := encodeHeader(t, &header{Alg: "HS256", Kid: tt.kid, Typ: "JWT"}) + "." + claims.String()
+			jwt := body + "." + signPayload(tt.key, body)
+			if _, ok := issuer.Verify(jwt); ok != tt.valid {
+				t.Errorf("Verify() = %v, want %v", ok, tt.valid)
+			}
+		})
+	}
+}
+
+func TestKeyringDerive(t *testing.T) {
+	old := NewKey()
+	keys := Keyring{old}.Rotate(time.Hour)
+
+	derived := keys.Derive("csrf")
+	if len(derived) != 2 {
+		t.Fatalf("%d derived keys, want 2", len(derived))
+	}
+	if !bytes.Equal(derived[0], Keyring{*keys.Signing()}.Derive("csrf")[0]) {
+		t.Error("first derived key not derived from the signing key")
+	}
+	if !bytes.Equal(derived[1], Keyring{old}.Derive("csrf")[0]) {
+		t.Error("second derived key not derived from the old key")
+	}
+	if bytes.Equal(derived[0], keys.Derive("oauth-state")[0]) {
+		t.Error("derived keys do not depend on the purpose")
+	}
+}
+
+func encodeHeader(t *testing.T, hdr *header) string {
+	t.Helper()
+	buf, err := json.Marshal(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
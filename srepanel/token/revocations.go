@@ -0,0 +1,46 @@
+package token
+
+import (
+	"sync"
+	"time"
+)
+
+// Revocations caches the IDs of revoked sessions in memory,
+// so verifying a token does not require a database lookup.
+type Revocations struct {
+	lock sync.RWMutex
+	ids  map[string]time.Time // session ID => token expiry
+}
+
+func NewRevocations() *Revocations {
+	return &Revocations{ids: make(map[string]time.Time)}
+}
+
+// Add marks a session as revoked until its token expires.
+func (r *Revocations) Add(id string, expiry time.Time) {
+	if time.Now().After(expiry) {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.ids[id] = expiry
+	r.prune()
+}
+
+// Contains returns whether a session was revoked.
+func (r *Revocations) Contains(id string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	_, ok := r.ids[id]
+	return ok
+}
+
+// prune forgets sessions whose tokens expired anyway.
+func (r *Revocations) prune() {
+	now := time.Now()
+	for id, expiry := range r.ids {
+		if now.After(expiry) {
+			delete(r.ids, id)
+		}
+	}
+}
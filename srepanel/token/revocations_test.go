@@ -0,0 +1,39 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevocations(t *testing.T) {
+	r := NewRevocations()
+	now := time.Now()
+	r.Add("active", now.Add(time.Hour))
+	r.Add("expired", now.Add(-time.Second))
+	r.Add("expiring", now.Add(10*time.Millisecond))
+
+	tests := []struct {
+		id      string
+		revoked bool
+	}{
+		{"active", true},
+		{"expiring", true},
+		{"expired", false}, // its token is rejected anyway
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		if got := r.Contains(tt.id); got != tt.revoked {
+			t.Errorf("Contains(%q) = %v, want %v", tt.id, got, tt.revoked)
+		}
+	}
+
+	// Adding prunes sessions whose tokens expired
+	time.Sleep(20 * time.Millisecond)
+	r.Add("other", now.Add(time.Hour))
+	if r.Contains("expiring") {
+		t.Error("expired revocation not pruned")
+	}
+	if !r.Contains("active") || !r.Contains("other") {
+		t.Error("active revocations pruned")
+	}
+}
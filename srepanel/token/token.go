@@ -2,6 +2,7 @@ package token
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
@@ -14,24 +15,73 @@ import (
 
 // TODO Integrate BitRing for token expiry
 
-// jwtPrefix is the JWT header of type HS256
-const jwtPrefix = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9."
+const (
+	// DefaultLifetime is how long a session lasts at most after login.
+	DefaultLifetime = 90 * 24 * time.Hour
+	// DefaultIdleTimeout is how long a session lasts without activity.
+	DefaultIdleTimeout = 7 * 24 * time.Hour
+)
 
-const jwtValidity = 90 * 24 * time.Hour
+// clockSkew is the tolerance applied to token timestamps.
+const clockSkew = time.Minute
 
 type Issuer struct {
-	Secret *[32]byte
+	Keys    Keyring
+	Revoked *Revocations
+
+	// Origin identifies the panel deployment, e.g. its base URL.
+	// Used as issuer and audience, so tokens do not verify on other deployments.
+	Origin      string
+	Lifetime    time.Duration
+	IdleTimeout time.Duration
 }
 
-func NewIssuer(secret *[32]byte) Issuer {
-	return Issuer{secret}
+func NewIssuer(keys Keyring, origin string, lifetime, idleTimeout time.Duration) Issuer {
+	return Issuer{
+		Keys:        keys,
+		Revoked:     NewRevocations(),
+		Origin:      origin,
+		Lifetime:    lifetime,
+		IdleTimeout: idleTimeout,
+	}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
 }
 
 type Claims struct {
+	Iss        string `json:"iss"`
+	Aud        string `json:"aud"`
 	Sub        uint64 `json:"sub,string"`
 	Name       string `json:"name"`
+	GlobalName string `json:"global_name,omitempty"`
 	AvatarHash string `json:"avatar"`
+	Picture    string `json:"picture,omitempty"`
 	Iat        int64  `json:"iat"`
+	Nbf        int64  `json:"nbf"`
+	Exp        int64  `json:"exp"`
+	AuthTime   int64  `json:"auth_time"`         // login time, bounds session lifetime
+	StepUp     int64  `json:"step_up,omitempty"` // last second factor verification
+	Jti        string `json:"jti"`               // session ID
+}
+
+// Identity reconstructs the identity the claims were issued for.
+func (c *Claims) Identity() *common.Identity {
+	return &common.Identity{
+		ID:         c.Sub,
+		Username:   c.Name,
+		GlobalName: c.GlobalName,
+		AvatarHash: c.AvatarHash,
+		AvatarURL:  c.Picture,
+	}
+}
+
+// Deadline returns when the session of the token ends, regardless of activity.
+func (iss Issuer) Deadline(c *Claims) time.Time {
+	return time.Unix(c.AuthTime, 0).Add(iss.Lifetime)
 }
 
 func (c *Claims) String() string {
@@ -42,50 +92,131 @@ func (c *Claims) String() string {
 	return base64.RawURLEncoding.EncodeToString(buf)
 }
 
-func (iss Issuer) Issue(ident *common.Identity) string {
-	claims := &Claims{
+// Issue creates a token for a new session of the given identity.
+func (iss Issuer) Issue(ident *common.Identity) (jwt string, claims *Claims) {
+	claims = &Claims{
 		Sub:        ident.ID,
 		Name:       ident.Username,
+		GlobalName: ident.GlobalName,
 		AvatarHash: ident.AvatarHash,
-		Iat:        time.Now().Unix(),
+		Picture:    ident.AvatarURL,
+		AuthTime:   time.Now().Unix(),
+		Jti:        newSessionID(),
+	}
+	return iss.sign(claims), claims
+}
+
+// NeedsRenewal returns whether a token passed half of its idle window
+// and should be replaced using Renew.
+func (iss Issuer) NeedsRenewal(claims *Claims) bool {
+	renewAt := time.Unix(claims.Iat, 0).Add(iss.IdleTimeout / 2)
+	return time.Now().After(renewAt) && time.Unix(claims.Exp, 0).Before(iss.Deadline(claims))
+}
+
+// Renew re-issues a token of the same session with a fresh idle window.
+func (iss Issuer) Renew(claims *Claims) (jwt string, renewed *Claims) {
+	renewed = new(Claims)
+	*renewed = *claims
+	return iss.sign(renewed), renewed
+}
+
+// StepUp re-issues a token of the same session, recording that the user
+// just verified a second factor.
+func (iss Issuer) StepUp(claims *Claims) (jwt string, renewed *Claims) {
+	renewed = new(Claims)
+	*renewed = *claims
+	renewed.StepUp = time.Now().Unix()
+	return iss.sign(renewed), renewed
+}
+
+// SteppedUp returns whether the user verified a second factor within maxAge.
+func (c *Claims) SteppedUp(maxAge time.Duration) bool {
+	return c.StepUp != 0 && time.Since(time.Unix(c.StepUp, 0)) < maxAge
+}
+
+// sign sets the registered claims and signs the token with the newest key.
+func (iss Issuer) sign(claims *Claims) string {
+	now := time.Now()
+	exp := now.Add(iss.IdleTimeout)
+	if deadline := iss.Deadline(claims); exp.After(deadline) {
+		exp = deadline
+	}
+	claims.Iss = iss.Origin
+	claims.Aud = iss.Origin
+	claims.Iat = now.Unix()
+	claims.Nbf = now.Unix()
+	claims.Exp = exp.Unix()
+
+	key := iss.Keys.Signing()
+	headerBuf, _ := json.Marshal(&header{Alg: "HS256", Kid: key.ID, Typ: "JWT"})
+	body := base64.RawURLEncoding.EncodeToString(headerBuf) + "." + claims.String()
+	return body + "." + signPayload(key, body)
+}
+
+func newSessionID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic("crypto rand read failed: " + err.Error())
 	}
-	body := jwtPrefix + claims.String()
-	return body + "." + iss.sign(body)
+	return base64.RawURLEncoding.EncodeToString(id[:])
 }
 
-func (iss Issuer) sign(payload string) string {
+func signPayload(key *Key, payload string) string {
 	var sig [32]byte
-	mac := hmac.New(sha256.New, iss.Secret[:])
+	mac := hmac.New(sha256.New, key.Secret)
 	_, _ = mac.Write([]byte(payload))
 	mac.Sum(sig[:0])
 
 	return base64.RawURLEncoding.EncodeToString(sig[:])
 }
 
+// Verify checks a token and returns the identity it was issued for.
 func (iss Issuer) Verify(jwt string) (ident *common.Identity, ok bool) {
-	// Laziness
-	if !strings.HasPrefix(jwt, jwtPrefix) {
+	claims, ok := iss.VerifyClaims(jwt)
+	if !ok {
 		return nil, false
 	}
+	return claims.Identity(), true
+}
 
-	// Find signature
-	sigSep := strings.LastIndex(jwt, ".")
-	if sigSep == -1 {
+// VerifyClaims checks a token and returns its claims.
+// Rejects expired tokens and tokens of revoked sessions.
+func (iss Issuer) VerifyClaims(jwt string) (claims *Claims, ok bool) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+
+	// Decode header
+	headerBuf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+	var hdr header
+	if err := json.Unmarshal(headerBuf, &hdr); err != nil {
+		return nil, false
+	}
+	if hdr.Alg != "HS256" || hdr.Typ != "JWT" {
+		return nil, false
+	}
+	key := iss.Keys.Lookup(hdr.Kid)
+	if key == nil {
 		return nil, false
 	}
-	sigB64 := jwt[sigSep+1:]
 
 	// Decode signature
 	var sig [32]byte
-	_, err := base64.RawURLEncoding.Decode(sig[:], []byte(sigB64))
-	if err != nil {
+	if len(parts[2]) != base64.RawURLEncoding.EncodedLen(len(sig)) {
+		return nil, false
+	}
+	if _, err := base64.RawURLEncoding.Decode(sig[:], []byte(parts[2])); err != nil {
 		return nil, false
 	}
 
 	// Verify signature
 	var sig2 [32]byte
-	mac := hmac.New(sha256.New, iss.Secret[:])
-	_, _ = mac.Write([]byte(jwt[:sigSep]))
+	mac := hmac.New(sha256.New, key.Secret)
+	_, _ = mac.Write([]byte(parts[0] + "." + parts[1]))
 	mac.Sum(sig2[:0])
 	macValid := subtle.ConstantTimeCompare(sig[:], sig2[:]) == 1
 	if !macValid {
@@ -93,25 +224,34 @@ func (iss Issuer) Verify(jwt string) (ident *common.Identity, ok bool) {
 	}
 
 	// Decode claims
-	claimsB64 := jwt[len(jwtPrefix):sigSep]
-	claimsBuf, err := base64.RawURLEncoding.DecodeString(claimsB64)
+	claimsBuf, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
 		return nil, false
 	}
-	var claims Claims
-	if err = json.Unmarshal(claimsBuf, &claims); err != nil {
+	claims = new(Claims)
+	if err = json.Unmarshal(claimsBuf, claims); err != nil {
+		return nil, false
+	}
+
+	// Check registered claims
+	now := time.Now()
+	if claims.Iss != iss.Origin || claims.Aud != iss.Origin {
+		return nil, false
+	}
+	if claims.Exp == 0 || !now.Before(time.Unix(claims.Exp, 0)) {
+		return nil, false
+	}
+	if now.Add(clockSkew).Before(time.Unix(claims.Nbf, 0)) {
+		return nil, false
+	}
+	if !now.Before(iss.Deadline(claims)) {
 		return nil, false
 	}
 
-	// Check expiry
-	if time.Now().Unix()-claims.Iat > int64(jwtValidity)/int64(time.Second) {
+	// Check revocation, tokens predating sessions cannot be revoked
+	if claims.Jti == "" || iss.Revoked.Contains(claims.Jti) {
 		return nil, false
 	}
 
-	// Reconstruct identity
-	return &common.Identity{
-		ID:         claims.Sub,
-		Username:   claims.Name,
-		AvatarHash: claims.AvatarHash,
-	}, true
+	return claims, true
 }
@@ -0,0 +1,146 @@
+package token
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+func TestVerifyClaims(t *testing.T) {
+	issuer := NewIssuer(Keyring{NewKey()}, "https://panel.example", 24*time.Hour, time.Hour)
+	ident := &common.Identity{ID: 1, Username: "bob", GlobalName: "Bob", AvatarHash: "abc"}
+	now := time.Now()
+
+	// token signs claims as issued by the issuer, after modifying them
+	token := func(modify func(c *Claims)) string {
+		_, claims := issuer.Issue(ident)
+		jwt := issuer.sign(claims)
+		if modify == nil {
+			return jwt
+		}
+		modify(claims)
+		body := jwt[:strings.Index(jwt, ".")] + "." + claims.String()
+		return body + "." + signPayload(issuer.Keys.Signing(), body)
+	}
+	revoked := token(nil)
+	revokedClaims, _ := issuer.VerifyClaims(revoked)
+	issuer.Revoked.Add(revokedClaims.Jti, now.Add(time.Hour))
+
+	tests := []struct {
+		name  string
+		jwt   string
+		valid bool
+	}{
+		{"valid", token(nil), true},
+		{"idle timeout", token(func(c *Claims) { c.Exp = now.Add(-time.Second).Unix() }), false},
+		{"no expiry", token(func(c *Claims) { c.Exp = 0 }), false},
+		{"not yet valid", token(func(c *Claims) { c.Nbf = now.Add(2 * clockSkew).Unix() }), false},
+		{"within clock skew", token(func(c *Claims) { c.Nbf = now.Add(clockSkew / 2).Unix() }), true},
+		{"lifetime exceeded", token(func(c *Claims) { c.AuthTime = now.Add(-25 * time.Hour).Unix() }), false},
+		{"other issuer", token(func(c *Claims) { c.Iss = "https://other.example" }), false},
+		{"other audience", token(func(c *Claims) { c.Aud = "https://other.example" }), false},
+		{"no session ID", token(func(c *Claims) { c.Jti = "" }), false},
+		{"revoked", revoked, false},
+		{"tampered", token(nil) + "x", false},
+		{"unsigned", strings.TrimRight(token(nil), "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"), false},
+		{"malformed", "a.b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, ok := issuer.VerifyClaims(tt.jwt)
+			if ok != tt.valid {
+				t.Fatalf("VerifyClaims() = %v, want %v", ok, tt.valid)
+			}
+			if ok && (claims.Sub != 1 || claims.Identity().Username != "bob" || claims.Identity().GlobalName != "Bob") {
+				t.Errorf("claims %+v do not match the identity", claims)
+			}
+		})
+	}
+}
+
+func TestIssueClaims(t *testing.T) {
+	issuer := NewIssuer(Keyring{NewKey()}, "https://panel.example", time.Hour, 2*time.Hour)
+	_, claims := issuer.Issue(&common.Identity{ID: 1})
+	if claims.Iss != issuer.Origin || claims.Aud != issuer.Origin {
+		t.Errorf("issuer %q, audience %q, want %q", claims.Iss, claims.Aud, issuer.Origin)
+	}
+	if claims.Iat == 0 || claims.Nbf != claims.Iat || claims.AuthTime != claims.Iat {
+		t.Errorf("iat %d, nbf %d, auth_time %d", claims.Iat, claims.Nbf, claims.AuthTime)
+	}
+	// The idle window is cut short by the session lifetime
+	if exp := time.Unix(claims.Exp, 0); !exp.Equal(issuer.Deadline(claims)) {
+		t.Errorf("expiry %s, want deadline %s", exp, issuer.Deadline(claims))
+	}
+	if _, other := issuer.Issue(&common.Identity{ID: 1}); other.Jti == claims.Jti {
+		t.Error("sessions share an ID")
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	issuer := NewIssuer(Keyring{NewKey()}, "https://panel.example", 24*time.Hour, time.Hour)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		iat      time.Time
+		authTime time.Time
+		renew    bool
+	}{
+		{"fresh", now, now, false},
+		{"before half of idle window", now.Add(-20 * time.Minute), now.Add(-20 * time.Minute), false},
+		{"past half of idle window", now.Add(-40 * time.Minute), now.Add(-40 * time.Minute), true},
+		{"capped by lifetime", now.Add(-40 * time.Minute), now.Add(-24*time.Hour + 10*time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, claims := issuer.Issue(&common.Identity{ID: 1})
+			claims.AuthTime = tt.authTime.Unix()
+			issuer.sign(claims)
+			claims.Iat = tt.iat.Unix()
+			if got := issuer.NeedsRenewal(claims); got != tt.renew {
+				t.Fatalf("NeedsRenewal() = %v, want %v", got, tt.renew)
+			}
+			if !tt.renew {
+				return
+			}
+			jwt, renewed := issuer.Renew(claims)
+			if renewed.Jti != claims.Jti || renewed.AuthTime != claims.AuthTime {
+				t.Error("renewal started a new session")
+			}
+			if renewed.Iat <= claims.Iat || renewed.Exp < claims.Exp {
+				t.Errorf("renewal did not extend the idle window: %+v", renewed)
+			}
+			if _, ok := issuer.VerifyClaims(jwt); !ok {
+				t.Error("renewed token does not verify")
+			}
+		})
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	issuer := NewIssuer(Keyring{NewKey()}, "https://panel.example", time.Hour, time.Hour)
+	_, claims := issuer.Issue(&common.Identity{ID: 1})
+	if claims.SteppedUp(time.Hour) {
+		t.Fatal("new session stepped up")
+	}
+	jwt, stepped := issuer.StepUp(claims)
+	verified, ok := issuer.VerifyClaims(jwt)
+	if !ok || verified.Jti != claims.Jti {
+		t.Fatal("stepped up token does not verify for the same session")
+	}
+
+	tests := []struct {
+		maxAge time.Duration
+		want   bool
+	}{
+		{time.Minute, true},
+		{0, false},
+	}
+	for _, tt := range tests {
+		if got := stepped.SteppedUp(tt.maxAge); got != tt.want {
+			t.Errorf("SteppedUp(%s) = %v, want %v", tt.maxAge, got, tt.want)
+		}
+	}
+}
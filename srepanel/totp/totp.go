@@ -0,0 +1,92 @@
+// Package totp implements time-based one-time passwords (RFC 6238)
+// as used by common authenticator apps: HMAC-SHA1, six digits, 30 seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Digits is the length of codes.
+	Digits = 6
+	// Period is how long a code is valid.
+	Period = 30 * time.Second
+	// SecretSize is the size of generated secrets in bytes.
+	SecretSize = 20
+)
+
+// skewSteps is how many time steps before and after the current one are
+// accepted, to tolerate clock drift and slow typing.
+const skewSteps = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random secret.
+func NewSecret() []byte {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		panic("crypto rand read failed: " + err.Error())
+	}
+	return secret
+}
+
+// EncodeSecret returns the base32 form of a secret for manual entry.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Step returns the time step of t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of a time step.
+func Code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	_, _ = mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226, section 5.3)
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, bin%1_000_000)
+}
+
+// Validate checks a code at time t and returns the time step it matched.
+// Callers must reject steps at or before the last step accepted for the
+// same secret, so codes cannot be replayed.
+func Validate(secret []byte, code string, t time.Time) (step int64, ok bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for s := now - skewSteps; s <= now+skewSteps; s++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, s)), []byte(code)) == 1 {
+			step, ok = s, true
+		}
+	}
+	return step, ok
+}
+
+// URI returns the otpauth URI authenticator apps enroll from, usually as QR code.
+func URI(issuer, account string, secret []byte) string {
+	query := url.Values{
+		"secret":    {EncodeSecret(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period / time.Second))},
+	}
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,109 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 4226 and RFC 6238 test vectors.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// RFC 6238, appendix B, truncated to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := Code(rfcSecret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	// RFC 4226, appendix D
+	hotp := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, want := range hotp {
+		if got := Code(rfcSecret, int64(counter)); got != want {
+			t.Errorf("Code() of counter %d = %s, want %s", counter, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	tests := []struct {
+		name string
+		code string
+		ok   bool
+		step int64
+	}{
+		{"current", Code(rfcSecret, step), true, step},
+		{"previous", Code(rfcSecret, step-1), true, step - 1},
+		{"next", Code(rfcSecret, step+1), true, step + 1},
+		{"too old", Code(rfcSecret, step-2), false, 0},
+		{"too new", Code(rfcSecret, step+2), false, 0},
+		{"other secret", Code([]byte("other secret"), step), false, 0},
+		{"eight digits", "14050471", false, 0},
+		{"short", Code(rfcSecret, step)[:5], false, 0},
+		{"empty", "", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.ok || got != tt.step {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, got, ok, tt.step, tt.ok)
+			}
+		})
+	}
+
+	// The window moves with the step, not with the time within it
+	if _, ok := Validate(rfcSecret, Code(rfcSecret, step-1), now.Add(Period)); ok {
+		t.Error("code of two steps ago accepted")
+	}
+}
+
+func TestSecret(t *testing.T) {
+	secret := NewSecret()
+	if len(secret) != SecretSize {
+		t.Fatalf("secret of %d bytes, want %d", len(secret), SecretSize)
+	}
+	decoded, err := encoding.DecodeString(EncodeSecret(secret))
+	if err != nil || string(decoded) != string(secret) {
+		t.Errorf("secret does not round-trip: %v", err)
+	}
+	if EncodeSecret(rfcSecret) != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("EncodeSecret() = %s", EncodeSecret(rfcSecret))
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Ghidra Panel", "bob", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Ghidra Panel:bob" {
+		t.Errorf("URI %s", uri)
+	}
+	query := uri.Query()
+	want := map[string]string{
+		"secret":    "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"issuer":    "Ghidra Panel",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	for param, value := range want {
+		if got := query.Get(param); got != value {
+			t.Errorf("%s = %q, want %q", param, got, value)
+		}
+	}
+}
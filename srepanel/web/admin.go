@@ -0,0 +1,229 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/ghidra"
+)
+
+// checkAdmin returns the identity of the request if it belongs to an admin
+// who recently verified their second factor. Otherwise writes a response.
+func (s *Server) checkAdmin(wr http.ResponseWriter, req *http.Request) (*common.Identity, bool) {
+	claims, ok := s.checkSession(req)
+	if !ok {
+		http.Error(wr, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	ctx := req.Context()
+	isAdmin, err := s.DB.HasRole(ctx, claims.Sub, database.RoleAdmin)
+	if err != nil {
+		log.Print("Failed to check role: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !isAdmin {
+		http.Error(wr, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	enrolled, err := s.DB.HasTOTP(ctx, claims.Sub)
+	if err != nil {
+		log.Print("Failed to check TOTP: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !enrolled || !claims.SteppedUp(stepUpValidity) {
+		redirectStepUp(wr, req, enrolled, "/admin")
+		return nil, false
+	}
+	return claims.Identity(), true
+}
+
+func (s *Server) handleAdmin(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := s.stateWithNav(
+		Nav{Route: "/", Name: "Ghidra"},
+		Nav{Route: "/admin", Name: "Admin"},
+	)
+	if !s.authenticateState(wr, req, state) {
+		return
+	}
+	if !state.UserState.IsAdmin {
+		http.Error(wr, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !state.UserState.TOTPEnabled || !state.claims.SteppedUp(stepUpValidity) {
+		redirectStepUp(wr, req, state.UserState.TOTPEnabled, "/admin")
+		return
+	}
+	state.Notice = homeNotice(req)
+	state.CanRotate = s.Config().RotateSecrets != nil
+	if acls := s.ACLs.Get(); acls != nil {
+		for repo := range acls.ACLs {
+			state.Repos = append(state.Repos, repo)
+		}
+		sort.Strings(state.Repos)
+	}
+
+	if err := adminPage.Execute(wr, state); err != nil {
+		log.Print("failed to serve admin: ", err)
+	}
+}
+
+func (s *Server) handleAdminRevokeSessions(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin, ok := s.checkAdmin(wr, req)
+	if !ok {
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseUint(req.PostForm.Get("user_id"), 10, 64)
+	if err != nil {
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.revokeSessions(req.Context(), userID, nil, ""); err != nil {
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.DB.Audit(req.Context(), admin.ID, "sessions.revoke_all", fmt.Sprintf("user=%d", userID)); err != nil {
+		log.Print("Failed to write audit log: ", err)
+	}
+	http.Redirect(wr, req, "/admin?sessions=revoked", http.StatusSeeOther)
+}
+
+func (s *Server) handleAdminACL(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin, ok := s.checkAdmin(wr, req)
+	if !ok {
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+	perm := ghidra.PermNone
+	if p := req.PostForm.Get("perm"); p != "NONE" {
+		if perm, ok = ghidra.ParsePerm(p); !ok {
+			http.Error(wr, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	change, err := s.ACLs.SetUserPerm(req.PostForm.Get("repo"), req.PostForm.Get("user"), perm)
+	switch {
+	case errors.Is(err, ghidra.ErrUnknownRepo), errors.Is(err, ghidra.ErrInvalidUser):
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Print("Failed to update ACL: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if change.Old != change.New {
+		if err := s.DB.Audit(req.Context(), admin.ID, "acl.set", change.String()); err != nil {
+			log.Print("Failed to write audit log: ", err)
+		}
+	}
+	http.Redirect(wr, req, "/admin?acl=updated", http.StatusSeeOther)
+}
+
+func (s *Server) handleAdminRoles(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin, ok := s.checkAdmin(wr, req)
+	if !ok {
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+	userID, err := strconv.ParseUint(req.PostForm.Get("user_id"), 10, 64)
+	if err != nil {
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+	var grant bool
+	switch req.PostForm.Get("action") {
+	case "grant":
+		grant = true
+	case "revoke":
+		if userID == admin.ID {
+			http.Error(wr, "You cannot revoke your own admin role", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DB.SetRole(req.Context(), userID, database.RoleAdmin, grant); err != nil {
+		log.Print("Failed to set role: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	action := "role.revoke"
+	if grant {
+		action = "role.grant"
+	}
+	if err := s.DB.Audit(req.Context(), admin.ID, action, fmt.Sprintf("user=%d role=%s", userID, database.RoleAdmin)); err != nil {
+		log.Print("Failed to write audit log: ", err)
+	}
+	http.Redirect(wr, req, "/admin?role=updated", http.StatusSeeOther)
+}
+
+func (s *Server) handleAdminRotateSecrets(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Config().RotateSecrets == nil {
+		http.NotFound(wr, req)
+		return
+	}
+
+	admin, ok := s.checkAdmin(wr, req)
+	if !ok {
+		return
+	}
+
+	keyID, err := s.Config().RotateSecrets()
+	if err != nil {
+		log.Print("Failed to rotate secrets: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.DB.Audit(req.Context(), admin.ID, "secrets.rotate", "key="+keyID); err != nil {
+		log.Print("Failed to write audit log: ", err)
+	}
+	http.Redirect(wr, req, "/admin?secrets=rotated", http.StatusSeeOther)
+}
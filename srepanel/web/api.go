@@ -0,0 +1,409 @@
+package web
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/ghidra"
+	"go.mkw.re/ghidra-panel/passhash"
+)
+
+// openAPIDoc documents the /api/v1 endpoints.
+//
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// maxAPIBodySize limits JSON request bodies.
+const maxAPIBodySize = 64 << 10
+
+// apiError is the body of all API error responses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(wr http.ResponseWriter, status int, v any) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(status)
+	if err := json.NewEncoder(wr).Encode(v); err != nil {
+		log.Print("Failed to write JSON response: ", err)
+	}
+}
+
+// writeJSONError writes a JSON error response.
+func writeJSONError(wr http.ResponseWriter, status int, msg string) {
+	if status == http.StatusUnauthorized {
+		wr.Header().Set("WWW-Authenticate", `Bearer realm="ghidra-panel"`)
+	}
+	writeJSON(wr, status, &apiError{Error: msg})
+}
+
+// readJSON decodes a JSON request body, rejecting unknown fields.
+func readJSON(wr http.ResponseWriter, req *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(wr, req.Body, maxAPIBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSONError(wr, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// apiAuth authenticates an API request and checks that its token grants the scope.
+// Writes an error response and returns false otherwise.
+func (s *Server) apiAuth(wr http.ResponseWriter, req *http.Request, scope string) (userID uint64, ok bool) {
+	tok, ok := s.checkAPIToken(req)
+	if !ok {
+		writeJSONError(wr, http.StatusUnauthorized, "invalid API token")
+		return 0, false
+	}
+	for _, granted := range tok.Scopes {
+		if granted == scope {
+			return tok.UserID, true
+		}
+	}
+	writeJSONError(wr, http.StatusForbidden, fmt.Sprintf("API token lacks scope %q", scope))
+	return 0, false
+}
+
+// apiAdmin authenticates an API request of an admin.
+func (s *Server) apiAdmin(wr http.ResponseWriter, req *http.Request) (userID uint64, ok bool) {
+	userID, ok = s.apiAuth(wr, req, scopeAdmin)
+	if !ok {
+		return 0, false
+	}
+	isAdmin, err := s.DB.HasRole(req.Context(), userID, database.RoleAdmin)
+	if err != nil {
+		log.Print("Failed to check role: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return 0, false
+	}
+	if !isAdmin {
+		writeJSONError(wr, http.StatusForbidden, "admin role required")
+		return 0, false
+	}
+	enrolled, err := s.DB.HasTOTP(req.Context(), userID)
+	if err != nil {
+		log.Print("Failed to check TOTP: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return 0, false
+	}
+	if !enrolled {
+		writeJSONError(wr, http.StatusForbidden, "two-factor authentication must be set up for admins")
+		return 0, false
+	}
+	return userID, true
+}
+
+// apiStepUp checks the second factor sent along with a sensitive API request.
+func (s *Server) apiStepUp(wr http.ResponseWriter, req *http.Request, userID uint64) bool {
+	code := req.Header.Get(totpHeader)
+	if code == "" {
+		writeJSONError(wr, http.StatusForbidden, "two-factor code required in "+totpHeader+" header")
+		return false
+	}
+	if !s.apiRateLimit(wr, req, LimitSecondFactor, userID) {
+		return false
+	}
+	ok, err := s.verifySecondFactor(req.Context(), userID, code)
+	if err != nil {
+		log.Print("Failed to verify second factor: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return false
+	}
+	if !ok {
+		writeJSONError(wr, http.StatusForbidden, "invalid two-factor code")
+		return false
+	}
+	return true
+}
+
+// apiIdentity returns the profile of a user, falling back to an identity without details.
+func (s *Server) apiIdentity(wr http.ResponseWriter, req *http.Request, userID uint64) (*common.Identity, bool) {
+	ident, err := s.DB.GetProfile(req.Context(), userID)
+	if err != nil {
+		log.Print("Failed to get profile: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+	if ident == nil {
+		ident = &common.Identity{ID: userID}
+	}
+	return ident, true
+}
+
+// apiUserState returns the state of a user, with the Ghidra username defaulted.
+func (s *Server) apiUserState(wr http.ResponseWriter, req *http.Request, ident *common.Identity) (*common.UserState, bool) {
+	userState, err := s.DB.GetUserState(req.Context(), ident.ID)
+	if err != nil {
+		log.Print("Failed to get user state: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+	userState.GhidraUsername, err = s.ghidraUsername(req.Context(), ident, userState.GhidraUsername)
+	if err != nil {
+		log.Print("Failed to get Ghidra username: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+	return userState, true
+}
+
+func (s *Server) handleAPIOpenAPI(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json")
+	_, _ = wr.Write(openAPIDoc)
+}
+
+func (s *Server) handleAPINotFound(wr http.ResponseWriter, req *http.Request) {
+	writeJSONError(wr, http.StatusNotFound, "not found")
+}
+
+type apiMe struct {
+	ID         uint64 `json:"id,string"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name,omitempty"`
+	AvatarURL  string `json:"avatar_url,omitempty"`
+}
+
+func (s *Server) handleAPIMe(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, ok := s.apiAuth(wr, req, scopeReadAccess)
+	if !ok {
+		return
+	}
+	ident, ok := s.apiIdentity(wr, req, userID)
+	if !ok {
+		return
+	}
+	writeJSON(wr, http.StatusOK, &apiMe{
+		ID:         ident.ID,
+		Username:   ident.Username,
+		GlobalName: ident.GlobalName,
+		AvatarURL:  avatarURL(ident),
+	})
+}
+
+func (s *Server) handleAPIState(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, ok := s.apiAuth(wr, req, scopeReadAccess)
+	if !ok {
+		return
+	}
+	ident, ok := s.apiIdentity(wr, req, userID)
+	if !ok {
+		return
+	}
+	userState, ok := s.apiUserState(wr, req, ident)
+	if !ok {
+		return
+	}
+	writeJSON(wr, http.StatusOK, userState)
+}
+
+func (s *Server) handleAPIAccess(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, ok := s.apiAuth(wr, req, scopeReadAccess)
+	if !ok {
+		return
+	}
+	ident, ok := s.apiIdentity(wr, req, userID)
+	if !ok {
+		return
+	}
+	userState, ok := s.apiUserState(wr, req, ident)
+	if !ok {
+		return
+	}
+	writeJSON(wr, http.StatusOK, s.userACL(userState.GhidraUsername))
+}
+
+func (s *Server) handleAPIPassword(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, ok := s.apiAuth(wr, req, scopeWritePassword)
+	if !ok || !s.apiRateLimit(wr, req, LimitPassword, userID) {
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+	}
+	if !readJSON(wr, req, &body) {
+		return
+	}
+	if body.Password == "" {
+		writeJSONError(wr, http.StatusBadRequest, "password must not be empty")
+		return
+	}
+	ident, ok := s.apiIdentity(wr, req, userID)
+	if !ok {
+		return
+	}
+	if ident.Username == "" {
+		writeJSONError(wr, http.StatusConflict, "log into the panel once before setting a password")
+		return
+	}
+	if err := s.setPassword(req.Context(), ident.ID, ident.Username, body.Password); errors.Is(err, passhash.ErrBusy) {
+		wr.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+		writeJSONError(wr, http.StatusServiceUnavailable, "server busy, retry later")
+		return
+	} else if errors.Is(err, database.ErrUsernameTaken) {
+		writeJSONError(wr, http.StatusConflict, "username belongs to another Ghidra account, contact an admin")
+		return
+	} else if err != nil {
+		log.Print("Failed to update password of user: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAPIAccessRequests(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	userID, ok := s.apiAuth(wr, req, scopeRequestAccess)
+	if !ok || !s.apiRateLimit(wr, req, LimitAccessRequest, userID) {
+		return
+	}
+	ident, ok := s.apiIdentity(wr, req, userID)
+	if !ok {
+		return
+	}
+	userState, ok := s.apiUserState(wr, req, ident)
+	if !ok {
+		return
+	}
+	if !userState.HasPassword {
+		writeJSONError(wr, http.StatusConflict, "set a password before requesting access")
+		return
+	}
+	message := s.writeMessage(ident)
+	if err := s.sendWebhook(req.Context(), &message); err != nil {
+		log.Print("Failed to send access request: ", err)
+		writeJSONError(wr, http.StatusBadGateway, "failed to send access request")
+		return
+	}
+	wr.WriteHeader(http.StatusAccepted)
+}
+
+type apiRepo struct {
+	Name            string          `json:"name"`
+	AnonymousAccess bool            `json:"anonymous_access"`
+	Users           []apiRepoAccess `json:"users"`
+}
+
+type apiRepoAccess struct {
+	User string `json:"user"`
+	Perm string `json:"perm"`
+}
+
+type apiRepos struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Repos     []apiRepo `json:"repos"`
+}
+
+func (s *Server) handleAPIAdminRepos(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if _, ok := s.apiAdmin(wr, req); !ok {
+		return
+	}
+	acls := s.ACLs.Get()
+	if acls == nil {
+		writeJSONError(wr, http.StatusServiceUnavailable, "ACLs not loaded yet")
+		return
+	}
+
+	res := apiRepos{UpdatedAt: acls.UpdatedAt, Repos: []apiRepo{}}
+	for name, acl := range acls.ACLs {
+		repo := apiRepo{Name: name, AnonymousAccess: acl.AnonymousAccess, Users: []apiRepoAccess{}}
+		for user, perm := range acl.Users {
+			repo.Users = append(repo.Users, apiRepoAccess{User: user, Perm: ghidra.PermStrs[perm]})
+		}
+		sort.Slice(repo.Users, func(i, j int) bool { return repo.Users[i].User < repo.Users[j].User })
+		res.Repos = append(res.Repos, repo)
+	}
+	sort.Slice(res.Repos, func(i, j int) bool { return res.Repos[i].Name < res.Repos[j].Name })
+	writeJSON(wr, http.StatusOK, &res)
+}
+
+// handleAPIAdminRepoUser handles /api/v1/admin/repos/{repo}/users/{user}.
+func (s *Server) handleAPIAdminRepoUser(wr http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/v1/admin/repos/"), "/")
+	if len(parts) != 3 || parts[1] != "users" || parts[0] == "" || parts[2] == "" {
+		s.handleAPINotFound(wr, req)
+		return
+	}
+	repo, user := parts[0], parts[2]
+
+	if req.Method != http.MethodPut && req.Method != http.MethodDelete {
+		writeJSONError(wr, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	adminID, ok := s.apiAdmin(wr, req)
+	if !ok || !s.apiStepUp(wr, req, adminID) {
+		return
+	}
+
+	perm := ghidra.PermNone
+	if req.Method == http.MethodPut {
+		var body struct {
+			Perm string `json:"perm"`
+		}
+		if !readJSON(wr, req, &body) {
+			return
+		}
+		if perm, ok = ghidra.ParsePerm(body.Perm); !ok {
+			writeJSONError(wr, http.StatusBadRequest, fmt.Sprintf("invalid perm %q", body.Perm))
+			return
+		}
+	}
+
+	change, err := s.ACLs.SetUserPerm(repo, user, perm)
+	switch {
+	case errors.Is(err, ghidra.ErrUnknownRepo):
+		writeJSONError(wr, http.StatusNotFound, "unknown repo")
+		return
+	case errors.Is(err, ghidra.ErrInvalidUser):
+		writeJSONError(wr, http.StatusBadRequest, "invalid user name")
+		return
+	case err != nil:
+		log.Print("Failed to update ACL: ", err)
+		writeJSONError(wr, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if change.Old != change.New {
+		if err := s.DB.Audit(req.Context(), adminID, "acl.set", change.String()); err != nil {
+			log.Print("Failed to write audit log: ", err)
+		}
+	}
+	wr.WriteHeader(http.StatusNoContent)
+}
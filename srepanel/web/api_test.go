@@ -0,0 +1,178 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/totp"
+)
+
+// Users of the API tests
+const (
+	apiUser     = 1 // bob, owns the Ghidra account bob
+	apiAdmin    = 2 // carol, admin with two-factor authentication
+	apiSquatter = 3 // another bob, without a Ghidra account
+	apiDisabled = 4
+)
+
+// newAPITestServer creates a server with the API test users and returns
+// the TOTP secret of the admin.
+func newAPITestServer(t *testing.T) (*Server, []byte) {
+	t.Helper()
+	webhook := httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		wr.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(webhook.Close)
+	s := newTestServer(t, &Config{
+		DiscordWebhookURL: webhook.URL,
+		GhidraEndpoint:    &common.GhidraEndpoint{Hostname: "ghidra.example", Port: 13100},
+	})
+
+	ctx := context.Background()
+	for _, ident := range []*common.Identity{
+		{ID: apiUser, Username: "bob", GlobalName: "Bob"},
+		{ID: apiAdmin, Username: "carol"},
+		{ID: apiSquatter, Username: "bob"},
+		{ID: apiDisabled, Username: "dave"},
+	} {
+		ident.Provider = "discord"
+		ident.Subject = strconv.FormatUint(ident.ID, 10)
+		if _, _, err := s.DB.ResolveIdentity(ctx, ident); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.DB.SyncProfile(ctx, ident); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DB.SetPassword(ctx, apiUser, "bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.SetRole(ctx, apiAdmin, database.RoleAdmin, true); err != nil {
+		t.Fatal(err)
+	}
+	secret := totp.NewSecret()
+	if err := s.DB.SetPendingTOTP(ctx, apiAdmin, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.EnableTOTP(ctx, apiAdmin, totp.Step(time.Now())-10, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.DisableUser(ctx, apiDisabled); err != nil {
+		t.Fatal(err)
+	}
+	return s, secret
+}
+
+// newTestAPIToken creates an API token of the user with the given scopes.
+func newTestAPIToken(t *testing.T, s *Server, userID uint64, scopes ...string) string {
+	t.Helper()
+	id, secret := newAPIToken()
+	tok := common.APIToken{
+		ID:        id,
+		Name:      "test",
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := s.DB.CreateAPIToken(context.Background(), userID, &tok, hashAPISecret(secret)); err != nil {
+		t.Fatal(err)
+	}
+	return apiTokenPrefix + id + "." + secret
+}
+
+func TestAPI(t *testing.T) {
+	const validCode = "valid"
+
+	tests := []struct {
+		name   string
+		user   uint64   // owner of the token, none if zero
+		scopes []string // of the token
+		auth   string   // Authorization header, overrides the token
+		method string
+		path   string
+		body   string
+		code   string // sent in the two-factor header, see validCode
+		status int
+		want   string // part of the response body
+	}{
+		{name: "no token", method: http.MethodGet, path: "/api/v1/me", status: http.StatusUnauthorized, want: "invalid API token"},
+		{name: "malformed token", auth: "Bearer gpat_nope", method: http.MethodGet, path: "/api/v1/me", status: http.StatusUnauthorized},
+		{name: "wrong secret", auth: "Bearer gpat_AAAAAAAAAAAA.secret", method: http.MethodGet, path: "/api/v1/me", status: http.StatusUnauthorized},
+		{name: "session scheme", auth: "Basic Ym9iOmh1bnRlcjI=", method: http.MethodGet, path: "/api/v1/me", status: http.StatusUnauthorized},
+		{name: "disabled user", user: apiDisabled, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/me", status: http.StatusUnauthorized},
+		{name: "missing scope", user: apiUser, scopes: []string{scopeWritePassword}, method: http.MethodGet, path: "/api/v1/me", status: http.StatusForbidden, want: `lacks scope \"access:read\"`},
+		{name: "method not allowed", user: apiUser, scopes: []string{scopeReadAccess}, method: http.MethodPost, path: "/api/v1/me", status: http.StatusMethodNotAllowed},
+		{name: "unknown endpoint", user: apiUser, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/nope", status: http.StatusNotFound, want: `"error":"not found"`},
+		{name: "OpenAPI document", method: http.MethodGet, path: "/api/v1/openapi.json", status: http.StatusOK, want: `"openapi"`},
+
+		{name: "me", user: apiUser, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/me", status: http.StatusOK, want: `"username":"bob","global_name":"Bob"`},
+		{name: "state", user: apiUser, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/me/state", status: http.StatusOK, want: `"has_password":true,"ghidra_username":"bob"`},
+		{name: "state of squatter", user: apiSquatter, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/me/state", status: http.StatusOK, want: `"has_password":false,"ghidra_username":""`},
+		{name: "access", user: apiUser, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/me/access", status: http.StatusOK, want: `"re"`},
+		{name: "access of squatter", user: apiSquatter, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/me/access", status: http.StatusOK, want: "[]"},
+
+		{name: "password", user: apiUser, scopes: []string{scopeWritePassword}, method: http.MethodPut, path: "/api/v1/me/password", body: `{"password":"new"}`, status: http.StatusNoContent},
+		{name: "password without scope", user: apiUser, scopes: []string{scopeReadAccess}, method: http.MethodPut, path: "/api/v1/me/password", body: `{"password":"new"}`, status: http.StatusForbidden},
+		{name: "empty password", user: apiUser, scopes: []string{scopeWritePassword}, method: http.MethodPut, path: "/api/v1/me/password", body: `{"password":""}`, status: http.StatusBadRequest},
+		{name: "unknown field", user: apiUser, scopes: []string{scopeWritePassword}, method: http.MethodPut, path: "/api/v1/me/password", body: `{"password":"new","admin":true}`, status: http.StatusBadRequest},
+		{name: "malformed body", user: apiUser, scopes: []string{scopeWritePassword}, method: http.MethodPut, path: "/api/v1/me/password", body: `{`, status: http.StatusBadRequest},
+		{name: "password of taken username", user: apiSquatter, scopes: []string{scopeWritePassword}, method: http.MethodPut, path: "/api/v1/me/password", body: `{"password":"new"}`, status: http.StatusConflict, want: "another Ghidra account"},
+
+		{name: "access request", user: apiUser, scopes: []string{scopeRequestAccess}, method: http.MethodPost, path: "/api/v1/me/access_requests", status: http.StatusAccepted},
+		{name: "access request without password", user: apiSquatter, scopes: []string{scopeRequestAccess}, method: http.MethodPost, path: "/api/v1/me/access_requests", status: http.StatusConflict},
+
+		{name: "repos", user: apiAdmin, scopes: []string{scopeAdmin}, method: http.MethodGet, path: "/api/v1/admin/repos", status: http.StatusOK, want: `"name":"re","anonymous_access":false,"users":[{"user":"bob","perm":"READ_ONLY"}]`},
+		{name: "repos of non-admin", user: apiUser, scopes: []string{scopeAdmin}, method: http.MethodGet, path: "/api/v1/admin/repos", status: http.StatusForbidden, want: "admin role required"},
+		{name: "repos without scope", user: apiAdmin, scopes: []string{scopeReadAccess}, method: http.MethodGet, path: "/api/v1/admin/repos", status: http.StatusForbidden},
+		{name: "set permission without code", user: apiAdmin, scopes: []string{scopeAdmin}, method: http.MethodPut, path: "/api/v1/admin/repos/re/users/bob", body: `{"perm":"WRITE"}`, status: http.StatusForbidden, want: "two-factor code required"},
+		{name: "set permission with wrong code", user: apiAdmin, scopes: []string{scopeAdmin}, method: http.MethodPut, path: "/api/v1/admin/repos/re/users/bob", body: `{"perm":"WRITE"}`, code: "000000", status: http.StatusForbidden, want: "invalid two-factor code"},
+		{name: "set invalid permission", user: apiAdmin, scopes: []string{scopeAdmin}, method: http.MethodPut, path: "/api/v1/admin/repos/re/users/bob", body: `{"perm":"OWNER"}`, code: validCode, status: http.StatusBadRequest, want: "invalid perm"},
+		{name: "set permission in unknown repo", user: apiAdmin, scopes: []string{scopeAdmin}, method: http.MethodDelete, path: "/api/v1/admin/repos/nope/users/bob", code: validCode, status: http.StatusNotFound, want: "unknown repo"},
+		{name: "malformed repo path", user: apiAdmin, scopes: []string{scopeAdmin}, method: http.MethodDelete, path: "/api/v1/admin/repos/re/bob", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, secret := newAPITestServer(t)
+			mux := http.NewServeMux()
+			s.RegisterRoutes(mux)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			switch {
+			case tt.auth != "":
+				req.Header.Set("Authorization", tt.auth)
+			case tt.user != 0:
+				req.Header.Set("Authorization", "Bearer "+newTestAPIToken(t, s, tt.user, tt.scopes...))
+			}
+			switch tt.code {
+			case "":
+			case validCode:
+				req.Header.Set(totpHeader, totp.Code(secret, totp.Step(time.Now())))
+			default:
+				req.Header.Set(totpHeader, tt.code)
+			}
+			wr := httptest.NewRecorder()
+			mux.ServeHTTP(wr, req)
+
+			if wr.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", wr.Code, tt.status, wr.Body)
+			}
+			if !strings.Contains(wr.Body.String(), tt.want) {
+				t.Errorf("body %q does not contain %q", wr.Body, tt.want)
+			}
+			if wr.Code >= 400 && wr.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("error with content type %q", wr.Header().Get("Content-Type"))
+			}
+			if wr.Code == http.StatusUnauthorized && wr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+)
+
+// apiTokenPrefix marks API tokens, so they can be recognized by secret scanners.
+const apiTokenPrefix = "gpat_"
+
+const (
+	maxAPITokens       = 20
+	maxAPITokenNameLen = 64
+	maxAPITokenDays    = 365
+)
+
+// apiScope is a permission that can be granted to an API token.
+type apiScope struct {
+	Name        string
+	Description string
+}
+
+const (
+	scopeReadAccess    = "access:read"
+	scopeRequestAccess = "access:request"
+	scopeWritePassword = "password:write"
+	scopeAdmin         = "admin"
+)
+
+var apiScopes = []apiScope{
+	{Name: scopeReadAccess, Description: "View your profile and repository access"},
+	{Name: scopeRequestAccess, Description: "Request repository access from the admins"},
+	{Name: scopeWritePassword, Description: "Change your Ghidra password"},
+	{Name: scopeAdmin, Description: "Manage repository access (admins only)"},
+}
+
+func validScope(name string) bool {
+	for _, scope := range apiScopes {
+		if scope.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newAPIToken generates the public ID and the secret of an API token.
+func newAPIToken() (id, secret string) {
+	var idBuf [9]byte
+	var secretBuf [32]byte
+	if _, err := rand.Read(idBuf[:]); err != nil {
+		panic("crypto rand read failed: " + err.Error())
+	}
+	if _, err := rand.Read(secretBuf[:]); err != nil {
+		panic("crypto rand read failed: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(idBuf[:]), base64.RawURLEncoding.EncodeToString(secretBuf[:])
+}
+
+// hashAPISecret hashes the secret of an API token for storage.
+// Secrets are random, so a fast hash suffices.
+func hashAPISecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// parseAPIToken splits an API token into its ID and secret.
+func parseAPIToken(tok string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(tok, apiTokenPrefix) {
+		return "", "", false
+	}
+	return strings.Cut(tok[len(apiTokenPrefix):], ".")
+}
+
+// checkAPIToken authenticates a request carrying an API token
+// in the Authorization header.
+func (s *Server) checkAPIToken(req *http.Request) (*database.APITokenAuth, bool) {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil, false
+	}
+	id, secret, ok := parseAPIToken(strings.TrimSpace(auth[7:]))
+	if !ok {
+		return nil, false
+	}
+	ctx := req.Context()
+	tok, err := s.DB.LookupAPIToken(ctx, id)
+	if err != nil {
+		log.Print("Failed to look up API token: ", err)
+		return nil, false
+	}
+	if tok == nil || subtle.ConstantTimeCompare(tok.Hash, hashAPISecret(secret)) != 1 {
+		return nil, false
+	}
+	if disabled, err := s.DB.IsDisabled(ctx, tok.UserID); err != nil || disabled {
+		return nil, false
+	}
+
+	// Record usage at most every sessionTouchInterval
+	now := time.Now()
+	key := "api:" + id
+	if last, ok := s.touched.Load(key); !ok || now.Sub(last.(time.Time)) >= sessionTouchInterval {
+		s.touched.Store(key, now)
+		if err := s.DB.TouchAPIToken(ctx, id, now); err != nil {
+			log.Print("Failed to update API token: ", err)
+		}
+	}
+	return tok, true
+}
+
+// newTokenCookie carries the secret of a just created API token
+// from the form submission to the page showing it.
+const newTokenCookie = "new_token"
+
+// newTokenMaxAge is how long, in seconds, the secret of a just created
+// API token is kept for the redirect to pick it up.
+const newTokenMaxAge = 60
+
+// ownsAPIToken reports whether id is one of the tokens.
+func ownsAPIToken(tokens []common.APIToken, id string) bool {
+	for _, tok := range tokens {
+		if tok.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleTokens(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := s.stateWithNav(
+		Nav{Route: "/", Name: "Ghidra"},
+		Nav{Route: "/tokens", Name: "API Tokens"},
+	)
+	if !s.authenticateState(wr, req, state) {
+		return
+	}
+	ctx := req.Context()
+	userID := state.Identity.ID
+
+	tokens, err := s.DB.ListAPITokens(ctx, userID)
+	if err != nil {
+		log.Print("Failed to list API tokens: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Create a token
+	if req.Method == http.MethodPost {
+		if err := req.ParseForm(); err != nil {
+			http.Error(wr, "Bad request", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(req.PostForm.Get("name"))
+		days, err := strconv.Atoi(req.PostForm.Get("expires_in_days"))
+		scopes := req.PostForm["scope"]
+		valid := err == nil && days > 0 && days <= maxAPITokenDays &&
+			name != "" && len(name) <= maxAPITokenNameLen && len(scopes) > 0
+		for _, scope := range scopes {
+			valid = valid && validScope(scope) && (scope != scopeAdmin || state.UserState.IsAdmin)
+		}
+		if !valid {
+			http.Error(wr, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if len(tokens) >= maxAPITokens {
+			http.Error(wr, fmt.Sprintf("You cannot have more than %d API tokens", maxAPITokens), http.StatusBadRequest)
+			return
+		}
+
+		id, secret := newAPIToken()
+		now := time.Now()
+		tok := common.APIToken{
+			ID:        id,
+			Name:      name,
+			Scopes:    scopes,
+			CreatedAt: now,
+			ExpiresAt: now.AddDate(0, 0, days),
+		}
+		if err := s.DB.CreateAPIToken(ctx, userID, &tok, hashAPISecret(secret)); err != nil {
+			log.Print("Failed to create API token: ", err)
+			http.Error(wr, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := s.DB.Audit(ctx, userID, "api_token.create", fmt.Sprintf("id=%s scopes=%s", id, strings.Join(scopes, ","))); err != nil {
+			log.Print("Failed to write audit log: ", err)
+		}
+		// Redirect, so reloading the page does not create another token
+		setCookie(wr, &http.Cookie{
+			Name:   newTokenCookie,
+			Value:  apiTokenPrefix + id + "." + secret,
+			Path:   "/tokens",
+			MaxAge: newTokenMaxAge,
+		})
+		http.Redirect(wr, req, "/tokens", http.StatusSeeOther)
+		return
+	}
+
+	// Show the secret of a just created token once
+	if cookie, err := req.Cookie(newTokenCookie); err == nil {
+		clearCookie(wr, newTokenCookie, "/tokens")
+		wr.Header().Set("Cache-Control", "no-store")
+		if id, _, ok := parseAPIToken(cookie.Value); ok && ownsAPIToken(tokens, id) {
+			state.NewToken = cookie.Value
+		}
+	}
+
+	state.APITokens = tokens
+	state.APIScopes = apiScopes
+	state.Notice = homeNotice(req)
+	if err := tokensPage.Execute(wr, state); err != nil {
+		log.Print("failed to serve tokens: ", err)
+	}
+}
+
+func (s *Server) handleRevokeToken(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, ok := s.checkAuth(req)
+	if !ok {
+		http.Error(wr, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	id := req.PostForm.Get("token")
+	revoked, err := s.DB.RevokeAPIToken(req.Context(), ident.ID, id)
+	if err != nil {
+		log.Print("Failed to revoke API token: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if revoked {
+		if err := s.DB.Audit(req.Context(), ident.ID, "api_token.revoke", "id="+id); err != nil {
+			log.Print("Failed to write audit log: ", err)
+		}
+	}
+	http.Redirect(wr, req, "/tokens?tokens=revoked", http.StatusSeeOther)
+}
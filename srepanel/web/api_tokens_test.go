@@ -0,0 +1,129 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+// tokensRequest sends a request to the API tokens page.
+func tokensRequest(s *Server, method string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/tokens", strings.NewReader(form.Encode()))
+	if method == http.MethodPost {
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	wr := httptest.NewRecorder()
+	s.handleTokens(wr, req)
+	return wr
+}
+
+// responseCookie returns the cookie with the given name set by a response.
+func responseCookie(wr *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range wr.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestCreateAPIToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		form    url.Values
+		status  int
+		created bool
+	}{
+		{"valid", url.Values{"name": {"ci"}, "expires_in_days": {"30"}, "scope": {scopeReadAccess}}, http.StatusSeeOther, true},
+		{"no name", url.Values{"expires_in_days": {"30"}, "scope": {scopeReadAccess}}, http.StatusBadRequest, false},
+		{"no scope", url.Values{"name": {"ci"}, "expires_in_days": {"30"}}, http.StatusBadRequest, false},
+		{"unknown scope", url.Values{"name": {"ci"}, "expires_in_days": {"30"}, "scope": {"root"}}, http.StatusBadRequest, false},
+		{"admin scope", url.Values{"name": {"ci"}, "expires_in_days": {"30"}, "scope": {scopeAdmin}}, http.StatusBadRequest, false},
+		{"too long", url.Values{"name": {"ci"}, "expires_in_days": {"366"}, "scope": {scopeReadAccess}}, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			session := login(t, s, &common.Identity{ID: 1, Username: "bob"})
+
+			wr := tokensRequest(s, http.MethodPost, tt.form, session)
+			if wr.Code != tt.status {
+				t.Fatalf("status %d, want %d", wr.Code, tt.status)
+			}
+			tokens, err := s.DB.ListAPITokens(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created := len(tokens) == 1; created != tt.created || len(tokens) > 1 {
+				t.Fatalf("%d tokens created, want created %v", len(tokens), tt.created)
+			}
+			flash := responseCookie(wr, newTokenCookie)
+			if !tt.created {
+				if flash != nil {
+					t.Errorf("secret set without token: %+v", flash)
+				}
+				return
+			}
+
+			// The secret is not part of the response to the form,
+			// which can be re-submitted by reloading
+			if location := wr.Header().Get("location"); location != "/tokens" {
+				t.Errorf("redirect to %q, want /tokens", location)
+			}
+			if flash == nil || flash.Path != "/tokens" || flash.MaxAge <= 0 || !flash.HttpOnly || !flash.Secure {
+				t.Fatalf("secret cookie %+v", flash)
+			}
+			if strings.Contains(wr.Body.String(), flash.Value) {
+				t.Error("secret rendered in response to the form")
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+			req.Header.Set("Authorization", "Bearer "+flash.Value)
+			if tok, ok := s.checkAPIToken(req); !ok || tok.UserID != 1 {
+				t.Fatalf("created token does not authenticate")
+			}
+
+			// The secret is shown once
+			wr = tokensRequest(s, http.MethodGet, nil, session, flash)
+			if wr.Code != http.StatusOK || !strings.Contains(wr.Body.String(), flash.Value) {
+				t.Fatalf("status %d, secret shown %v", wr.Code, strings.Contains(wr.Body.String(), flash.Value))
+			}
+			if cleared := responseCookie(wr, newTokenCookie); cleared == nil || cleared.MaxAge >= 0 {
+				t.Errorf("secret cookie not cleared: %+v", cleared)
+			}
+			if cache := wr.Header().Get("Cache-Control"); cache != "no-store" {
+				t.Errorf("Cache-Control %q, want no-store", cache)
+			}
+			wr = tokensRequest(s, http.MethodGet, nil, session)
+			if strings.Contains(wr.Body.String(), flash.Value) {
+				t.Error("secret shown again")
+			}
+		})
+	}
+}
+
+// The secret of a token is only shown to its owner.
+func TestCreateAPITokenOtherUser(t *testing.T) {
+	s := newTestServer(t, nil)
+	bob := login(t, s, &common.Identity{ID: 1, Username: "bob"})
+	alice := login(t, s, &common.Identity{ID: 2, Username: "alice"})
+
+	form := url.Values{"name": {"ci"}, "expires_in_days": {"30"}, "scope": {scopeReadAccess}}
+	flash := responseCookie(tokensRequest(s, http.MethodPost, form, bob), newTokenCookie)
+	if flash == nil {
+		t.Fatal("no secret cookie set")
+	}
+	for _, value := range []string{flash.Value, "gpat_forged.secret", "garbage"} {
+		wr := tokensRequest(s, http.MethodGet, nil, alice, &http.Cookie{Name: newTokenCookie, Value: value})
+		if strings.Contains(wr.Body.String(), value) {
+			t.Errorf("secret %q shown to another user", value)
+		}
+	}
+}
@@ -1,16 +1,23 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/discord"
 )
 
 func (s *Server) handleLogin(wr http.ResponseWriter, req *http.Request) {
+	returnTo := safeReturnTo(req.FormValue("return_to"))
 	_, ok := s.checkAuth(req)
 	if ok {
-		http.Redirect(wr, req, "/", http.StatusTemporaryRedirect)
+		http.Redirect(wr, req, orHome(returnTo), http.StatusSeeOther)
 		return
 	}
 
@@ -20,36 +27,74 @@ func (s *Server) handleLogin(wr http.ResponseWriter, req *http.Request) {
 			Nav{Route: "/", Name: "Ghidra"},
 			Nav{Route: "/login", Name: "Login"},
 		)
-		err := loginPage.Execute(wr, state)
-		if err != nil {
-			panic(err)
+		state.ReturnTo = returnTo
+		if err := loginPage.Execute(wr, state); err != nil {
+			log.Print("failed to serve login: ", err)
 		}
 	case http.MethodPost:
-		if s.Config.Dev {
+		if !s.rateLimit(wr, req, LimitLogin, 0) {
+			return
+		}
+		if s.Config().Dev {
 			ident := &common.Identity{
 				ID:       1,
 				Username: "testuser",
 			}
-			http.SetCookie(wr, &http.Cookie{
-				Name:     "token",
-				Value:    s.Issuer.Issue(ident),
-				Path:     "/",
-				HttpOnly: true,
-				Secure:   true,
-			})
-			http.Redirect(wr, req, "/", http.StatusTemporaryRedirect)
+			if err := s.startSession(wr, req, ident); err != nil {
+				log.Print("Failed to start session: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(wr, req, orHome(returnTo), http.StatusSeeOther)
 			return
 		}
-		authURL := s.Auth.AuthURL()
-		http.Redirect(wr, req, authURL, http.StatusTemporaryRedirect)
+		provider := s.provider(req.PostFormValue("provider"))
+		if provider == nil {
+			http.Error(wr, "unknown identity provider", http.StatusBadRequest)
+			return
+		}
+		// See Other, so the form is not re-posted to the provider
+		authURL := provider.AuthURL(wr, returnTo)
+		http.Redirect(wr, req, authURL, http.StatusSeeOther)
 	default:
 		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// provider returns the identity provider with the given ID.
+// An empty ID selects the only provider if there is exactly one.
+func (s *Server) provider(id string) IdentityProvider {
+	if id == "" && len(s.Providers) == 1 {
+		return s.Providers[0]
+	}
+	for _, p := range s.Providers {
+		if p.ID() == id {
+			return p
+		}
+	}
+	return nil
+}
+
 func (s *Server) handleOAuthRedirect(wr http.ResponseWriter, req *http.Request) {
-	ident, err := s.Auth.HandleRedirect(wr, req)
+	// Discord redirects to /redirect for compatibility with existing app registrations
+	providerID := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/redirect"), "/")
+	if providerID == "" {
+		providerID = "discord"
+	}
+	provider := s.provider(providerID)
+	if provider == nil {
+		http.NotFound(wr, req)
+		return
+	}
+
+	ident, returnTo, err := provider.HandleRedirect(wr, req)
+	if errors.Is(err, discord.ErrNotGuildMember) {
+		s.metrics.logins.Inc(providerID, "denied")
+		s.renderDenied(wr, deniedNotMember)
+		return
+	}
 	if err != nil {
+		s.metrics.logins.Inc(providerID, "failure")
 		log.Print("redirect request failed: ", err)
 		http.Error(wr, "auth failed", http.StatusUnauthorized)
 		return
@@ -58,31 +103,164 @@ func (s *Server) handleOAuthRedirect(wr http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	http.SetCookie(wr, &http.Cookie{
-		Name:     "token",
-		Value:    s.Issuer.Issue(ident),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-	})
-	http.Redirect(wr, req, "/", http.StatusTemporaryRedirect)
+	if session, ok := s.checkLinkState(wr, req); ok {
+		s.completeLink(wr, req, session, ident)
+		return
+	}
+
+	ctx := req.Context()
+	guildRoles := ident.GuildRoles
+	userID, primary, err := s.DB.ResolveIdentity(ctx, ident)
+	if errors.Is(err, database.ErrIdentityUnlinked) {
+		s.metrics.logins.Inc(providerID, "denied")
+		s.renderDenied(wr, deniedUnlinked)
+		return
+	}
+	if err != nil {
+		log.Print("Failed to resolve identity: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	ident.ID = userID
+	if disabled, err := s.DB.IsDisabled(ctx, userID); err != nil {
+		log.Print("Failed to check user status: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	} else if disabled {
+		s.metrics.logins.Inc(providerID, "denied")
+		s.renderDenied(wr, deniedDisabled)
+		return
+	}
+	if primary {
+		s.syncProfile(ctx, ident)
+	} else {
+		// Present the account under its primary profile
+		profile, err := s.DB.GetProfile(ctx, userID)
+		if err != nil {
+			log.Print("Failed to get profile: ", err)
+			http.Error(wr, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if profile != nil {
+			ident = profile
+		}
+	}
+
+	if ghidraUsername, err := s.DB.GetUsername(ctx, userID); err != nil {
+		log.Print("Failed to get Ghidra username: ", err)
+	} else if ghidraUsername, err = s.ghidraUsername(ctx, ident, ghidraUsername); err != nil {
+		log.Print("Failed to get Ghidra username: ", err)
+	} else if ghidraUsername != "" {
+		s.syncRoles(ctx, userID, ghidraUsername, guildRoles)
+	}
+
+	if err := s.startSession(wr, req, ident); err != nil {
+		log.Print("Failed to start session: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.metrics.logins.Inc(providerID, "success")
+	http.Redirect(wr, req, orHome(safeReturnTo(returnTo)), http.StatusTemporaryRedirect)
+}
+
+// maxReturnToLen limits return paths carried through the OAuth state.
+const maxReturnToLen = 256
+
+// safeReturnTo returns the path if it is safe to redirect to after login,
+// that is a local absolute path, or an empty string otherwise.
+func safeReturnTo(returnTo string) string {
+	if len(returnTo) > maxReturnToLen || !strings.HasPrefix(returnTo, "/") {
+		return ""
+	}
+	// Reject protocol-relative URLs, including ones browsers
+	// normalize from backslashes, and control characters
+	if strings.HasPrefix(returnTo, "//") || strings.ContainsAny(returnTo, "\\\x00\r\n\t") {
+		return ""
+	}
+	parsed, err := url.Parse(returnTo)
+	if err != nil || parsed.Scheme != "" || parsed.Host != "" || parsed.User != nil {
+		return ""
+	}
+	// Do not return into the login flow
+	for _, prefix := range []string{"/login", "/logout", "/redirect"} {
+		if parsed.Path == prefix || strings.HasPrefix(parsed.Path, prefix+"/") {
+			return ""
+		}
+	}
+	return parsed.RequestURI()
+}
+
+func orHome(returnTo string) string {
+	if returnTo == "" {
+		return "/"
+	}
+	return returnTo
+}
+
+// syncProfile records the user's Discord profile and notifies admins
+// if their Ghidra username needs to be reconciled.
+// Failures are logged but do not prevent login.
+func (s *Server) syncProfile(ctx context.Context, ident *common.Identity) {
+	change, err := s.DB.SyncProfile(ctx, ident)
+	if err != nil {
+		log.Print("Failed to sync profile: ", err)
+		return
+	}
+	if change == nil {
+		return
+	}
+	if change.UsernameChanged() {
+		log.Printf("User %d changed username from %q to %q", ident.ID, change.OldUsername, change.NewUsername)
+	}
+	if !change.RenamePending {
+		return
+	}
+	message := s.writeRenameMessage(ident, change)
+	if err := s.sendWebhook(ctx, &message); err != nil {
+		log.Print("Failed to notify admins of rename: ", err)
+	}
+}
+
+// Reasons for refusing a login.
+const (
+	deniedNotMember = "not_member"
+	deniedDisabled  = "disabled"
+	deniedUnlinked  = "unlinked"
+)
+
+// renderDenied explains to the user why their login was refused.
+func (s *Server) renderDenied(wr http.ResponseWriter, reason string) {
+	state := s.stateWithNav(
+		Nav{Route: "/", Name: "Ghidra"},
+		Nav{Route: "/login", Name: "Login"},
+	)
+	state.Denied = reason
+	state.InviteURL = s.Config().GuildInviteURL
+	wr.WriteHeader(http.StatusForbidden)
+	if err := deniedPage.Execute(wr, state); err != nil {
+		log.Print("failed to serve denied page: ", err)
+	}
 }
 
 func (s *Server) checkAuth(req *http.Request) (*common.Identity, bool) {
-	cookie, err := req.Cookie("token")
-	if err != nil || cookie == nil {
+	claims, ok := s.checkSession(req)
+	if !ok {
 		return nil, false
 	}
-	return s.Issuer.Verify(cookie.Value)
+	return claims.Identity(), true
 }
 
 func (s *Server) handleLogout(wr http.ResponseWriter, req *http.Request) {
-	http.SetCookie(wr, &http.Cookie{
-		Name:   "token",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
-
-	http.Redirect(wr, req, "/login", http.StatusTemporaryRedirect)
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if claims, ok := s.checkSession(req); ok {
+		s.revokeSessions(req.Context(), claims.Sub, []string{claims.Jti}, "")
+	}
+
+	clearCookie(wr, tokenCookie, "/")
+
+	http.Redirect(wr, req, "/login", http.StatusSeeOther)
 }
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+func TestSafeReturnTo(t *testing.T) {
+	tests := []struct {
+		returnTo string
+		want     string
+	}{
+		{"/", "/"},
+		{"/tokens", "/tokens"},
+		{"/admin?tab=acl#roles", "/admin?tab=acl"},
+		{"/a/../b", "/a/../b"},
+		{"", ""},
+		{"tokens", ""},
+		{"https://evil.example/", ""},
+		{"//evil.example/", ""},
+		{"/\\evil.example/", ""},
+		{"/\\/evil.example/", ""},
+		{"/\tevil", ""},
+		{"/foo\r\nset-cookie: x", ""},
+		{"/%zz", ""},
+		{"/login", ""},
+		{"/login?return_to=/admin", ""},
+		{"/logout", ""},
+		{"/redirect", ""},
+		{"/redirect/corp", ""},
+		{"/redirects", "/redirects"},
+		{"/" + strings.Repeat("a", maxReturnToLen), ""},
+	}
+	for _, tt := range tests {
+		if got := safeReturnTo(tt.returnTo); got != tt.want {
+			t.Errorf("safeReturnTo(%q) = %q, want %q", tt.returnTo, got, tt.want)
+		}
+	}
+}
+
+// The return path survives the round trip through the identity provider,
+// and is checked again after it, as the provider hands back what it got.
+func TestLoginReturnTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		returnTo string // submitted with the login form
+		state    string // return path in the state sent to the provider
+		location string // after login
+	}{
+		{"none", "", "", "/"},
+		{"path", "/tokens", "/tokens", "/tokens"},
+		{"query", "/admin?tab=acl", "/admin?tab=acl", "/admin?tab=acl"},
+		{"absolute URL", "https://evil.example/", "", "/"},
+		{"protocol-relative URL", "//evil.example/", "", "/"},
+		{"login flow", "/redirect/test", "", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+
+			form := url.Values{"provider": {"test"}, "return_to": {tt.returnTo}}
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("content-type", "application/x-www-form-urlencoded")
+			wr := httptest.NewRecorder()
+			s.handleLogin(wr, req)
+			if wr.Code != http.StatusSeeOther {
+				t.Fatalf("login status %d, want %d", wr.Code, http.StatusSeeOther)
+			}
+			authURL, err := url.Parse(wr.Header().Get("location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := authURL.Query().Get("state")
+			if state != tt.state {
+				t.Errorf("return path %q sent to provider, want %q", state, tt.state)
+			}
+
+			wr = httptest.NewRecorder()
+			s.handleOAuthRedirect(wr, httptest.NewRequest(http.MethodGet, "/redirect/test?state="+url.QueryEscape(state), nil))
+			if wr.Code != http.StatusTemporaryRedirect || wr.Header().Get("location") != tt.location {
+				t.Errorf("redirect %d to %q, want %d to %q", wr.Code, wr.Header().Get("location"), http.StatusTemporaryRedirect, tt.location)
+			}
+		})
+	}
+
+	// A provider handing back an unsafe path is not followed
+	s := newTestServer(t, nil)
+	wr := httptest.NewRecorder()
+	s.handleOAuthRedirect(wr, httptest.NewRequest(http.MethodGet, "/redirect/test?state="+url.QueryEscape("//evil.example/"), nil))
+	if location := wr.Header().Get("location"); location != "/" {
+		t.Errorf("redirect to %q after unsafe return path, want /", location)
+	}
+}
+
+// Logging in with an identity unlinked from its account does not bring
+// it back into the account.
+func TestLoginUnlinked(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, nil)
+	// The account carol's test identity creates has another identity left
+	other := &common.Identity{Provider: "other", Subject: "carol", Username: "other-carol"}
+	if _, _, err := s.DB.ResolveIdentity(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	wr := httptest.NewRecorder()
+	s.handleOAuthRedirect(wr, httptest.NewRequest(http.MethodGet, "/redirect/test?state=", nil))
+	if wr.Code != http.StatusForbidden || !strings.Contains(wr.Body.String(), "Login unlinked") {
+		t.Fatalf("login status %d, want %d with unlinked notice", wr.Code, http.StatusForbidden)
+	}
+	for _, cookie := range wr.Result().Cookies() {
+		if cookie.Name == tokenCookie {
+			t.Error("session started for unlinked identity")
+		}
+	}
+	idents, err := s.DB.ListIdentities(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idents) != 1 || idents[0].Provider != "other" {
+		t.Errorf("identities %+v, want only the other identity", idents)
+	}
+}
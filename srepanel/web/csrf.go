@@ -0,0 +1,42 @@
+package web
+
+import (
+	"log"
+	"net/http"
+
+	"go.mkw.re/ghidra-panel/csrf"
+)
+
+// checkCSRF rejects state-changing requests that are cross-origin, of
+// unknown origin, or lack the synchronizer token of the session they are
+// made with. API requests authenticate with the Authorization header,
+// which browsers do not attach on their own, and are exempt.
+func (s *Server) checkCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(wr, req)
+			return
+		}
+		if req.Header.Get("Authorization") != "" {
+			next.ServeHTTP(wr, req)
+			return
+		}
+		if s.Config().Origin != "" {
+			if err := csrf.CheckOrigin(req, s.Config().Origin); err != nil {
+				log.Printf("Rejected %s %s: %v", req.Method, req.URL.Path, err)
+				http.Error(wr, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		if cookie, err := req.Cookie(tokenCookie); err == nil {
+			claims, ok := s.Issuer().VerifyClaims(cookie.Value)
+			if ok && !s.CSRF.Verify(claims.Jti, req.PostFormValue(csrf.FormField)) {
+				log.Printf("Rejected %s %s: invalid csrf token", req.Method, req.URL.Path)
+				http.Error(wr, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(wr, req)
+	})
+}
@@ -0,0 +1,63 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/csrf"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	s := newTestServer(t, &Config{Origin: "https://panel.example"})
+	cookie := login(t, s, &common.Identity{ID: 1, Username: "bob"})
+	claims, _ := s.Issuer().VerifyClaims(cookie.Value)
+	otherCookie := login(t, s, &common.Identity{ID: 2, Username: "alice"})
+	handler := s.checkCSRF(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		wr.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		auth    string // Authorization header
+		session *http.Cookie
+		token   string
+		status  int
+	}{
+		{"safe method", http.MethodGet, "https://evil.example", "", cookie, "", http.StatusNoContent},
+		{"valid token", http.MethodPost, "https://panel.example", "", cookie, s.CSRF.Token(claims.Jti), http.StatusNoContent},
+		{"no origin", http.MethodPost, "", "", cookie, s.CSRF.Token(claims.Jti), http.StatusForbidden},
+		{"missing token", http.MethodPost, "https://panel.example", "", cookie, "", http.StatusForbidden},
+		{"token of other session", http.MethodPost, "https://panel.example", "", otherCookie, s.CSRF.Token(claims.Jti), http.StatusForbidden},
+		{"cross origin", http.MethodPost, "https://evil.example", "", cookie, s.CSRF.Token(claims.Jti), http.StatusForbidden},
+		{"no session", http.MethodPost, "https://panel.example", "", nil, "", http.StatusNoContent},
+		{"no session and no origin", http.MethodPost, "", "", nil, "", http.StatusForbidden},
+		{"API token", http.MethodPut, "", "Bearer gpat_token", nil, "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{csrf.FormField: {tt.token}}
+			req := httptest.NewRequest(tt.method, "/update_password", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.session != nil {
+				req.AddCookie(tt.session)
+			}
+			wr := httptest.NewRecorder()
+			handler.ServeHTTP(wr, req)
+			if wr.Code != tt.status {
+				t.Errorf("status %d, want %d", wr.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultACLMaxAge is how old the ACLs may get before the panel is not ready.
+	// ACLs are refreshed every 30 seconds.
+	DefaultACLMaxAge = 2 * time.Minute
+	// readyTimeout bounds all readiness checks together.
+	readyTimeout = 3 * time.Second
+)
+
+// Results of health checks.
+const (
+	checkOK      = "ok"
+	checkFailed  = "fail"
+	checkSkipped = "skipped" // dependency not configured
+)
+
+// checkResult is the outcome of a single readiness check.
+type checkResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// healthResponse is the body of the health endpoints.
+type healthResponse struct {
+	Status string                  `json:"status"`
+	Checks map[string]*checkResult `json:"checks,omitempty"`
+}
+
+// errSkipped marks a check whose dependency is not configured.
+var errSkipped = errors.New("not configured")
+
+// handleHealthz reports that the process is alive and serving requests.
+func (s *Server) handleHealthz(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	wr.Header().Set("Cache-Control", "no-store")
+	writeJSON(wr, http.StatusOK, healthResponse{Status: checkOK})
+}
+
+// handleReadyz reports whether the panel and its dependencies are usable.
+// Responds 503 if any check fails.
+func (s *Server) handleReadyz(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"database": s.checkDatabase,
+		"acls":     s.checkACLs,
+		"repo_dir": s.checkRepoDir,
+		"ghidra":   s.checkGhidra,
+	}
+	resp := healthResponse{Status: checkOK, Checks: make(map[string]*checkResult, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		name, check := name, check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			result := &checkResult{
+				Status:     checkOK,
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			switch {
+			case errors.Is(err, errSkipped):
+				result.Status = checkSkipped
+			case err != nil:
+				result.Status = checkFailed
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[name] = result
+			if result.Status == checkFailed {
+				resp.Status = checkFailed
+			}
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if resp.Status != checkOK {
+		status = http.StatusServiceUnavailable
+	}
+	wr.Header().Set("Cache-Control", "no-store")
+	writeJSON(wr, status, resp)
+}
+
+func (s *Server) checkDatabase(ctx context.Context) error {
+	return s.DB.PingContext(ctx)
+}
+
+// checkACLs checks that the ACLs were read recently.
+func (s *Server) checkACLs(ctx context.Context) error {
+	if s.ACLs == nil || s.ACLs.Dir == "" {
+		return errSkipped
+	}
+	acls := s.ACLs.Get()
+	if acls == nil {
+		return errors.New("ACLs not loaded yet")
+	}
+	maxAge := s.Config().ACLMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultACLMaxAge
+	}
+	if age := time.Since(acls.UpdatedAt); age > maxAge {
+		return fmt.Errorf("ACLs are %s old", age.Round(time.Second))
+	}
+	return nil
+}
+
+// checkRepoDir checks that ACL files can be written.
+func (s *Server) checkRepoDir(ctx context.Context) error {
+	if s.ACLs == nil || s.ACLs.Dir == "" {
+		return errSkipped
+	}
+	f, err := os.CreateTemp(s.ACLs.Dir, ".panel-readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	err = f.Close()
+	if rmErr := os.Remove(name); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// checkGhidra checks that the Ghidra server accepts connections.
+func (s *Server) checkGhidra(ctx context.Context) error {
+	endpoint := s.Config().GhidraEndpoint
+	if endpoint == nil || endpoint.Hostname == "" {
+		return errSkipped
+	}
+	// Prefer the prober over opening connections on every request
+	if s.Prober != nil {
+		if status := s.Prober.Status(); status != nil {
+			if !status.Up {
+				return fmt.Errorf("down since %s", status.Since.Format(time.RFC3339))
+			}
+			return nil
+		}
+	}
+	addr := net.JoinHostPort(endpoint.Hostname, strconv.Itoa(int(endpoint.Port)))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
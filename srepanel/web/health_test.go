@@ -0,0 +1,188 @@
+package web
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/ghidra"
+)
+
+// listenGhidra returns the endpoint of a listener accepting connections.
+func listenGhidra(t *testing.T) *common.GhidraEndpoint {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return &common.GhidraEndpoint{Hostname: "127.0.0.1", Port: uint16(ln.Addr().(*net.TCPAddr).Port)}
+}
+
+// closedGhidra returns an endpoint refusing connections.
+func closedGhidra(t *testing.T) *common.GhidraEndpoint {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return &common.GhidraEndpoint{Hostname: "127.0.0.1", Port: uint16(port)}
+}
+
+// watchRepoDir points the ACL monitor of the server at a repo directory.
+func watchRepoDir(t *testing.T, s *Server) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "re"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "re", "userAccess.acl"), []byte("bob=READ_ONLY\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.ACLs = &ghidra.ACLMon{Dir: dir}
+	s.ACLs.Refresh()
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, s *Server)
+		status string
+		checks map[string]string
+	}{
+		{
+			name:   "nothing configured",
+			status: checkOK,
+			checks: map[string]string{"database": checkOK, "acls": checkSkipped, "repo_dir": checkSkipped, "ghidra": checkSkipped},
+		},
+		{
+			name: "all ok",
+			setup: func(t *testing.T, s *Server) {
+				watchRepoDir(t, s)
+				s.SetConfig(&Config{GhidraEndpoint: listenGhidra(t)})
+			},
+			status: checkOK,
+			checks: map[string]string{"database": checkOK, "acls": checkOK, "repo_dir": checkOK, "ghidra": checkOK},
+		},
+		{
+			name: "Ghidra down",
+			setup: func(t *testing.T, s *Server) {
+				watchRepoDir(t, s)
+				s.SetConfig(&Config{GhidraEndpoint: closedGhidra(t)})
+			},
+			status: checkFailed,
+			checks: map[string]string{"database": checkOK, "acls": checkOK, "repo_dir": checkOK, "ghidra": checkFailed},
+		},
+		{
+			name: "stale ACLs",
+			setup: func(t *testing.T, s *Server) {
+				watchRepoDir(t, s)
+				s.SetConfig(&Config{ACLMaxAge: time.Nanosecond})
+			},
+			status: checkFailed,
+			checks: map[string]string{"database": checkOK, "acls": checkFailed, "repo_dir": checkOK, "ghidra": checkSkipped},
+		},
+		{
+			name:   "ACLs not loaded",
+			setup:  func(t *testing.T, s *Server) { s.ACLs = &ghidra.ACLMon{Dir: t.TempDir()} },
+			status: checkFailed,
+			checks: map[string]string{"database": checkOK, "acls": checkFailed, "repo_dir": checkOK, "ghidra": checkSkipped},
+		},
+		{
+			name: "repo dir gone",
+			setup: func(t *testing.T, s *Server) {
+				watchRepoDir(t, s)
+				if err := os.RemoveAll(s.ACLs.Dir); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: checkFailed,
+			checks: map[string]string{"database": checkOK, "acls": checkOK, "repo_dir": checkFailed, "ghidra": checkSkipped},
+		},
+		{
+			name:   "database closed",
+			setup:  func(t *testing.T, s *Server) { s.DB.Close() },
+			status: checkFailed,
+			checks: map[string]string{"database": checkFailed, "acls": checkSkipped, "repo_dir": checkSkipped, "ghidra": checkSkipped},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			if tt.setup != nil {
+				tt.setup(t, s)
+			}
+			wr := httptest.NewRecorder()
+			s.handleReadyz(wr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			wantCode := http.StatusOK
+			if tt.status != checkOK {
+				wantCode = http.StatusServiceUnavailable
+			}
+			if wr.Code != wantCode || wr.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("status %d, Cache-Control %q, want %d", wr.Code, wr.Header().Get("Cache-Control"), wantCode)
+			}
+			var resp healthResponse
+			if err := json.Unmarshal(wr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != tt.status {
+				t.Errorf("status %q, want %q", resp.Status, tt.status)
+			}
+			if len(resp.Checks) != len(tt.checks) {
+				t.Errorf("checks %v, want %v", resp.Checks, tt.checks)
+			}
+			for name, want := range tt.checks {
+				result, ok := resp.Checks[name]
+				if !ok {
+					t.Errorf("check %s missing", name)
+					continue
+				}
+				if result.Status != want {
+					t.Errorf("check %s = %q (%s), want %q", name, result.Status, result.Error, want)
+				}
+				if (result.Error != "") != (result.Status == checkFailed) {
+					t.Errorf("check %s has error %q with status %q", name, result.Error, result.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	s := newTestServer(t, nil)
+	// Liveness does not depend on the database
+	s.DB.Close()
+	tests := []struct {
+		method string
+		status int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		wr := httptest.NewRecorder()
+		s.handleHealthz(wr, httptest.NewRequest(tt.method, "/healthz", nil))
+		if wr.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.method, wr.Code, tt.status)
+		}
+	}
+}
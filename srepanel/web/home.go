@@ -16,8 +16,61 @@ func (s *Server) handleHome(wr http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	state.Notice = homeNotice(req)
+	if s.Prober != nil {
+		state.GhidraStatus = s.Prober.Status()
+	}
+
 	err := homePage.Execute(wr, state)
 	if err != nil {
 		log.Print("failed to serve home: ", err)
 	}
 }
+
+// homeNotices maps result query parameters to messages shown on the home page.
+var homeNotices = map[string]map[string]string{
+	"acl": {
+		"updated": "The repository access has been updated.",
+	},
+	"access_request": {
+		"success": "Your access request has been sent to the admins.",
+		"failure": "Failed to send your access request, please try again later.",
+	},
+	"password_update": {
+		"success": "Your Ghidra password has been updated.",
+	},
+	"role": {
+		"updated": "The admin role has been updated.",
+	},
+	"secrets": {
+		"rotated": "A new signing key was added. Restart the panel to start using it.",
+	},
+	"link": {
+		"success":  "Your account has been linked.",
+		"conflict": "That account is already linked to another panel account.",
+	},
+	"sessions": {
+		"revoked": "The sessions have been signed out.",
+	},
+	"tokens": {
+		"revoked": "The API token has been revoked.",
+	},
+	"totp": {
+		"required": "Set up two-factor authentication to continue, it is required for admins.",
+		"disabled": "Two-factor authentication has been turned off.",
+	},
+	"unlink": {
+		"success": "Your account has been unlinked.",
+		"blocked": "You cannot unlink your only remaining way to log in.",
+	},
+}
+
+func homeNotice(req *http.Request) string {
+	query := req.URL.Query()
+	for param, messages := range homeNotices {
+		if msg, ok := messages[query.Get(param)]; ok {
+			return msg
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package web
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+)
+
+// linkCookie remembers the OAuth state of a pending account link.
+// The state itself is protected by csrf.OneTime in the identity provider.
+const linkCookie = "link_state"
+
+func (s *Server) handleLink(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, ok := s.checkAuth(req)
+	if !ok {
+		http.Error(wr, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if !s.rateLimit(wr, req, LimitLogin, ident.ID) {
+		return
+	}
+
+	provider := s.provider(req.PostFormValue("provider"))
+	if provider == nil {
+		http.Error(wr, "Unknown identity provider", http.StatusBadRequest)
+		return
+	}
+
+	authURL := provider.AuthURL(wr, "")
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	setCookie(wr, &http.Cookie{
+		Name:   linkCookie,
+		Value:  parsed.Query().Get("state"),
+		Path:   "/redirect",
+		MaxAge: 300,
+	})
+	// See Other, so the form is not re-posted to the provider
+	http.Redirect(wr, req, authURL, http.StatusSeeOther)
+}
+
+// checkLinkState returns the session of the user if the OAuth redirect
+// completes an account link rather than a login.
+func (s *Server) checkLinkState(wr http.ResponseWriter, req *http.Request) (*common.Identity, bool) {
+	cookie, err := req.Cookie(linkCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, false
+	}
+	clearCookie(wr, linkCookie, "/redirect")
+	if cookie.Value != req.URL.Query().Get("state") {
+		return nil, false
+	}
+	return s.checkAuth(req)
+}
+
+func (s *Server) completeLink(wr http.ResponseWriter, req *http.Request, session, ident *common.Identity) {
+	err := s.DB.LinkIdentity(req.Context(), session.ID, ident)
+	if errors.Is(err, database.ErrIdentityLinked) {
+		http.Redirect(wr, req, "/?link=conflict", http.StatusTemporaryRedirect)
+		return
+	} else if err != nil {
+		log.Print("Failed to link identity: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d linked %s identity %s", session.ID, ident.Provider, ident.Subject)
+	http.Redirect(wr, req, "/?link=success", http.StatusTemporaryRedirect)
+}
+
+func (s *Server) handleUnlink(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ident, ok := s.checkAuth(req)
+	if !ok {
+		http.Error(wr, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		http.Error(wr, "Bad request", http.StatusBadRequest)
+		return
+	}
+	provider := req.PostForm.Get("provider")
+	subject := req.PostForm.Get("subject")
+
+	enabled := make([]string, len(s.Providers))
+	for i, p := range s.Providers {
+		enabled[i] = p.ID()
+	}
+
+	err := s.DB.UnlinkIdentity(req.Context(), ident.ID, provider, subject, enabled)
+	if errors.Is(err, database.ErrLastIdentity) {
+		http.Redirect(wr, req, "/?unlink=blocked", http.StatusTemporaryRedirect)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		http.Error(wr, "Identity not linked", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Print("Failed to unlink identity: ", err)
+		http.Error(wr, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr, req, "/?unlink=success", http.StatusTemporaryRedirect)
+}
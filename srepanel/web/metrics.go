@@ -0,0 +1,198 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mkw.re/ghidra-panel/metrics"
+	"go.mkw.re/ghidra-panel/passhash"
+	"go.mkw.re/ghidra-panel/probe"
+)
+
+// serverMetrics are the metrics recorded by the web server.
+type serverMetrics struct {
+	requests  *metrics.CounterVec
+	latency   *metrics.HistogramVec
+	logins    *metrics.CounterVec
+	passwords *metrics.CounterVec
+	webhooks  *metrics.CounterVec
+}
+
+// newMetrics registers the metrics of the server, including those computed
+// from the ACL monitor and the password hasher at scrape time.
+func (s *Server) newMetrics() *serverMetrics {
+	r := s.Metrics
+	m := &serverMetrics{
+		requests: r.NewCounterVec("ghidra_panel_http_requests_total",
+			"HTTP requests by route, method and status code.", "route", "method", "code"),
+		latency: r.NewHistogramVec("ghidra_panel_http_request_duration_seconds",
+			"HTTP request latencies by route.", metrics.DefaultBuckets, "route"),
+		logins: r.NewCounterVec("ghidra_panel_oauth_logins_total",
+			"OAuth logins by provider and result (success, denied, failure).", "provider", "result"),
+		passwords: r.NewCounterVec("ghidra_panel_password_updates_total",
+			"Ghidra password updates by result (success, busy, failure).", "result"),
+		webhooks: r.NewCounterVec("ghidra_panel_webhook_deliveries_total",
+			"Discord webhook deliveries by result (success, failure).", "result"),
+	}
+
+	aclState := func(f func(acls *aclStats) float64) func() float64 {
+		return func() float64 {
+			return f(newACLStats(s))
+		}
+	}
+	r.NewGaugeFunc("ghidra_panel_acl_refresh_duration_seconds",
+		"Time taken by the last successful ACL refresh.",
+		aclState(func(a *aclStats) float64 { return a.duration.Seconds() }))
+	r.NewGaugeFunc("ghidra_panel_acl_age_seconds",
+		"Time since the ACLs in use were read.",
+		aclState(func(a *aclStats) float64 { return a.age.Seconds() }))
+	r.NewGaugeFunc("ghidra_panel_acl_repos",
+		"Repositories with an ACL.",
+		aclState(func(a *aclStats) float64 { return float64(a.repos) }))
+	r.NewGaugeFunc("ghidra_panel_acl_users",
+		"Users with access to at least one repository.",
+		aclState(func(a *aclStats) float64 { return float64(a.users) }))
+	r.NewCounterFunc("ghidra_panel_acl_refresh_failures_total",
+		"Failed ACL refreshes.",
+		func() float64 {
+			if s.ACLs == nil {
+				return 0
+			}
+			return float64(s.ACLs.Failures())
+		})
+
+	ghidraStatus := func(f func(st *probe.Status) float64) func() float64 {
+		return func() float64 {
+			if s.Prober == nil {
+				return 0
+			}
+			status := s.Prober.Status()
+			if status == nil {
+				return 0
+			}
+			return f(status)
+		}
+	}
+	r.NewGaugeFunc("ghidra_panel_ghidra_up",
+		"Whether the Ghidra server accepts connections on all ports.",
+		ghidraStatus(func(st *probe.Status) float64 {
+			if st.Up {
+				return 1
+			}
+			return 0
+		}))
+	r.NewGaugeFunc("ghidra_panel_ghidra_probe_latency_seconds",
+		"Connection latency of the slowest Ghidra server port in the last probe.",
+		ghidraStatus(func(st *probe.Status) float64 {
+			if len(st.History) == 0 {
+				return 0
+			}
+			return st.History[len(st.History)-1].Latency.Seconds()
+		}))
+
+	hasher := func(f func(st passhash.Stats) float64) func() float64 {
+		return func() float64 {
+			if s.DB == nil || s.DB.Hasher == nil {
+				return 0
+			}
+			return f(s.DB.Hasher.Stats())
+		}
+	}
+	r.NewGaugeFunc("ghidra_panel_argon2_workers",
+		"Maximum concurrent password hashes.",
+		hasher(func(st passhash.Stats) float64 { return float64(st.Workers) }))
+	r.NewGaugeFunc("ghidra_panel_argon2_active",
+		"Password hashes running.",
+		hasher(func(st passhash.Stats) float64 { return float64(st.Active) }))
+	r.NewGaugeFunc("ghidra_panel_argon2_queue_depth",
+		"Password hashes waiting for a worker.",
+		hasher(func(st passhash.Stats) float64 { return float64(st.Queued) }))
+	r.NewGaugeFunc("ghidra_panel_argon2_queue_capacity",
+		"Maximum password hashes running or waiting.",
+		hasher(func(st passhash.Stats) float64 { return float64(st.Capacity) }))
+	r.NewCounterFunc("ghidra_panel_argon2_hashes_total",
+		"Password hashes completed.",
+		hasher(func(st passhash.Stats) float64 { return float64(st.Completed) }))
+	r.NewCounterFunc("ghidra_panel_argon2_rejected_total",
+		"Password hashes rejected because the queue was full.",
+		hasher(func(st passhash.Stats) float64 { return float64(st.Rejected) }))
+	r.NewCounterFunc("ghidra_panel_argon2_canceled_total",
+		"Password hashes abandoned while waiting for a worker.",
+		hasher(func(st passhash.Stats) float64 { return float64(st.Canceled) }))
+	r.NewCounterFunc("ghidra_panel_argon2_wait_seconds_total",
+		"Total time password hashes waited for a worker.",
+		hasher(func(st passhash.Stats) float64 { return st.WaitTime.Seconds() }))
+	return m
+}
+
+// aclStats summarizes the ACLs in use.
+type aclStats struct {
+	duration, age time.Duration
+	repos, users  int
+}
+
+func newACLStats(s *Server) *aclStats {
+	var stats aclStats
+	if s.ACLs == nil {
+		return &stats
+	}
+	acls := s.ACLs.Get()
+	if acls == nil {
+		return &stats
+	}
+	stats.duration = acls.RefreshDuration
+	stats.age = time.Since(acls.UpdatedAt)
+	stats.repos = len(acls.ACLs)
+	stats.users = len(acls.UserAccess)
+	return &stats
+}
+
+// metricsMethod bounds the method label to common methods.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+// measureRequests records request counts and latencies by the route
+// pattern of routes that matches the request.
+func (s *Server) measureRequests(routes *http.ServeMux) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: wr}
+			_, route := routes.Handler(req)
+			if route == "" {
+				route = "none"
+			}
+			defer func() {
+				status := rec.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				s.metrics.requests.Inc(route, metricsMethod(req.Method), strconv.Itoa(status))
+				s.metrics.latency.Observe(time.Since(start).Seconds(), route)
+			}()
+			next.ServeHTTP(rec, req)
+		})
+	}
+}
+
+// setPassword updates the Ghidra password of a user, recording the result.
+func (s *Server) setPassword(ctx context.Context, userID uint64, username, password string) error {
+	err := s.DB.SetPassword(ctx, userID, username, password)
+	switch {
+	case err == nil:
+		s.metrics.passwords.Inc("success")
+	case errors.Is(err, passhash.ErrBusy):
+		s.metrics.passwords.Inc("busy")
+	default:
+		s.metrics.passwords.Inc("failure")
+	}
+	return err
+}
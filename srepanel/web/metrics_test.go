@@ -0,0 +1,109 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+// scrape returns the metrics exposed by the server.
+func scrape(t *testing.T, s *Server) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := s.Metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestMeasureRequests(t *testing.T) {
+	s := newTestServer(t, &Config{ServeMetrics: true})
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	requests := []struct {
+		method, target string
+	}{
+		{http.MethodGet, "/healthz"},
+		{http.MethodGet, "/healthz"},
+		{http.MethodPost, "/healthz"},
+		{"PATCH", "/healthz"},
+		{http.MethodGet, "/api/v1/admin/repos/re/users/bob"},
+		{http.MethodGet, "/api/v1/me?token=secret"},
+	}
+	for _, r := range requests {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(r.method, r.target, nil))
+	}
+
+	// Routes are labeled by pattern, so paths cannot blow up the series
+	out := scrape(t, s)
+	for _, want := range []string{
+		`ghidra_panel_http_requests_total{route="/healthz",method="GET",code="200"} 2`,
+		`ghidra_panel_http_requests_total{route="/healthz",method="POST",code="405"} 1`,
+		`ghidra_panel_http_requests_total{route="/healthz",method="other",code="405"} 1`,
+		`ghidra_panel_http_requests_total{route="/api/v1/admin/repos/",method="GET",code="405"} 1`,
+		`ghidra_panel_http_requests_total{route="/api/v1/me",method="GET",code="401"} 1`,
+		`ghidra_panel_http_request_duration_seconds_count{route="/healthz"} 4`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+	if strings.Contains(out, "bob") || strings.Contains(out, "secret") {
+		t.Error("metrics contain request paths")
+	}
+
+	// The registry is served on /metrics
+	wr := httptest.NewRecorder()
+	mux.ServeHTTP(wr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if wr.Code != http.StatusOK || !strings.Contains(wr.Body.String(), `route="/healthz"`) {
+		t.Errorf("/metrics status %d", wr.Code)
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	s := newTestServer(t, nil)
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+	wr := httptest.NewRecorder()
+	mux.ServeHTTP(wr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if strings.Contains(wr.Body.String(), "ghidra_panel_") {
+		t.Error("metrics served although disabled")
+	}
+}
+
+func TestScrapeTimeMetrics(t *testing.T) {
+	s := newTestServer(t, nil)
+	ctx := context.Background()
+	if _, _, err := s.DB.ResolveIdentity(ctx, &common.Identity{ID: 1, Provider: "discord", Subject: "1", Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setPassword(ctx, 1, "bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	// bob is not available for another user
+	if err := s.setPassword(ctx, 2, "bob", "hunter2"); err == nil {
+		t.Fatal("username taken twice")
+	}
+
+	out := scrape(t, s)
+	for _, want := range []string{
+		`ghidra_panel_password_updates_total{result="success"} 1`,
+		`ghidra_panel_password_updates_total{result="failure"} 1`,
+		"ghidra_panel_acl_repos 1",
+		"ghidra_panel_acl_users 1",
+		"ghidra_panel_argon2_workers 2",
+		"ghidra_panel_argon2_hashes_total 2",
+		"ghidra_panel_argon2_rejected_total 0",
+		"ghidra_panel_ghidra_up 0",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// contentSecurityPolicy allows the panel's own resources and the stylesheet CDN.
+// Templates use inline styles but no scripts.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'none'; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'none'; " +
+	"frame-ancestors 'none'"
+
+// hstsMaxAge is how long browsers remember to only use HTTPS, two years.
+const hstsMaxAge = 2 * 365 * 24 * 60 * 60
+
+// maxRequestIDLen bounds request IDs accepted from trusted proxies.
+const maxRequestIDLen = 64
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	clientIPKey
+)
+
+// middleware wraps a handler.
+type middleware func(http.Handler) http.Handler
+
+// chain applies middlewares to a handler, the first one outermost.
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// ParseTrustedProxies parses the addresses of reverse proxies,
+// given as CIDR ranges or single IP addresses.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy range %q", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func (s *Server) trustedProxy(ip net.IP) bool {
+	for _, ipNet := range s.Config().TrustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP determines the client address. Behind trusted proxies,
+// X-Forwarded-For is walked from the right, skipping proxies, so clients
+// cannot spoof their address by sending the header themselves.
+func (s *Server) resolveClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !s.trustedProxy(peer) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if !s.trustedProxy(ip) {
+			return ip.String()
+		}
+		host = ip.String()
+	}
+	return host
+}
+
+// clientIP returns the IP address of the client, as resolved by withRequestInfo.
+func clientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// requestID returns the ID of the request for correlating logs.
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic("crypto rand read failed: " + err.Error())
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestInfo assigns a request ID and resolves the client address.
+// Request IDs of trusted proxies are kept, so logs can be correlated.
+func (s *Server) withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-Id")
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err != nil || !s.trustedProxy(net.ParseIP(host)) || !validRequestID(id) {
+			id = newRequestID()
+		}
+		wr.Header().Set("X-Request-Id", id)
+
+		ctx := context.WithValue(req.Context(), requestIDKey, id)
+		ctx = context.WithValue(ctx, clientIPKey, s.resolveClientIP(req))
+		next.ServeHTTP(wr, req.WithContext(ctx))
+	})
+}
+
+// statusRecorder records the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests writes an access log line per request.
+// Query strings are left out, as they may carry OAuth codes.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: wr}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			log.Printf("http: %s %q %d %dB %s id=%s",
+				clientIP(req), req.Method+" "+req.URL.Path, status, rec.size,
+				time.Since(start).Round(time.Millisecond), requestID(req))
+		}()
+		next.ServeHTTP(rec, req)
+	})
+}
+
+// recoverPanics turns panics of handlers into a 500 page.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		rec := &statusRecorder{ResponseWriter: wr}
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			log.Printf("Panic serving %s %s id=%s: %v\n%s", req.Method, req.URL.Path, requestID(req), err, debug.Stack())
+			if rec.status != 0 {
+				// Too late for an error page
+				return
+			}
+			s.serveError(rec, req)
+		}()
+		next.ServeHTTP(rec, req)
+	})
+}
+
+// serveError writes the generic 500 page.
+func (s *Server) serveError(wr http.ResponseWriter, req *http.Request) {
+	state := s.stateWithNav(Nav{Route: "/", Name: "Ghidra"})
+	state.RequestID = requestID(req)
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	wr.Header().Set("Cache-Control", "no-store")
+	wr.WriteHeader(http.StatusInternalServerError)
+	if err := errorPage.Execute(wr, state); err != nil {
+		log.Print("failed to serve error: ", err)
+	}
+}
+
+// securityHeaders sets headers hardening browsers against framing,
+// content injection and downgrades.
+func (s *Server) securityHeaders(next http.Handler) http.Handler {
+	hsts := strings.HasPrefix(s.Config().Origin, "https://") && !s.Config().Dev
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		h := wr.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy)
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		if hsts {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", hstsMaxAge))
+		}
+		next.ServeHTTP(wr, req)
+	})
+}
+
+// RedirectHTTPS redirects plain HTTP requests to the same path at origin.
+// The Host header is ignored so the redirect cannot point elsewhere.
+func RedirectHTTPS(origin string) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		status := http.StatusMovedPermanently
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(wr, req, origin+req.URL.RequestURI(), status)
+	})
+}
+
+// setCookie sets a cookie with the attributes all panel cookies share:
+// hidden from scripts, HTTPS only and not sent along cross-site subrequests.
+func setCookie(wr http.ResponseWriter, cookie *http.Cookie) {
+	cookie.HttpOnly = true
+	cookie.Secure = true
+	cookie.SameSite = http.SameSiteLaxMode
+	http.SetCookie(wr, cookie)
+}
+
+// clearCookie deletes a cookie.
+func clearCookie(wr http.ResponseWriter, name, path string) {
+	setCookie(wr, &http.Cookie{Name: name, Value: "", Path: path, MaxAge: -1})
+}
@@ -0,0 +1,264 @@
+package web
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(wr, req)
+			})
+		}
+	}
+	h := chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { order = append(order, "handler") }),
+		mw("outer"), mw("inner"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := strings.Join(order, ","); got != "outer,inner,handler" {
+		t.Errorf("order %s", got)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		proxies []string
+		want    []string
+		err     bool
+	}{
+		{nil, nil, false},
+		{[]string{"10.0.0.1"}, []string{"10.0.0.1/32"}, false},
+		{[]string{"::1"}, []string{"::1/128"}, false},
+		{[]string{"10.0.0.0/8", "fd00::/8"}, []string{"10.0.0.0/8", "fd00::/8"}, false},
+		{[]string{"proxy.example"}, nil, true},
+		{[]string{"10.0.0.0/33"}, nil, true},
+	}
+	for _, tt := range tests {
+		nets, err := ParseTrustedProxies(tt.proxies)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseTrustedProxies(%q) error = %v, want error %v", tt.proxies, err, tt.err)
+			continue
+		}
+		var got []string
+		for _, ipNet := range nets {
+			got = append(got, ipNet.String())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("ParseTrustedProxies(%q) = %q, want %q", tt.proxies, got, tt.want)
+		}
+	}
+}
+
+// newProxiedServer creates a server trusting proxies in 10.0.0.0/8.
+func newProxiedServer(t *testing.T) *Server {
+	t.Helper()
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestServer(t, &Config{TrustedProxies: proxies})
+}
+
+func TestRequestInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string // X-Forwarded-For headers
+		id        string   // X-Request-Id header
+		ip        string
+		keepID    bool
+	}{
+		{name: "direct", remote: "192.0.2.1:1234", ip: "192.0.2.1"},
+		{name: "spoofed by client", remote: "192.0.2.1:1234", forwarded: []string{"198.51.100.1"}, id: "client-id", ip: "192.0.2.1"},
+		{name: "proxied", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1"}, id: "proxy-id", ip: "198.51.100.1", keepID: true},
+		{name: "spoofed through proxy", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9, 198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "proxy chain", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1, 10.0.0.2"}, ip: "198.51.100.1"},
+		{name: "multiple headers", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9", "198.51.100.1, 10.0.0.2"}, ip: "198.51.100.1"},
+		{name: "only proxies", remote: "10.0.0.1:1234", forwarded: []string{"10.0.0.3, 10.0.0.2"}, ip: "10.0.0.3"},
+		{name: "garbage hop", remote: "10.0.0.1:1234", forwarded: []string{"198.51.100.1, garbage"}, ip: "10.0.0.1"},
+		{name: "no header", remote: "10.0.0.1:1234", ip: "10.0.0.1"},
+		{name: "IPv6", remote: "[2001:db8::1]:1234", ip: "2001:db8::1"},
+		{name: "invalid request ID", remote: "10.0.0.1:1234", id: "id with spaces", ip: "10.0.0.1"},
+		{name: "long request ID", remote: "10.0.0.1:1234", id: strings.Repeat("a", maxRequestIDLen+1), ip: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newProxiedServer(t)
+			var ip, id string
+			h := s.withRequestInfo(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+				ip, id = clientIP(req), requestID(req)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, header := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+			if tt.id != "" {
+				req.Header.Set("X-Request-Id", tt.id)
+			}
+			wr := httptest.NewRecorder()
+			h.ServeHTTP(wr, req)
+
+			if ip != tt.ip {
+				t.Errorf("client IP %q, want %q", ip, tt.ip)
+			}
+			if kept := id == tt.id; kept != tt.keepID {
+				t.Errorf("request ID %q, want kept %v", id, tt.keepID)
+			}
+			if !validRequestID(id) || wr.Header().Get("X-Request-Id") != id {
+				t.Errorf("request ID %q, header %q", id, wr.Header().Get("X-Request-Id"))
+			}
+		})
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		page    bool // error page served
+	}{
+		{
+			name:    "no panic",
+			handler: func(wr http.ResponseWriter, req *http.Request) { wr.WriteHeader(http.StatusTeapot) },
+			status:  http.StatusTeapot,
+		},
+		{
+			name:    "panic",
+			handler: func(wr http.ResponseWriter, req *http.Request) { panic("boom") },
+			status:  http.StatusInternalServerError,
+			page:    true,
+		},
+		{
+			name: "panic after response started",
+			handler: func(wr http.ResponseWriter, req *http.Request) {
+				_, _ = wr.Write([]byte("partial"))
+				panic("boom")
+			},
+			status: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer log.SetOutput(log.Writer())
+			log.SetOutput(&bytes.Buffer{})
+
+			s := newTestServer(t, nil)
+			h := chain(tt.handler, s.withRequestInfo, s.recoverPanics)
+			wr := httptest.NewRecorder()
+			h.ServeHTTP(wr, httptest.NewRequest(http.MethodGet, "/", nil))
+			if wr.Code != tt.status {
+				t.Errorf("status %d, want %d", wr.Code, tt.status)
+			}
+			id := wr.Header().Get("X-Request-Id")
+			if page := strings.Contains(wr.Body.String(), id) && strings.Contains(wr.Body.String(), "Something went wrong"); page != tt.page {
+				t.Errorf("error page with request ID served = %v, want %v", page, tt.page)
+			}
+		})
+	}
+
+	// Aborted handlers are left to net/http
+	s := newTestServer(t, nil)
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", err)
+		}
+	}()
+	s.recoverPanics(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		hsts   bool
+	}{
+		{"HTTPS", Config{Origin: "https://panel.example"}, true},
+		{"HTTP", Config{Origin: "http://panel.example"}, false},
+		{"development", Config{Origin: "https://panel.example", Dev: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &tt.config)
+			wr := httptest.NewRecorder()
+			s.securityHeaders(http.NotFoundHandler()).ServeHTTP(wr, httptest.NewRequest(http.MethodGet, "/", nil))
+			h := wr.Header()
+			if h.Get("Content-Security-Policy") != contentSecurityPolicy || h.Get("X-Frame-Options") != "DENY" ||
+				h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Referrer-Policy") != "same-origin" {
+				t.Errorf("headers %v", h)
+			}
+			if hsts := h.Get("Strict-Transport-Security"); (hsts != "") != tt.hsts || (tt.hsts && hsts != "max-age=63072000") {
+				t.Errorf("Strict-Transport-Security %q, want set %v", hsts, tt.hsts)
+			}
+		})
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	s := newProxiedServer(t)
+	h := chain(http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		http.Error(wr, "gone", http.StatusGone)
+	}), s.withRequestInfo, logRequests)
+	req := httptest.NewRequest(http.MethodGet, "/redirect?code=secret&state=x", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Request-Id", "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := buf.String()
+	for _, want := range []string{`http: 198.51.100.1 "GET /redirect" 410 5B`, "id=abc"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log %q does not contain %q", line, want)
+		}
+	}
+	if strings.Contains(line, "secret") {
+		t.Errorf("log %q contains query string", line)
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		method   string
+		target   string
+		status   int
+		location string
+	}{
+		{http.MethodGet, "http://evil.example/tokens?x=1", http.StatusMovedPermanently, "https://panel.example/tokens?x=1"},
+		{http.MethodHead, "http://panel.example/", http.StatusMovedPermanently, "https://panel.example/"},
+		{http.MethodPost, "http://panel.example/login", http.StatusPermanentRedirect, "https://panel.example/login"},
+	}
+	for _, tt := range tests {
+		wr := httptest.NewRecorder()
+		RedirectHTTPS("https://panel.example").ServeHTTP(wr, httptest.NewRequest(tt.method, tt.target, nil))
+		if wr.Code != tt.status || wr.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s: %d %q, want %d %q", tt.method, tt.target, wr.Code, wr.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	wr := httptest.NewRecorder()
+	rec := &statusRecorder{ResponseWriter: wr}
+	rec.WriteHeader(http.StatusCreated)
+	rec.WriteHeader(http.StatusTeapot)
+	_, _ = rec.Write([]byte("hello"))
+	if rec.status != http.StatusCreated || rec.size != 5 {
+		t.Errorf("recorded %d %dB, want 201 5B", rec.status, rec.size)
+	}
+	if http.NewResponseController(rec).Flush() != nil || !wr.Flushed {
+		t.Error("flush does not reach the underlying writer")
+	}
+}
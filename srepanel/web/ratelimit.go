@@ -0,0 +1,82 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mkw.re/ghidra-panel/ratelimit"
+)
+
+// Rate limited routes.
+const (
+	LimitLogin         = "login"          // starting a login or account link
+	LimitPassword      = "password"       // Ghidra password changes
+	LimitAccessRequest = "access_request" // access requests sent to Discord
+	LimitSecondFactor  = "second_factor"  // TOTP and recovery code attempts
+)
+
+// DefaultRateLimits apply to routes without configured limits.
+var DefaultRateLimits = map[string]ratelimit.Rule{
+	LimitLogin:         {Every: 6 * time.Second, Burst: 10},
+	LimitPassword:      {Every: time.Minute, Burst: 5},
+	LimitAccessRequest: {Every: 10 * time.Minute, Burst: 3},
+	LimitSecondFactor:  {Every: 30 * time.Second, Burst: 5},
+}
+
+// newLimiters creates the limiters of all routes, overriding defaults with rules.
+func newLimiters(rules map[string]ratelimit.Rule) map[string]*ratelimit.Limiter {
+	limiters := make(map[string]*ratelimit.Limiter, len(DefaultRateLimits))
+	for route, rule := range DefaultRateLimits {
+		if r, ok := rules[route]; ok {
+			rule = r
+		}
+		limiters[route] = ratelimit.New(rule)
+	}
+	return limiters
+}
+
+// allowRequest applies the rate limit of a route to the client address
+// and, if authenticated, the user. Audits the first denial of a client.
+func (s *Server) allowRequest(req *http.Request, route string, userID uint64) (ok bool, retryAfter time.Duration) {
+	ip := clientIP(req)
+	keys := []string{"ip:" + ip}
+	if userID != 0 {
+		keys = append(keys, "user:"+strconv.FormatUint(userID, 10))
+	}
+	ok, retryAfter, first := s.limiters[route].Allow(keys...)
+	if !ok && first {
+		if err := s.DB.Audit(req.Context(), userID, "rate_limit", fmt.Sprintf("route=%s ip=%s", route, ip)); err != nil {
+			log.Print("Failed to write audit log: ", err)
+		}
+	}
+	return ok, retryAfter
+}
+
+func setRetryAfter(wr http.ResponseWriter, retryAfter time.Duration) {
+	wr.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
+// rateLimit applies the rate limit of a route to a page request.
+// Writes a 429 response if it is exceeded.
+func (s *Server) rateLimit(wr http.ResponseWriter, req *http.Request, route string, userID uint64) bool {
+	ok, retryAfter := s.allowRequest(req, route, userID)
+	if !ok {
+		setRetryAfter(wr, retryAfter)
+		http.Error(wr, "Too many requests, please try again later", http.StatusTooManyRequests)
+	}
+	return ok
+}
+
+// apiRateLimit applies the rate limit of a route to an API request.
+func (s *Server) apiRateLimit(wr http.ResponseWriter, req *http.Request, route string, userID uint64) bool {
+	ok, retryAfter := s.allowRequest(req, route, userID)
+	if !ok {
+		setRetryAfter(wr, retryAfter)
+		writeJSONError(wr, http.StatusTooManyRequests, "too many requests")
+	}
+	return ok
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/ratelimit"
+)
+
+func TestSetRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       string
+	}{
+		{0, "0"},
+		{time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{90 * time.Second, "90"},
+	}
+	for _, tt := range tests {
+		wr := httptest.NewRecorder()
+		setRetryAfter(wr, tt.retryAfter)
+		if got := wr.Header().Get("Retry-After"); got != tt.want {
+			t.Errorf("setRetryAfter(%v) = %q, want %q", tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit func(s *Server, wr http.ResponseWriter, req *http.Request) bool
+		json  bool
+	}{
+		{
+			name: "page",
+			limit: func(s *Server, wr http.ResponseWriter, req *http.Request) bool {
+				return s.rateLimit(wr, req, LimitPassword, 1)
+			},
+		},
+		{
+			name: "API",
+			limit: func(s *Server, wr http.ResponseWriter, req *http.Request) bool {
+				return s.apiRateLimit(wr, req, LimitPassword, 1)
+			},
+			json: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &Config{RateLimits: map[string]ratelimit.Rule{
+				LimitPassword: {Every: 90 * time.Second, Burst: 2},
+			}})
+			request := func(ip string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/update_password", nil)
+				req.RemoteAddr = ip + ":1234"
+				wr := httptest.NewRecorder()
+				if tt.limit(s, wr, req) != (wr.Code == http.StatusOK) {
+					t.Fatalf("limited with status %d", wr.Code)
+				}
+				return wr
+			}
+
+			// The user is limited across addresses
+			for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+				if wr := request(ip); wr.Code != http.StatusOK {
+					t.Fatalf("request from %s limited", ip)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				wr := request("192.0.2.3")
+				if wr.Code != http.StatusTooManyRequests {
+					t.Fatalf("status %d, want %d", wr.Code, http.StatusTooManyRequests)
+				}
+				if retryAfter := wr.Header().Get("Retry-After"); retryAfter != "90" {
+					t.Errorf("Retry-After %q, want 90", retryAfter)
+				}
+				if isJSON := strings.HasPrefix(wr.Header().Get("Content-Type"), "application/json"); isJSON != tt.json {
+					t.Errorf("Content-Type %q", wr.Header().Get("Content-Type"))
+				}
+			}
+
+			// Only the first denial is audited
+			var audited int
+			err := s.DB.QueryRowContext(context.Background(),
+				`SELECT COUNT(*) FROM audit_log WHERE action = 'rate_limit' AND detail = 'route=password ip=192.0.2.3'`).
+				Scan(&audited)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if audited != 1 {
+				t.Errorf("%d denials audited, want 1", audited)
+			}
+		})
+	}
+}
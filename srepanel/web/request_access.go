@@ -1,10 +1,8 @@
 package web
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 
@@ -23,6 +21,9 @@ func (s *Server) handleRequestAccess(wr http.ResponseWriter, req *http.Request)
 		http.Error(wr, "Not authorized", http.StatusUnauthorized)
 		return
 	}
+	if !s.rateLimit(wr, req, LimitAccessRequest, ident.ID) {
+		return
+	}
 
 	if err := req.ParseForm(); err != nil {
 		http.Error(wr, "Bad request", http.StatusBadRequest)
@@ -34,22 +35,11 @@ func (s *Server) handleRequestAccess(wr http.ResponseWriter, req *http.Request)
 	message := s.writeMessage(ident)
 
 	// Send access request message
-	ctx := context.TODO()
-	payloadBuf, _ := json.Marshal(&message)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Config.DiscordWebhookURL, bytes.NewReader(payloadBuf))
-	if err != nil {
-		http.Error(wr, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	req.Header.Set("content-type", "application/json")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		// TODO properly handle
+	if err := s.sendWebhook(req.Context(), &message); err != nil {
+		log.Print("Failed to send access request: ", err)
 		http.Redirect(wr, req, "/?access_request=failure", http.StatusTemporaryRedirect)
 		return
 	}
-	defer res.Body.Close()
 
 	http.Redirect(wr, req, "/?access_request=success", http.StatusTemporaryRedirect)
 }
@@ -57,18 +47,18 @@ func (s *Server) handleRequestAccess(wr http.ResponseWriter, req *http.Request)
 func (s *Server) writeMessage(ident *common.Identity) discord.WebhookMessage {
 	embedAuthor := discord.EmbedAuthor{
 		Name:    ident.Username,
-		IconURL: fmt.Sprintf("https://cdn.discordapp.com/avatars/%d/%s.png", ident.ID, ident.AvatarHash),
+		IconURL: avatarURL(ident),
 	}
 
 	hostnameField := discord.EmbedField{
 		Name:   "Hostname",
-		Value:  s.Config.GhidraEndpoint.Hostname,
+		Value:  s.Config().GhidraEndpoint.Hostname,
 		Inline: true,
 	}
 
 	portField := discord.EmbedField{
 		Name:   "Port",
-		Value:  strconv.FormatUint(uint64(s.Config.GhidraEndpoint.Port), 10),
+		Value:  strconv.FormatUint(uint64(s.Config().GhidraEndpoint.Port), 10),
 		Inline: true,
 	}
 
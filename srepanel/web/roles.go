@@ -0,0 +1,104 @@
+package web
+
+import (
+	"context"
+	"log"
+	"path"
+
+	"go.mkw.re/ghidra-panel/ghidra"
+)
+
+// RoleRule grants a repo permission to holders of a Discord guild role.
+type RoleRule struct {
+	Guild string `json:"guild"`
+	Role  string `json:"role"`
+	Perm  string `json:"perm"`  // READ_ONLY, WRITE, or ADMIN
+	Repos string `json:"repos"` // glob pattern matched against repo names
+}
+
+// RoleGuilds returns the guilds referenced by role rules.
+func RoleGuilds(rules []RoleRule) []string {
+	var guilds []string
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if !seen[rule.Guild] {
+			seen[rule.Guild] = true
+			guilds = append(guilds, rule.Guild)
+		}
+	}
+	return guilds
+}
+
+// rolePerms returns the highest permission per repo granted by role rules.
+func (s *Server) rolePerms(acls *ghidra.ACLState, guildRoles map[string][]string) map[string]int {
+	want := make(map[string]int)
+	for _, rule := range s.Config().RoleRules {
+		if !hasRole(guildRoles[rule.Guild], rule.Role) {
+			continue
+		}
+		perm, ok := ghidra.ParsePerm(rule.Perm)
+		if !ok {
+			continue
+		}
+		for repo := range acls.ACLs {
+			if match, _ := path.Match(rule.Repos, repo); !match {
+				continue
+			}
+			if current, ok := want[repo]; !ok || perm > current {
+				want[repo] = perm
+			}
+		}
+	}
+	return want
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// syncRoles reconciles the ACL entries of a user with their Discord roles.
+// Failures are logged but do not prevent login.
+func (s *Server) syncRoles(ctx context.Context, userID uint64, username string, guildRoles map[string][]string) {
+	if len(s.Config().RoleRules) == 0 || guildRoles == nil {
+		return
+	}
+	acls := s.ACLs.Get()
+	if acls == nil {
+		return
+	}
+
+	managed, err := s.DB.GetManagedGrants(ctx, userID)
+	if err != nil {
+		log.Print("Failed to get managed grants: ", err)
+		return
+	}
+
+	dryRun := s.Config().RoleSyncDryRun
+	want := s.rolePerms(acls, guildRoles)
+	changes, newManaged, err := s.ACLs.Reconcile(username, want, managed, dryRun)
+
+	action := "acl.role_sync"
+	if dryRun {
+		action = "acl.role_sync.dry_run"
+	}
+	for _, change := range changes {
+		if err := s.DB.Audit(ctx, userID, action, change.String()); err != nil {
+			log.Print("Failed to write audit log: ", err)
+		}
+	}
+	if err != nil {
+		log.Print("Failed to sync roles: ", err)
+	}
+	if dryRun {
+		return
+	}
+	// Also after errors, to track the grants that were written
+	if err := s.DB.SetManagedGrants(ctx, userID, newManaged); err != nil {
+		log.Print("Failed to store managed grants: ", err)
+	}
+}
@@ -1,14 +1,24 @@
 package web
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"html/template"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/csrf"
 	"go.mkw.re/ghidra-panel/database"
-	"go.mkw.re/ghidra-panel/discord"
 	"go.mkw.re/ghidra-panel/ghidra"
+	"go.mkw.re/ghidra-panel/metrics"
+	"go.mkw.re/ghidra-panel/probe"
+	"go.mkw.re/ghidra-panel/ratelimit"
 	"go.mkw.re/ghidra-panel/token"
 )
 
@@ -21,8 +31,15 @@ var (
 )
 
 var (
-	homePage  *template.Template
-	loginPage *template.Template
+	homePage       *template.Template
+	loginPage      *template.Template
+	deniedPage     *template.Template
+	sessionsPage   *template.Template
+	adminPage      *template.Template
+	tokensPage     *template.Template
+	totpPage       *template.Template
+	totpVerifyPage *template.Template
+	errorPage      *template.Template
 )
 
 func init() {
@@ -32,61 +49,224 @@ func init() {
 	}
 	homePage = templates.Lookup("home.gohtml")
 	loginPage = templates.Lookup("login.gohtml")
+	deniedPage = templates.Lookup("denied.gohtml")
+	sessionsPage = templates.Lookup("sessions.gohtml")
+	adminPage = templates.Lookup("admin.gohtml")
+	tokensPage = templates.Lookup("tokens.gohtml")
+	totpPage = templates.Lookup("totp.gohtml")
+	totpVerifyPage = templates.Lookup("totp_verify.gohtml")
+	errorPage = templates.Lookup("error.gohtml")
 }
 
 type Config struct {
+	Origin            string   // origin of the panel, e.g. "https://panel.example.org"
+	CSRFKeys          [][]byte // keys of form synchronizer tokens, random if empty
 	GhidraEndpoint    *common.GhidraEndpoint
 	Links             []common.Link
 	DiscordWebhookURL string
+	GuildInviteURL    string // shown to users outside the required guilds
+	RoleRules         []RoleRule
+	RoleSyncDryRun    bool // only audit changes role rules would make
 	Dev               bool // developer mode
+
+	// TrustedProxies are reverse proxies whose X-Forwarded-For and
+	// X-Request-Id headers are trusted.
+	TrustedProxies []*net.IPNet
+	// RateLimits override DefaultRateLimits per route.
+	RateLimits map[string]ratelimit.Rule
+
+	// ACLMaxAge is how old the ACLs may get before /readyz fails,
+	// DefaultACLMaxAge if zero.
+	ACLMaxAge time.Duration
+	// ServeMetrics mounts the metrics at /metrics of the panel.
+	// Otherwise they are only served where Server.Metrics is mounted.
+	ServeMetrics bool
+
+	// RotateSecrets adds a new signing key to the secrets file, starts
+	// using it and returns its ID.
+	// Rotation from the admin page is unavailable if nil.
+	RotateSecrets func() (keyID string, err error)
+}
+
+// IdentityProvider authenticates users through an external OAuth 2.0 service.
+type IdentityProvider interface {
+	// ID returns the stable identifier of the provider, e.g. "discord".
+	ID() string
+	// Name returns the human-readable name of the provider, e.g. "Discord".
+	Name() string
+	// AuthURL returns the authorization URL that starts a new login,
+	// setting cookies that bind the login to the browser.
+	// The return path is passed back by HandleRedirect.
+	AuthURL(wr http.ResponseWriter, returnTo string) string
+	// HandleRedirect handles the redirect back from the provider.
+	// Returns a nil identity without error if a response was already written.
+	HandleRedirect(wr http.ResponseWriter, req *http.Request) (ident *common.Identity, returnTo string, err error)
 }
 
 type Server struct {
-	Config *Config
-	DB     *database.DB
-	Auth   *discord.Auth
-	Issuer *token.Issuer
-	ACLs   *ghidra.ACLMon
+	DB        *database.DB
+	Providers []IdentityProvider
+	ACLs      *ghidra.ACLMon
+	CSRF      *csrf.Synchronizer
+	Metrics   *metrics.Registry
+	Prober    *probe.Prober // Ghidra server status, unknown if nil
+
+	config   atomic.Pointer[Config]
+	issuer   atomic.Pointer[token.Issuer]
+	touched  sync.Map // session ID => last time seen
+	limiters map[string]*ratelimit.Limiter
+	metrics  *serverMetrics
 }
 
 func NewServer(
 	config *Config,
 	db *database.DB,
-	auth *discord.Auth,
+	providers []IdentityProvider,
 	issuer *token.Issuer,
 	acls *ghidra.ACLMon,
 ) (*Server, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("no identity providers configured")
+	}
 	server := &Server{
-		Config: config,
-		DB:     db,
-		Auth:   auth,
-		Issuer: issuer,
-		ACLs:   acls,
+		DB:        db,
+		Providers: providers,
+		ACLs:      acls,
+		CSRF:      csrf.NewSynchronizer(config.CSRFKeys),
+		Metrics:   metrics.NewRegistry(),
+		limiters:  newLimiters(config.RateLimits),
 	}
+	server.config.Store(config)
+	server.issuer.Store(issuer)
+	server.metrics = server.newMetrics()
 	return server, nil
 }
 
+// Config returns the configuration in use.
+func (s *Server) Config() *Config {
+	return s.config.Load()
+}
+
+// SetConfig replaces the configuration at runtime. Origin, CSRFKeys,
+// RateLimits and ServeMetrics only take effect in a new Server,
+// CSRFKeys can be replaced with SetKeys.
+func (s *Server) SetConfig(config *Config) {
+	s.config.Store(config)
+}
+
+// Issuer returns the issuer of session tokens.
+func (s *Server) Issuer() *token.Issuer {
+	return s.issuer.Load()
+}
+
+// SetKeys replaces the keys of session tokens and of form synchronizer
+// tokens at runtime, e.g. after rotating secrets.
+// Sessions signed with keys no longer given become invalid.
+func (s *Server) SetKeys(keys token.Keyring, csrfKeys [][]byte) {
+	issuer := *s.Issuer()
+	issuer.Keys = keys
+	s.issuer.Store(&issuer)
+	s.CSRF.SetKeys(csrfKeys)
+}
+
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/", s.handleHome)
-	mux.HandleFunc("/login", s.handleLogin)
-	mux.HandleFunc("/redirect", s.handleOAuthRedirect)
-	mux.HandleFunc("/logout", s.handleLogout)
+	routes := http.NewServeMux()
+	routes.HandleFunc("/", s.handleHome)
+	routes.HandleFunc("/login", s.handleLogin)
+	routes.HandleFunc("/redirect", s.handleOAuthRedirect)
+	routes.HandleFunc("/redirect/", s.handleOAuthRedirect)
+	routes.HandleFunc("/logout", s.handleLogout)
+	routes.HandleFunc("/link", s.handleLink)
+	routes.HandleFunc("/unlink", s.handleUnlink)
+
+	routes.HandleFunc("/sessions", s.handleSessions)
+	routes.HandleFunc("/tokens", s.handleTokens)
+	routes.HandleFunc("/tokens/revoke", s.handleRevokeToken)
+	routes.HandleFunc("/totp", s.handleTOTP)
+	routes.HandleFunc("/totp/verify", s.handleTOTPVerify)
+	routes.HandleFunc("/admin", s.handleAdmin)
+	routes.HandleFunc("/admin/revoke_sessions", s.handleAdminRevokeSessions)
+	routes.HandleFunc("/admin/acl", s.handleAdminACL)
+	routes.HandleFunc("/admin/roles", s.handleAdminRoles)
+	routes.HandleFunc("/admin/rotate_secrets", s.handleAdminRotateSecrets)
+
+	routes.HandleFunc("/healthz", s.handleHealthz)
+	routes.HandleFunc("/readyz", s.handleReadyz)
 
-	mux.HandleFunc("/update_password", s.handleUpdatePassword)
-	mux.HandleFunc("/request_access", s.handleRequestAccess)
+	routes.HandleFunc("/update_password", s.handleUpdatePassword)
+	routes.HandleFunc("/request_access", s.handleRequestAccess)
+
+	routes.HandleFunc("/api/v1/", s.handleAPINotFound)
+	routes.HandleFunc("/api/v1/openapi.json", s.handleAPIOpenAPI)
+	routes.HandleFunc("/api/v1/me", s.handleAPIMe)
+	routes.HandleFunc("/api/v1/me/state", s.handleAPIState)
+	routes.HandleFunc("/api/v1/me/access", s.handleAPIAccess)
+	routes.HandleFunc("/api/v1/me/password", s.handleAPIPassword)
+	routes.HandleFunc("/api/v1/me/access_requests", s.handleAPIAccessRequests)
+	routes.HandleFunc("/api/v1/admin/repos", s.handleAPIAdminRepos)
+	routes.HandleFunc("/api/v1/admin/repos/", s.handleAPIAdminRepoUser)
 
 	// Create file server for assets
-	mux.Handle("/assets/", http.FileServer(http.FS(assets)))
+	routes.Handle("/assets/", http.FileServer(http.FS(assets)))
+	if s.Config().ServeMetrics {
+		routes.Handle("/metrics", s.Metrics)
+	}
+
+	mux.Handle("/", chain(routes,
+		s.withRequestInfo,
+		logRequests,
+		s.measureRequests(routes),
+		s.recoverPanics,
+		s.securityHeaders,
+		s.renewSessions,
+		s.checkCSRF,
+	))
 }
 
 // State holds server-side web page state.
 type State struct {
-	Identity  *common.Identity // current user, null if unauthenticated
-	UserState *common.UserState
-	Nav       []Nav         // navigation bar
-	Links     []common.Link // footer links
-	Ghidra    *common.GhidraEndpoint
-	ACL       []common.UserRepoAccess
+	Identity   *common.Identity // current user, null if unauthenticated
+	SessionID  string           // current session
+	CSRFToken  string           // synchronizer token of the current session
+	UserState  *common.UserState
+	Providers  []ProviderInfo  // identity providers available for login
+	Identities []LinkedAccount // identities linked to current user
+	Linkable   []ProviderInfo  // providers not yet linked to current user
+	Notice     string          // result of the last action
+	Denied     string          // reason login was refused
+	InviteURL  string          // guild invite for users refused login
+	ReturnTo   string          // path to return to after login
+	Nav        []Nav           // navigation bar
+	Links      []common.Link   // footer links
+	Ghidra     *common.GhidraEndpoint
+	ACL        []common.UserRepoAccess
+	Sessions   []common.Session
+	APITokens  []common.APIToken
+	APIScopes  []apiScope
+	NewToken   string // secret of a just created API token, shown once
+	RequestID  string // shown on error pages
+	Repos      []string
+	CanRotate  bool // secrets can be rotated from the admin page
+
+	TOTPSetup         *totpSetup // authenticator enrollment in progress
+	RecoveryCodes     []string   // just generated recovery codes, shown once
+	RecoveryCodesLeft int
+
+	GhidraStatus *probe.Status // nil if unknown
+
+	claims *token.Claims // current session
+}
+
+// ProviderInfo describes an identity provider.
+type ProviderInfo struct {
+	ID   string
+	Name string
+}
+
+// LinkedAccount is an identity linked to the current user.
+type LinkedAccount struct {
+	common.LinkedIdentity
+	ProviderName string
 }
 
 type Nav struct {
@@ -95,27 +275,35 @@ type Nav struct {
 }
 
 func (s *Server) stateWithNav(nav ...Nav) *State {
+	providers := make([]ProviderInfo, len(s.Providers))
+	for i, p := range s.Providers {
+		providers[i] = ProviderInfo{ID: p.ID(), Name: p.Name()}
+	}
 	return &State{
-		Ghidra: s.Config.GhidraEndpoint,
-		Nav:    nav,
-		Links:  s.Config.Links,
+		Providers: providers,
+		Ghidra:    s.Config().GhidraEndpoint,
+		Nav:       nav,
+		Links:     s.Config().Links,
 	}
 }
 
 func (s *Server) authenticateState(wr http.ResponseWriter, req *http.Request, state *State) bool {
-	ident, ok := s.checkAuth(req)
+	claims, ok := s.checkSession(req)
 	if !ok {
-		http.SetCookie(wr, &http.Cookie{
-			Name:   "token",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
-		http.Redirect(wr, req, "/login", http.StatusTemporaryRedirect)
+		clearCookie(wr, tokenCookie, "/")
+		loginURL := "/login"
+		if req.Method == http.MethodGet && req.URL.Path != "/" {
+			loginURL += "?return_to=" + url.QueryEscape(req.URL.RequestURI())
+		}
+		http.Redirect(wr, req, loginURL, http.StatusTemporaryRedirect)
 		return false
 	}
 
+	ident := claims.Identity()
 	state.Identity = ident
+	state.SessionID = claims.Jti
+	state.claims = claims
+	state.CSRFToken = s.CSRF.Token(claims.Jti)
 
 	userState, err := s.DB.GetUserState(req.Context(), ident.ID)
 	if err != nil {
@@ -124,14 +312,65 @@ func (s *Server) authenticateState(wr http.ResponseWriter, req *http.Request, st
 	}
 	state.UserState = userState
 
-	acl := s.ACLs.Get().QueryUser(ident.Username)
-	state.ACL = make([]common.UserRepoAccess, len(acl))
+	ghidraUsername, err := s.ghidraUsername(req.Context(), ident, userState.GhidraUsername)
+	if err != nil {
+		http.Error(wr, "failed to get Ghidra account, please contact server admin", http.StatusInternalServerError)
+		return false
+	}
+	idents, err := s.DB.ListIdentities(req.Context(), ident.ID)
+	if err != nil {
+		http.Error(wr, "failed to get linked accounts, please contact server admin", http.StatusInternalServerError)
+		return false
+	}
+	linked := make(map[string]bool)
+	for _, linkedIdent := range idents {
+		linked[linkedIdent.Provider] = true
+		account := LinkedAccount{LinkedIdentity: linkedIdent, ProviderName: linkedIdent.Provider}
+		if p := s.provider(linkedIdent.Provider); p != nil {
+			account.ProviderName = p.Name()
+		}
+		state.Identities = append(state.Identities, account)
+	}
+	for _, p := range state.Providers {
+		if !linked[p.ID] {
+			state.Linkable = append(state.Linkable, p)
+		}
+	}
+
+	state.ACL = s.userACL(ghidraUsername)
+
+	return true
+}
+
+// ghidraUsername returns the Ghidra account of a user: the one they set
+// a password for, or else their username unless another user owns it.
+// Returns an empty string if the user has no Ghidra account to speak of.
+func (s *Server) ghidraUsername(ctx context.Context, ident *common.Identity, owned string) (string, error) {
+	if owned != "" {
+		return owned, nil
+	}
+	if ident.Username == "" {
+		return "", nil
+	}
+	available, err := s.DB.UsernameAvailable(ctx, ident.ID, ident.Username)
+	if err != nil || !available {
+		return "", err
+	}
+	return ident.Username, nil
+}
+
+// userACL returns the repository access of a Ghidra user.
+func (s *Server) userACL(ghidraUsername string) []common.UserRepoAccess {
+	if ghidraUsername == "" {
+		return []common.UserRepoAccess{}
+	}
+	acl := s.ACLs.Get().QueryUser(ghidraUsername)
+	access := make([]common.UserRepoAccess, len(acl))
 	for i, v := range acl {
-		state.ACL[i] = common.UserRepoAccess{
+		access[i] = common.UserRepoAccess{
 			Repo: v.Repo,
 			Perm: ghidra.PermStrs[v.Perm],
 		}
 	}
-
-	return true
+	return access
 }
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/ghidra"
+	"go.mkw.re/ghidra-panel/token"
+)
+
+// testProvider is an identity provider that logs in carol,
+// passing the return path through the state unprotected.
+type testProvider struct{}
+
+func (testProvider) ID() string   { return "test" }
+func (testProvider) Name() string { return "Test" }
+func (testProvider) AuthURL(_ http.ResponseWriter, returnTo string) string {
+	return "https://idp.example/authorize?state=" + url.QueryEscape(returnTo)
+}
+func (testProvider) HandleRedirect(_ http.ResponseWriter, req *http.Request) (*common.Identity, string, error) {
+	ident := &common.Identity{Provider: "test", Subject: "carol", Username: "test-carol"}
+	return ident, req.URL.Query().Get("state"), nil
+}
+
+// newTestServer creates a server backed by a temporary database.
+// The ACLs grant bob read access to the "re" repo.
+func newTestServer(t *testing.T, config *Config) *Server {
+	t.Helper()
+	db, err := database.Open(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	acls := ghidra.NewACLState()
+	acls.Add("re", &ghidra.ACL{Users: map[string]int{"bob": ghidra.PermRead}})
+	aclMon := &ghidra.ACLMon{}
+	aclMon.ACLs.Store(acls)
+
+	issuer := token.NewIssuer(token.Keyring{token.NewKey()}, "https://panel.example", time.Hour, time.Hour)
+	if config == nil {
+		config = &Config{}
+	}
+	s, err := NewServer(config, db, []IdentityProvider{testProvider{}}, &issuer, aclMon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestGhidraUsername(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t, nil)
+	// Discord user bob owns the Ghidra account bob
+	if err := s.DB.SetPassword(ctx, 1, "bob", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		ident common.Identity
+		owned string
+		want  string
+		repos int
+	}{
+		{"owner", common.Identity{ID: 1, Username: "bob"}, "bob", "bob", 1},
+		{"owner before login", common.Identity{ID: 1, Username: "bob"}, "", "bob", 1},
+		{"renamed owner", common.Identity{ID: 1, Username: "bobby"}, "bob", "bob", 1},
+		{"namespaced OIDC user", common.Identity{ID: 2, Provider: "corp", Username: "corp-bob"}, "", "corp-bob", 0},
+		{"username owned by other user", common.Identity{ID: 3, Username: "bob"}, "", "", 0},
+		{"no username", common.Identity{ID: 4}, "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ghidraUsername(ctx, &tt.ident, tt.owned)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ghidraUsername() = %q, want %q", got, tt.want)
+			}
+			if acl := s.userACL(got); len(acl) != tt.repos {
+				t.Errorf("userACL(%q) = %v, want %d repos", got, acl, tt.repos)
+			}
+		})
+	}
+}
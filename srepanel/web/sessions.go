@@ -0,0 +1,166 @@
+package web
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/token"
+)
+
+// sessionTouchInterval limits how often the last-seen time of a session is written.
+const sessionTouchInterval = 5 * time.Minute
+
+// maxDeviceLen truncates user agents stored with sessions.
+const maxDeviceLen = 200
+
+// tokenCookie holds the session token.
+const tokenCookie = "token"
+
+// startSession issues a session token for the identity and sets it as cookie.
+func (s *Server) startSession(wr http.ResponseWriter, req *http.Request, ident *common.Identity) error {
+	jwt, claims := s.Issuer().Issue(ident)
+
+	device := req.UserAgent()
+	if len(device) > maxDeviceLen {
+		device = device[:maxDeviceLen]
+	}
+	now := time.Now()
+	sess := &common.Session{
+		ID:         claims.Jti,
+		Device:     device,
+		IP:         clientIP(req),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  s.Issuer().Deadline(claims),
+	}
+	if err := s.DB.CreateSession(req.Context(), ident.ID, sess); err != nil {
+		return err
+	}
+	s.touched.Store(sess.ID, now)
+
+	setTokenCookie(wr, jwt, claims)
+	return nil
+}
+
+func setTokenCookie(wr http.ResponseWriter, jwt string, claims *token.Claims) {
+	setCookie(wr, &http.Cookie{
+		Name:    tokenCookie,
+		Value:   jwt,
+		Path:    "/",
+		Expires: time.Unix(claims.Exp, 0),
+	})
+}
+
+// renewSessions transparently re-issues session tokens past half of their idle window.
+func (s *Server) renewSessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(wr http.ResponseWriter, req *http.Request) {
+		if cookie, err := req.Cookie(tokenCookie); err == nil {
+			if claims, ok := s.Issuer().VerifyClaims(cookie.Value); ok && s.Issuer().NeedsRenewal(claims) {
+				jwt, renewed := s.Issuer().Renew(claims)
+				setTokenCookie(wr, jwt, renewed)
+			}
+		}
+		next.ServeHTTP(wr, req)
+	})
+}
+
+// checkSession verifies the session token of a request.
+func (s *Server) checkSession(req *http.Request) (*token.Claims, bool) {
+	cookie, err := req.Cookie(tokenCookie)
+	if err != nil || cookie == nil {
+		return nil, false
+	}
+	claims, ok := s.Issuer().VerifyClaims(cookie.Value)
+	if !ok {
+		return nil, false
+	}
+	// Sessions of disabled users are no longer valid
+	disabled, err := s.DB.IsDisabled(req.Context(), claims.Sub)
+	if err != nil {
+		log.Print("Failed to check user status: ", err)
+		return nil, false
+	}
+	if disabled {
+		return nil, false
+	}
+	s.touchSession(req, claims.Jti)
+	return claims, true
+}
+
+// touchSession records that a session was seen, at most every sessionTouchInterval.
+func (s *Server) touchSession(req *http.Request, id string) {
+	now := time.Now()
+	if last, ok := s.touched.Load(id); ok && now.Sub(last.(time.Time)) < sessionTouchInterval {
+		return
+	}
+	s.touched.Store(id, now)
+	if err := s.DB.TouchSession(req.Context(), id, clientIP(req), now); err != nil {
+		log.Print("Failed to update session: ", err)
+	}
+}
+
+// revokeSessions revokes sessions in the database and the token verifier.
+// If ids is empty, all sessions of the user except keep are revoked.
+func (s *Server) revokeSessions(ctx context.Context, userID uint64, ids []string, keep string) error {
+	revoked, err := s.DB.RevokeSessions(ctx, userID, ids, keep)
+	for _, sess := range revoked {
+		s.Issuer().Revoked.Add(sess.ID, sess.ExpiresAt)
+		s.touched.Delete(sess.ID)
+	}
+	if err != nil {
+		log.Print("Failed to revoke sessions: ", err)
+	}
+	return err
+}
+
+func (s *Server) handleSessions(wr http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		state := s.stateWithNav(
+			Nav{Route: "/", Name: "Ghidra"},
+			Nav{Route: "/sessions", Name: "Sessions"},
+		)
+		if !s.authenticateState(wr, req, state) {
+			return
+		}
+		sessions, err := s.DB.ListSessions(req.Context(), state.Identity.ID)
+		if err != nil {
+			log.Print("Failed to list sessions: ", err)
+			http.Error(wr, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == state.SessionID
+		}
+		state.Sessions = sessions
+		state.Notice = homeNotice(req)
+		if err := sessionsPage.Execute(wr, state); err != nil {
+			log.Print("failed to serve sessions: ", err)
+		}
+	case http.MethodPost:
+		claims, ok := s.checkSession(req)
+		if !ok {
+			http.Error(wr, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			http.Error(wr, "Bad request", http.StatusBadRequest)
+			return
+		}
+		// Revoke either a single session or all other sessions
+		var ids []string
+		if id := req.PostForm.Get("session"); id != "" {
+			ids = []string{id}
+		}
+		if err := s.revokeSessions(req.Context(), claims.Sub, ids, claims.Jti); err != nil {
+			http.Error(wr, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(wr, req, "/sessions?sessions=revoked", http.StatusSeeOther)
+	default:
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,87 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mkw.re/ghidra-panel/common"
+)
+
+// login starts a session for the identity and returns its cookie.
+func login(t *testing.T, s *Server, ident *common.Identity) *http.Cookie {
+	t.Helper()
+	wr := httptest.NewRecorder()
+	if err := s.startSession(wr, httptest.NewRequest(http.MethodPost, "/login", nil), ident); err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range wr.Result().Cookies() {
+		if cookie.Name == tokenCookie {
+			return cookie
+		}
+	}
+	t.Fatal("no session cookie set")
+	return nil
+}
+
+func TestSessionRevocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(s *Server, current, other string) error
+		valid  [2]bool // current and other session after revocation
+	}{
+		{
+			name:   "none",
+			revoke: func(s *Server, current, other string) error { return nil },
+			valid:  [2]bool{true, true},
+		},
+		{
+			name: "one session",
+			revoke: func(s *Server, current, other string) error {
+				return s.revokeSessions(context.Background(), 1, []string{other}, "")
+			},
+			valid: [2]bool{true, false},
+		},
+		{
+			name: "all other sessions",
+			revoke: func(s *Server, current, other string) error {
+				return s.revokeSessions(context.Background(), 1, nil, current)
+			},
+			valid: [2]bool{true, false},
+		},
+		{
+			name: "disabled user",
+			revoke: func(s *Server, current, other string) error {
+				return s.DB.DisableUser(context.Background(), 1)
+			},
+			valid: [2]bool{false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			ident := &common.Identity{ID: 1, Username: "bob"}
+			cookies := [2]*http.Cookie{login(t, s, ident), login(t, s, ident)}
+			var ids [2]string
+			for i, cookie := range cookies {
+				claims, ok := s.Issuer().VerifyClaims(cookie.Value)
+				if !ok {
+					t.Fatal("session token does not verify")
+				}
+				ids[i] = claims.Jti
+			}
+
+			if err := tt.revoke(s, ids[0], ids[1]); err != nil {
+				t.Fatal(err)
+			}
+			for i, cookie := range cookies {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(cookie)
+				if _, ok := s.checkSession(req); ok != tt.valid[i] {
+					t.Errorf("session %d valid = %v, want %v", i, ok, tt.valid[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mkw.re/ghidra-panel/qr"
+	"go.mkw.re/ghidra-panel/token"
+	"go.mkw.re/ghidra-panel/totp"
+)
+
+const (
+	// stepUpValidity is how long a second factor verification unlocks sensitive actions.
+	stepUpValidity = 10 * time.Minute
+	// recoveryCodeCount is how many recovery codes are issued at a time.
+	recoveryCodeCount = 10
+	// totpHeader carries the second factor of API requests that need one.
+	totpHeader = "X-TOTP-Code"
+)
+
+// totpSetup is shown while enrolling an authenticator app.
+type totpSetup struct {
+	QR     template.HTML // QR code of the otpauth URI as SVG
+	Secret string        // secret for manual entry
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes generates recovery codes and their hashes for storage.
+func newRecoveryCodes() (codes []string, hashes [][]byte) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		var buf [10]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			panic("crypto rand read failed: " + err.Error())
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(buf[:]))
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode hashes a recovery code for storage, ignoring case and separators.
+// Codes carry 80 random bits, so a fast hash suffices.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
+
+// isTOTPCode returns whether a code looks like a TOTP code rather than a recovery code.
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// verifySecondFactor checks a TOTP code or an unused recovery code of a user.
+// Each code is accepted only once.
+func (s *Server) verifySecondFactor(ctx context.Context, userID uint64, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if code == "" {
+		return false, nil
+	}
+	if isTOTPCode(code) {
+		secret, enabled, err := s.DB.GetTOTP(ctx, userID)
+		if err != nil || !enabled {
+			return false, err
+		}
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return s.DB.UseTOTPStep(ctx, userID, step)
+	}
+	used, err := s.DB.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if err != nil || !used {
+		return false, err
+	}
+	if err := s.DB.Audit(ctx, userID, "totp.recovery_code_used", ""); err != nil {
+		log.Print("Failed to write audit log: ", err)
+	}
+	return true, nil
+}
+
+// stepUpSession records a second factor verification in the session token.
+func (s *Server) stepUpSession(wr http.ResponseWriter, claims *token.Claims) {
+	jwt, renewed := s.Issuer().StepUp(claims)
+	setTokenCookie(wr, jwt, renewed)
+}
+
+// redirectStepUp sends the user to verify a second factor,
+// or to enroll one if they have not yet.
+func redirectStepUp(wr http.ResponseWriter, req *http.Request, enrolled bool, returnTo string) {
+	if !enrolled {
+		http.Redirect(wr, req, "/totp?totp=required", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(wr, req, "/totp/verify?return_to="+url.QueryEscape(returnTo), http.StatusSeeOther)
+}
+
+// totpIssuer names the panel in authenticator apps.
+func (s *Server) totpIssuer() string {
+	if parsed, err := url.Parse(s.Config().Origin); err == nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return "Ghidra Panel"
+}
+
+func (s *Server) newTOTPSetup(account string, secret []byte) (*totpSetup, error) {
+	code, err := qr.Encode(totp.URI(s.totpIssuer(), account, secret))
+	if err != nil {
+		return nil, err
+	}
+	return &totpSetup{
+		QR:     template.HTML(code.SVG(240)),
+		Secret: totp.EncodeSecret(secret),
+	}, nil
+}
+
+func (s *Server) handleTOTP(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := s.stateWithNav(
+		Nav{Route: "/", Name: "Ghidra"},
+		Nav{Route: "/totp", Name: "Two-Factor"},
+	)
+	if !s.authenticateState(wr, req, state) {
+		return
+	}
+	ctx := req.Context()
+	userID := state.Identity.ID
+	state.Notice = homeNotice(req)
+
+	if req.Method == http.MethodPost {
+		if !s.rateLimit(wr, req, LimitSecondFactor, userID) {
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			http.Error(wr, "Bad request", http.StatusBadRequest)
+			return
+		}
+		enabled := state.UserState.TOTPEnabled
+		code := req.PostForm.Get("code")
+
+		switch action := req.PostForm.Get("action"); {
+		case action == "setup" && !enabled:
+			// Start enrollment with a fresh secret
+			secret := totp.NewSecret()
+			if err := s.DB.SetPendingTOTP(ctx, userID, secret); err != nil {
+				log.Print("Failed to store TOTP secret: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			setup, err := s.newTOTPSetup(state.Identity.Username, secret)
+			if err != nil {
+				log.Print("Failed to render TOTP QR code: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			state.TOTPSetup = setup
+
+		case action == "enable" && !enabled:
+			// Confirm enrollment with a code from the authenticator app
+			secret, _, err := s.DB.GetTOTP(ctx, userID)
+			if err != nil {
+				log.Print("Failed to get TOTP secret: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if secret == nil {
+				http.Redirect(wr, req, "/totp", http.StatusSeeOther)
+				return
+			}
+			step, ok := totp.Validate(secret, strings.TrimSpace(code), time.Now())
+			if !ok {
+				setup, err := s.newTOTPSetup(state.Identity.Username, secret)
+				if err != nil {
+					log.Print("Failed to render TOTP QR code: ", err)
+					http.Error(wr, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				state.TOTPSetup = setup
+				state.Notice = "That code is not valid, please try again."
+				break
+			}
+			codes, hashes := newRecoveryCodes()
+			if err := s.DB.EnableTOTP(ctx, userID, step, hashes); err != nil {
+				log.Print("Failed to enable TOTP: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err := s.DB.Audit(ctx, userID, "totp.enable", ""); err != nil {
+				log.Print("Failed to write audit log: ", err)
+			}
+			s.stepUpSession(wr, state.claims)
+			state.UserState.TOTPEnabled = true
+			state.RecoveryCodes = codes
+			state.Notice = "Two-factor authentication is now set up."
+
+		case action == "recovery_codes" && enabled:
+			ok, err := s.verifySecondFactor(ctx, userID, code)
+			if err != nil {
+				log.Print("Failed to verify second factor: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				state.Notice = "That code is not valid, please try again."
+				break
+			}
+			codes, hashes := newRecoveryCodes()
+			if err := s.DB.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+				log.Print("Failed to replace recovery codes: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err := s.DB.Audit(ctx, userID, "totp.recovery_codes", ""); err != nil {
+				log.Print("Failed to write audit log: ", err)
+			}
+			state.RecoveryCodes = codes
+
+		case action == "disable" && enabled:
+			if state.UserState.IsAdmin {
+				http.Error(wr, "Admins cannot turn off two-factor authentication", http.StatusForbidden)
+				return
+			}
+			ok, err := s.verifySecondFactor(ctx, userID, code)
+			if err != nil {
+				log.Print("Failed to verify second factor: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				state.Notice = "That code is not valid, please try again."
+				break
+			}
+			if err := s.DB.DisableTOTP(ctx, userID); err != nil {
+				log.Print("Failed to disable TOTP: ", err)
+				http.Error(wr, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err := s.DB.Audit(ctx, userID, "totp.disable", ""); err != nil {
+				log.Print("Failed to write audit log: ", err)
+			}
+			http.Redirect(wr, req, "/totp?totp=disabled", http.StatusSeeOther)
+			return
+
+		default:
+			http.Error(wr, "Bad request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if state.UserState.TOTPEnabled {
+		left, err := s.DB.CountRecoveryCodes(ctx, userID)
+		if err != nil {
+			log.Print("Failed to count recovery codes: ", err)
+			http.Error(wr, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		state.RecoveryCodesLeft = left
+	}
+	if err := totpPage.Execute(wr, state); err != nil {
+		log.Print("failed to serve totp: ", err)
+	}
+}
+
+// handleTOTPVerify asks for a second factor before sensitive actions.
+func (s *Server) handleTOTPVerify(wr http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state := s.stateWithNav(
+		Nav{Route: "/", Name: "Ghidra"},
+		Nav{Route: "/totp", Name: "Two-Factor"},
+	)
+	if !s.authenticateState(wr, req, state) {
+		return
+	}
+	if !state.UserState.TOTPEnabled {
+		http.Redirect(wr, req, "/totp?totp=required", http.StatusSeeOther)
+		return
+	}
+	state.ReturnTo = safeReturnTo(req.URL.Query().Get("return_to"))
+
+	if req.Method == http.MethodPost {
+		if !s.rateLimit(wr, req, LimitSecondFactor, state.Identity.ID) {
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			http.Error(wr, "Bad request", http.StatusBadRequest)
+			return
+		}
+		ctx := req.Context()
+		state.ReturnTo = safeReturnTo(req.PostForm.Get("return_to"))
+		ok, err := s.verifySecondFactor(ctx, state.Identity.ID, req.PostForm.Get("code"))
+		if err != nil {
+			log.Print("Failed to verify second factor: ", err)
+			http.Error(wr, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			s.stepUpSession(wr, state.claims)
+			if err := s.DB.Audit(ctx, state.Identity.ID, "totp.step_up", ""); err != nil {
+				log.Print("Failed to write audit log: ", err)
+			}
+			http.Redirect(wr, req, orHome(state.ReturnTo), http.StatusSeeOther)
+			return
+		}
+		state.Notice = "That code is not valid, please try again."
+	}
+
+	if err := totpVerifyPage.Execute(wr, state); err != nil {
+		log.Print("failed to serve totp verify: ", err)
+	}
+}
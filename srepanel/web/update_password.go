@@ -1,10 +1,18 @@
 package web
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/passhash"
 )
 
+// busyRetryAfter is the Retry-After in seconds when password hashing is saturated.
+const busyRetryAfter = 5
+
 func (s *Server) handleUpdatePassword(wr http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
 		http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
@@ -16,6 +24,9 @@ func (s *Server) handleUpdatePassword(wr http.ResponseWriter, req *http.Request)
 		http.Error(wr, "Not authorized", http.StatusUnauthorized)
 		return
 	}
+	if !s.rateLimit(wr, req, LimitPassword, ident.ID) {
+		return
+	}
 
 	if err := req.ParseForm(); err != nil {
 		http.Error(wr, "Bad request", http.StatusBadRequest)
@@ -23,7 +34,14 @@ func (s *Server) handleUpdatePassword(wr http.ResponseWriter, req *http.Request)
 	}
 	pass := req.PostForm.Get("password")
 
-	if err := s.DB.SetPassword(req.Context(), ident.ID, ident.Username, pass); err != nil {
+	if err := s.setPassword(req.Context(), ident.ID, ident.Username, pass); errors.Is(err, passhash.ErrBusy) {
+		wr.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+		http.Error(wr, "The server is busy, please try again in a few seconds", http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, database.ErrUsernameTaken) {
+		http.Error(wr, "Your username belongs to another Ghidra account, please contact an admin", http.StatusConflict)
+		return
+	} else if err != nil {
 		log.Print("Failed to update password of user: ", err)
 		http.Error(wr, "Internal server error", http.StatusInternalServerError)
 		return
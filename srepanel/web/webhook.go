@@ -0,0 +1,56 @@
+package web
+
+import (
+	"context"
+	"fmt"
+
+	"go.mkw.re/ghidra-panel/common"
+	"go.mkw.re/ghidra-panel/database"
+	"go.mkw.re/ghidra-panel/discord"
+)
+
+// sendWebhook posts a message to the admin webhook.
+func (s *Server) sendWebhook(ctx context.Context, message *discord.WebhookMessage) error {
+	err := discord.SendWebhook(ctx, s.Config().DiscordWebhookURL, message)
+	if err != nil {
+		s.metrics.webhooks.Inc("failure")
+	} else {
+		s.metrics.webhooks.Inc("success")
+	}
+	return err
+}
+
+func avatarURL(ident *common.Identity) string {
+	if ident.AvatarURL != "" {
+		return ident.AvatarURL
+	}
+	if ident.AvatarHash == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://cdn.discordapp.com/avatars/%d/%s.png", ident.ID, ident.AvatarHash)
+}
+
+// writeRenameMessage notifies admins that a Ghidra account needs renaming.
+func (s *Server) writeRenameMessage(ident *common.Identity, change *database.ProfileChange) discord.WebhookMessage {
+	embed := discord.Embed{
+		Title: fmt.Sprintf("%s changed their username", ident.DisplayName()),
+		Color: 0xFDFD96,
+		Author: discord.EmbedAuthor{
+			Name:    ident.Username,
+			IconURL: avatarURL(ident),
+		},
+		Fields: []discord.EmbedField{
+			{Name: "Old Username", Value: change.OldUsername, Inline: true},
+			{Name: "New Username", Value: change.NewUsername, Inline: true},
+			{Name: "Ghidra Username", Value: change.GhidraUsername, Inline: true},
+			{
+				Name:  "Reconcile",
+				Value: fmt.Sprintf("`srepanel rename -user-id %d -user %s`", ident.ID, ident.Username),
+			},
+		},
+	}
+	return discord.WebhookMessage{
+		Username: "Panel",
+		Embeds:   []discord.Embed{embed},
+	}
+}